@@ -0,0 +1,409 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// unpackBits returns the first count bits of coil or input status data, LSB first
+func unpackBits(data []byte, count uint16) ([]bool, error) {
+	if len(data) < (int(count)+7)/8 {
+		return nil, fmt.Errorf("short response: %d bytes for %d bits", len(data), count)
+	}
+	bits := make([]bool, count)
+	for i := range bits {
+		bits[i] = data[i/8]&(1<<(i%8)) != 0
+	}
+	return bits, nil
+}
+
+// packBits packs bits into coil status data, LSB first
+func packBits(bits []bool) []byte {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, v := range bits {
+		if v {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
+
+// unpackRegisters returns the first count big-endian registers of data
+func unpackRegisters(data []byte, count uint16) ([]uint16, error) {
+	if len(data) < int(count)*2 {
+		return nil, fmt.Errorf("short response: %d bytes for %d registers", len(data), count)
+	}
+	registers := make([]uint16, count)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+	return registers, nil
+}
+
+// packRegisters encodes registers big-endian
+func packRegisters(registers []uint16) []byte {
+	data := make([]byte, 0, len(registers)*2)
+	for _, v := range registers {
+		data = binary.BigEndian.AppendUint16(data, v)
+	}
+	return data
+}
+
+// clientApi adapts a Client to ModbusApi. The client's slave id is set for every
+// request, so requests are serialized.
+type clientApi struct {
+	mu     sync.Mutex
+	client Client
+	logger io.Writer
+}
+
+// NewApiFromClient returns a ModbusApi on a Client, for typed []bool and []uint16
+// results on any client. Functions ModbusApi offers beyond Client, such as
+// ReadCustomData, are sent as raw PDUs through ReadWithCustomFunction.
+func NewApiFromClient(client Client) ModbusApi {
+	return &clientApi{client: client}
+}
+
+func (a *clientApi) GetType() string {
+	return a.client.GetHandlerType()
+}
+
+// SetLogger sets a writer for the errors of failed requests; the client logs its own
+// transport details
+func (a *clientApi) SetLogger(logger io.Writer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.logger = logger
+}
+
+// with runs fn with the client addressing slaveID
+func (a *clientApi) with(slaveID uint16, fn func(client Client) error) error {
+	if slaveID > 255 {
+		return fmt.Errorf("modbus: invalid slave id %d", slaveID)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.client.SetSlaveId(byte(slaveID))
+	err := fn(a.client)
+	if err != nil && a.logger != nil {
+		fmt.Fprintf(a.logger, "modbus: request to slave %d failed: %v\n", slaveID, err)
+	}
+	return err
+}
+
+func (a *clientApi) readBits(slaveID uint16, quantity uint16, read func(client Client) ([]byte, error)) ([]bool, error) {
+	var bits []bool
+	err := a.with(slaveID, func(client Client) error {
+		data, err := read(client)
+		if err != nil {
+			return err
+		}
+		bits, err = unpackBits(data, quantity)
+		return err
+	})
+	return bits, err
+}
+
+func (a *clientApi) readRegisters(slaveID uint16, quantity uint16, read func(client Client) ([]byte, error)) ([]uint16, error) {
+	var registers []uint16
+	err := a.with(slaveID, func(client Client) error {
+		data, err := read(client)
+		if err != nil {
+			return err
+		}
+		registers, err = unpackRegisters(data, quantity)
+		return err
+	})
+	return registers, err
+}
+
+func (a *clientApi) ReadCoils(slaveID uint16, startAddress, quantity uint16) ([]bool, error) {
+	return a.readBits(slaveID, quantity, func(client Client) ([]byte, error) {
+		return client.ReadCoils(startAddress, quantity)
+	})
+}
+
+func (a *clientApi) ReadDiscreteInputs(slaveID uint16, startAddress, quantity uint16) ([]bool, error) {
+	return a.readBits(slaveID, quantity, func(client Client) ([]byte, error) {
+		return client.ReadDiscreteInputs(startAddress, quantity)
+	})
+}
+
+func (a *clientApi) ReadHoldingRegisters(slaveID uint16, startAddress, quantity uint16) ([]uint16, error) {
+	return a.readRegisters(slaveID, quantity, func(client Client) ([]byte, error) {
+		return client.ReadHoldingRegisters(startAddress, quantity)
+	})
+}
+
+func (a *clientApi) ReadInputRegisters(slaveID uint16, startAddress, quantity uint16) ([]uint16, error) {
+	return a.readRegisters(slaveID, quantity, func(client Client) ([]byte, error) {
+		return client.ReadInputRegisters(startAddress, quantity)
+	})
+}
+
+func (a *clientApi) WriteSingleCoil(slaveID uint16, address uint16, value bool) error {
+	return a.with(slaveID, func(client Client) error {
+		coil := uint16(0x0000)
+		if value {
+			coil = 0xFF00
+		}
+		_, err := client.WriteSingleCoil(address, coil)
+		return err
+	})
+}
+
+func (a *clientApi) WriteSingleRegister(slaveID uint16, address, value uint16) error {
+	return a.with(slaveID, func(client Client) error {
+		_, err := client.WriteSingleRegister(address, value)
+		return err
+	})
+}
+
+func (a *clientApi) WriteMultipleCoils(slaveID uint16, startAddress uint16, values []bool) error {
+	return a.with(slaveID, func(client Client) error {
+		_, err := client.WriteMultipleCoils(startAddress, uint16(len(values)), packBits(values))
+		return err
+	})
+}
+
+func (a *clientApi) WriteMultipleRegisters(slaveID uint16, startAddress uint16, values []uint16) error {
+	return a.with(slaveID, func(client Client) error {
+		_, err := client.WriteMultipleRegisters(startAddress, uint16(len(values)), packRegisters(values))
+		return err
+	})
+}
+
+// ReadCustomData sends address and quantity with a custom function code and returns the
+// data after the byte count, like ModbusHandler
+func (a *clientApi) ReadCustomData(funcCode uint16, slaveID uint16, startAddress, quantity uint16) ([]byte, error) {
+	var data []byte
+	err := a.with(slaveID, func(client Client) error {
+		var err error
+		data, err = client.ReadWithCustomFunction(byte(funcCode), startAddress, quantity)
+		return err
+	})
+	return data, err
+}
+
+// WriteCustomData is not available on a Client, which has no generic write request
+func (a *clientApi) WriteCustomData(funcCode uint16, slaveID uint16, startAddress uint16, data []byte) error {
+	return fmt.Errorf("modbus: WriteCustomData is not supported on a Client")
+}
+
+// ReadRawDeviceIdentity is not available on a Client, which only sends FC 0x11
+// through SendRawBytes as a complete frame of its transport
+func (a *clientApi) ReadRawDeviceIdentity(slaveID uint16) ([]byte, error) {
+	return nil, fmt.Errorf("modbus: ReadRawDeviceIdentity is not supported on a Client")
+}
+
+func (a *clientApi) ReadDeviceIdentityWithHandler(slaveID uint16, handler func([]byte) error) error {
+	response, err := a.ReadRawDeviceIdentity(slaveID)
+	if err != nil {
+		return err
+	}
+	return handler(response)
+}
+
+// ScanSlaves is not available on a Client, see ReadRawDeviceIdentity
+func (a *clientApi) ScanSlaves(startID, endID uint16, callback func(slaveID uint16, rawResp []byte)) ([]uint16, error) {
+	return nil, fmt.Errorf("modbus: ScanSlaves is not supported on a Client")
+}
+
+// ReadWithMask reads a holding register and applies the masks locally, like ModbusHandler
+func (a *clientApi) ReadWithMask(slaveID uint16, readAddress, andMask, orMask uint16) (uint16, error) {
+	values, err := a.ReadHoldingRegisters(slaveID, readAddress, 1)
+	if err != nil {
+		return 0, err
+	}
+	return values[0]&andMask | orMask, nil
+}
+
+// apiClient adapts a ModbusApi to Client
+type apiClient struct {
+	mu      sync.Mutex
+	api     ModbusApi
+	slaveID byte
+}
+
+// NewClientFromApi returns a Client on a ModbusApi such as ModbusHandler, so the
+// register manager, group reader and poller work on top of it. Requests go to slaveID
+// until SetSlaveId. Requests are serialized, so concurrent group reads are safe. Functions
+// ModbusApi lacks, e.g. ReadWriteMultipleRegisters and ReadFIFOQueue, return an error.
+func NewClientFromApi(api ModbusApi, slaveID byte) Client {
+	return &apiClient{api: api, slaveID: slaveID}
+}
+
+// do runs fn with the slave id. The lock is held for the whole request, since
+// ModbusHandler does not serialize requests on its connection itself.
+func (c *apiClient) do(fn func(slave uint16) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fn(uint16(c.slaveID))
+}
+
+func (c *apiClient) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	err = c.do(func(slave uint16) error {
+		bits, err := c.api.ReadCoils(slave, address, quantity)
+		if err != nil {
+			return err
+		}
+		results = packBits(bits)
+		return nil
+	})
+	return results, err
+}
+
+func (c *apiClient) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	err = c.do(func(slave uint16) error {
+		bits, err := c.api.ReadDiscreteInputs(slave, address, quantity)
+		if err != nil {
+			return err
+		}
+		results = packBits(bits)
+		return nil
+	})
+	return results, err
+}
+
+func (c *apiClient) WriteSingleCoil(address, value uint16) ([]byte, error) {
+	if value != 0xFF00 && value != 0x0000 {
+		return nil, fmt.Errorf("modbus: state '%v' must be either 0xFF00 (ON) or 0x0000 (OFF)", value)
+	}
+	err := c.do(func(slave uint16) error {
+		return c.api.WriteSingleCoil(slave, address, value == 0xFF00)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataBlock(value), nil
+}
+
+func (c *apiClient) WriteMultipleCoils(address, quantity uint16, value []byte) ([]byte, error) {
+	bits, err := unpackBits(value, quantity)
+	if err != nil {
+		return nil, fmt.Errorf("modbus: %w", err)
+	}
+	err = c.do(func(slave uint16) error {
+		return c.api.WriteMultipleCoils(slave, address, bits)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataBlock(quantity), nil
+}
+
+func (c *apiClient) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	err = c.do(func(slave uint16) error {
+		registers, err := c.api.ReadInputRegisters(slave, address, quantity)
+		if err != nil {
+			return err
+		}
+		results = packRegisters(registers)
+		return nil
+	})
+	return results, err
+}
+
+func (c *apiClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	err = c.do(func(slave uint16) error {
+		registers, err := c.api.ReadHoldingRegisters(slave, address, quantity)
+		if err != nil {
+			return err
+		}
+		results = packRegisters(registers)
+		return nil
+	})
+	return results, err
+}
+
+func (c *apiClient) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	err := c.do(func(slave uint16) error {
+		return c.api.WriteSingleRegister(slave, address, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataBlock(value), nil
+}
+
+func (c *apiClient) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	registers, err := unpackRegisters(value, quantity)
+	if err != nil {
+		return nil, fmt.Errorf("modbus: %w", err)
+	}
+	err = c.do(func(slave uint16) error {
+		return c.api.WriteMultipleRegisters(slave, address, registers)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataBlock(quantity), nil
+}
+
+func (c *apiClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) ([]byte, error) {
+	return nil, fmt.Errorf("modbus: ReadWriteMultipleRegisters is not supported on a ModbusApi")
+}
+
+// MaskWriteRegister reads the register and writes it back masked; unlike FC 22 the
+// read and the write are separate requests
+func (c *apiClient) MaskWriteRegister(address, andMask, orMask uint16) ([]byte, error) {
+	err := c.do(func(slave uint16) error {
+		values, err := c.api.ReadHoldingRegisters(slave, address, 1)
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("modbus: no value returned for register %d", address)
+		}
+		// Result = (Current Contents AND And_Mask) OR (Or_Mask AND (NOT And_Mask))
+		return c.api.WriteSingleRegister(slave, address, values[0]&andMask|orMask&^andMask)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataBlock(andMask, orMask), nil
+}
+
+func (c *apiClient) ReadFIFOQueue(address uint16) ([]byte, error) {
+	return nil, fmt.Errorf("modbus: ReadFIFOQueue is not supported on a ModbusApi")
+}
+
+func (c *apiClient) ReadWithCustomFunction(code byte, address, quantity uint16) (results []byte, err error) {
+	err = c.do(func(slave uint16) error {
+		results, err = c.api.ReadCustomData(uint16(code), slave, address, quantity)
+		return err
+	})
+	return results, err
+}
+
+func (c *apiClient) ReadDeviceIdentification(firstExtendedID byte) (map[byte]string, error) {
+	return nil, fmt.Errorf("modbus: ReadDeviceIdentification is not supported on a ModbusApi")
+}
+
+func (c *apiClient) SendRawBytes(data []byte) ([]byte, error) {
+	return nil, fmt.Errorf("modbus: SendRawBytes is not supported on a ModbusApi")
+}
+
+func (c *apiClient) GetInterfaceName() string {
+	return "ModbusApi"
+}
+
+func (c *apiClient) SetSlaveId(slaveId byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slaveID = slaveId
+}
+
+func (c *apiClient) GetHandlerType() string {
+	return c.api.GetType()
+}
+
+// Close closes the ModbusApi if it has a Close method
+func (c *apiClient) Close() error {
+	if closer, ok := c.api.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
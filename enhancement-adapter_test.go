@@ -0,0 +1,184 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestApiFromClient(t *testing.T) {
+	client := newMemoryClient()
+	client.setRegisters(2, 10, 0x1234, 0xABCD)
+	api := NewApiFromClient(client)
+
+	if registers, err := api.ReadHoldingRegisters(2, 10, 2); err != nil || !reflect.DeepEqual(registers, []uint16{0x1234, 0xABCD}) {
+		t.Errorf("ReadHoldingRegisters = %04X, %v", registers, err)
+	}
+	if err := api.WriteMultipleCoils(2, 3, []bool{true, false, true, true, false, false, false, false, true}); err != nil {
+		t.Fatal(err)
+	}
+	if bits, err := api.ReadCoils(2, 3, 9); err != nil || !reflect.DeepEqual(bits, []bool{true, false, true, true, false, false, false, false, true}) {
+		t.Errorf("ReadCoils = %v, %v", bits, err)
+	}
+	if err := api.WriteSingleCoil(2, 4, true); err != nil || !client.coils[2][4] {
+		t.Errorf("WriteSingleCoil: %v", err)
+	}
+	if err := api.WriteMultipleRegisters(2, 20, []uint16{7, 8}); err != nil || client.register(2, 21) != 8 {
+		t.Errorf("WriteMultipleRegisters: %v", err)
+	}
+	if value, err := api.ReadWithMask(2, 10, 0x00FF, 0x0100); err != nil || value != 0x0134 {
+		t.Errorf("ReadWithMask = %04X, %v", value, err)
+	}
+	if _, err := api.ReadHoldingRegisters(256, 0, 1); err == nil {
+		t.Errorf("expected an error for slave id 256")
+	}
+	var log bytes.Buffer
+	api.SetLogger(&log)
+	client.readErr = errors.New("offline")
+	if _, err := api.ReadInputRegisters(2, 10, 1); err == nil || err.Error() != "offline" {
+		t.Errorf("expected the client error, got %v", err)
+	}
+	if !bytes.Contains(log.Bytes(), []byte("slave 2 failed: offline")) {
+		t.Errorf("logged %q", log.String())
+	}
+}
+
+func TestClientFromApi(t *testing.T) {
+	memory := newMemoryClient()
+	memory.setRegisters(1, 0, 0x00F0)
+	client := NewClientFromApi(NewApiFromClient(memory), 1)
+	if client.GetHandlerType() != "RTU" {
+		t.Errorf("handler type = %s", client.GetHandlerType())
+	}
+
+	// The register manager reads and writes through the adapter like through any client
+	manager := NewRegisterManager(client, 10)
+	if err := manager.LoadRegisters([]DeviceRegister{
+		{Tag: "setpoint", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16", Weight: 1},
+		{Tag: "relay", SlaverId: 1, Function: 1, ReadAddress: 5, ReadQuantity: 1, DataType: "bool"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if register, err := manager.ReadTag("setpoint"); err != nil || !bytes.Equal(register.Value, []byte{0x00, 0xF0}) {
+		t.Errorf("ReadTag(setpoint) = % X, %v", register.Value, err)
+	}
+	if err := manager.WriteTag("setpoint", 300); err != nil || memory.register(1, 0) != 300 {
+		t.Errorf("WriteTag(setpoint): %v", err)
+	}
+	if err := manager.WriteTag("relay", true); err != nil || !memory.coils[1][5] {
+		t.Errorf("WriteTag(relay): %v", err)
+	}
+
+	client.SetSlaveId(3)
+	if results, err := client.WriteMultipleRegisters(4, 2, []byte{0x00, 0x01, 0x00, 0x02}); err != nil || !bytes.Equal(results, []byte{0x00, 0x02}) {
+		t.Errorf("WriteMultipleRegisters = % X, %v", results, err)
+	}
+	if results, err := client.ReadHoldingRegisters(4, 2); err != nil || !bytes.Equal(results, []byte{0x00, 0x01, 0x00, 0x02}) {
+		t.Errorf("ReadHoldingRegisters = % X, %v", results, err)
+	}
+	if _, err := client.MaskWriteRegister(4, 0xFFF0, 0x000A); err != nil || memory.register(3, 4) != 0x000A {
+		t.Errorf("MaskWriteRegister: %04X, %v", memory.register(3, 4), err)
+	}
+	if _, err := client.WriteSingleCoil(0, 0x1234); err == nil {
+		t.Errorf("expected an error for an invalid coil state")
+	}
+	if _, err := client.ReadFIFOQueue(0); err == nil {
+		t.Errorf("expected ReadFIFOQueue to be unsupported")
+	}
+}
+
+func TestClientFromModbusHandler(t *testing.T) {
+	slaves := &testRTUSlaves{registers: map[uint8][]uint16{1: {10, 20, 30}}}
+	gateway := NewTCPRTUGateway()
+	gateway.Route(newTestRTUBus(t, slaves), 1)
+	conn, err := net.Dial("tcp", startTestGateway(t, gateway))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClientFromApi(NewModbusTCPHandler(conn, 2*time.Second), 1)
+
+	if results, err := client.ReadHoldingRegisters(1, 2); err != nil || !bytes.Equal(results, []byte{0x00, 20, 0x00, 30}) {
+		t.Errorf("ReadHoldingRegisters = % X, %v", results, err)
+	}
+	if _, err := client.WriteMultipleRegisters(0, 2, []byte{0x01, 0x00, 0x02, 0x00}); err != nil || slaves.registers[1][1] != 0x0200 {
+		t.Errorf("WriteMultipleRegisters: %v", err)
+	}
+
+	// Exceptions keep their code through the handler and the adapter
+	var mbErr *ModbusError
+	if _, err := client.ReadHoldingRegisters(2, 5); !errors.As(err, &mbErr) || mbErr.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Errorf("expected illegal data address, got %v", err)
+	}
+	manager := NewRegisterManager(client, 10)
+	if err := manager.LoadRegisters([]DeviceRegister{
+		{Tag: "missing", SlaverId: 1, Function: 3, ReadAddress: 5, ReadQuantity: 1, DataType: "uint16", Weight: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	register, _ := manager.ReadTag("missing")
+	if register.QualityReason != ReasonException || register.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Errorf("quality reason %q, exception code %d", register.QualityReason, register.ExceptionCode)
+	}
+}
+
+// overlapApi counts holding register reads that overlap, which would interleave
+// frames on a ModbusHandler connection
+type overlapApi struct {
+	ModbusApi
+	inFlight atomic.Int32
+	overlaps atomic.Int32
+}
+
+func (a *overlapApi) ReadHoldingRegisters(slaveID uint16, startAddress, quantity uint16) ([]uint16, error) {
+	if a.inFlight.Add(1) > 1 {
+		a.overlaps.Add(1)
+	}
+	defer a.inFlight.Add(-1)
+	time.Sleep(time.Millisecond)
+	return a.ModbusApi.ReadHoldingRegisters(slaveID, startAddress, quantity)
+}
+
+func TestClientFromApiConcurrent(t *testing.T) {
+	memory := newMemoryClient()
+	memory.setRegisters(1, 0, 1, 2, 3, 4, 5, 6, 7, 8)
+	api := &overlapApi{ModbusApi: NewApiFromClient(memory)}
+	client := NewClientFromApi(api, 1)
+
+	var wg sync.WaitGroup
+	for i := uint16(0); i < 8; i++ {
+		wg.Add(1)
+		go func(address uint16) {
+			defer wg.Done()
+			if results, err := client.ReadHoldingRegisters(address, 1); err != nil || !bytes.Equal(results, []byte{0x00, byte(address + 1)}) {
+				t.Errorf("read %d = % X, %v", address, results, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := api.overlaps.Load(); n != 0 {
+		t.Errorf("%d requests overlapped", n)
+	}
+}
+
+func TestPackBits(t *testing.T) {
+	bits := []bool{true, false, false, true, false, false, false, false, false, true}
+	packed := packBits(bits)
+	if !bytes.Equal(packed, []byte{0x09, 0x02}) {
+		t.Errorf("packBits = % X", packed)
+	}
+	if unpacked, err := unpackBits(packed, 10); err != nil || !reflect.DeepEqual(unpacked, bits) {
+		t.Errorf("unpackBits = %v, %v", unpacked, err)
+	}
+	if _, err := unpackBits(packed, 17); err == nil {
+		t.Errorf("expected a short response error")
+	}
+	if _, err := unpackRegisters([]byte{0x00}, 1); err == nil {
+		t.Errorf("expected a short response error")
+	}
+}
@@ -0,0 +1,300 @@
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Alarm rule types supported by AlarmRule.Type
+const (
+	AlarmHighHigh     = "hihi" // Value at or above Limit
+	AlarmHigh         = "hi"   // Value at or above Limit
+	AlarmLow          = "lo"   // Value at or below Limit
+	AlarmLowLow       = "lolo" // Value at or below Limit
+	AlarmRateOfChange = "roc"  // Absolute change per second above Limit
+	AlarmBitSet       = "bit"  // Bit of the raw value, or named bit field, is set
+)
+
+// AlarmState is the state of an alarm
+type AlarmState string
+
+const (
+	AlarmStateNormal       AlarmState = "normal"       // Condition inactive and acknowledged
+	AlarmStateActive       AlarmState = "active"       // Condition active, not acknowledged
+	AlarmStateAcknowledged AlarmState = "acknowledged" // Condition active and acknowledged
+	AlarmStateCleared      AlarmState = "cleared"      // Condition returned to normal, not acknowledged
+)
+
+// Alarm event types reported by AlarmEvent.Event
+const (
+	AlarmEventActivated    = "activated"
+	AlarmEventCleared      = "cleared"
+	AlarmEventAcknowledged = "acknowledged"
+)
+
+// AlarmRule defines an alarm on a tag.
+type AlarmRule struct {
+	Name       string        `json:"name"`                 // Unique name of the alarm, defaults to "<tag>:<type>"
+	Tag        string        `json:"tag"`                  // Tag the alarm is evaluated on
+	Type       string        `json:"type"`                 // One of the Alarm* rule types
+	Limit      float64       `json:"limit"`                // Limit for hi/lo alarms, change per second for roc
+	Bit        uint16        `json:"bit,omitempty"`        // Bit of the raw value for bit alarms
+	Flag       string        `json:"flag,omitempty"`       // Named bit field for bit alarms, used instead of Bit
+	Hysteresis float64       `json:"hysteresis,omitempty"` // Distance from Limit the value must return by to clear
+	OnDelay    time.Duration `json:"onDelay,omitempty"`    // Time the condition must persist before activating
+	OffDelay   time.Duration `json:"offDelay,omitempty"`   // Time the condition must be gone before clearing
+	Severity   int           `json:"severity,omitempty"`   // Free severity for consumers
+	Message    string        `json:"message,omitempty"`    // Free text for consumers
+}
+
+// AlarmEvent reports a state transition of an alarm
+type AlarmEvent struct {
+	Rule  AlarmRule  `json:"rule"`
+	Event string     `json:"event"` // One of the AlarmEvent* constants
+	State AlarmState `json:"state"` // State after the transition
+	Value float64    `json:"value"` // Last evaluated value (rate for roc alarms)
+	Time  time.Time  `json:"time"`
+}
+
+// AlarmStatus is the current state of an alarm
+type AlarmStatus struct {
+	Rule        AlarmRule  `json:"rule"`
+	State       AlarmState `json:"state"`
+	Value       float64    `json:"value"`       // Last evaluated value (rate for roc alarms)
+	ActiveSince time.Time  `json:"activeSince"` // Time of the last activation, zero if never active
+}
+
+// alarm is the runtime state of a rule
+type alarm struct {
+	rule        AlarmRule
+	state       AlarmState
+	value       float64
+	pending     time.Time // Since when the condition disagrees with the state, zero if it agrees
+	activeSince time.Time
+	lastValue   float64 // Previous sample for roc alarms
+	lastTime    time.Time
+}
+
+// active reports whether the alarm condition is considered active
+func (a *alarm) active() bool {
+	return a.state == AlarmStateActive || a.state == AlarmStateAcknowledged
+}
+
+// AlarmEngine evaluates alarm rules on polled registers and tracks the
+// active/acknowledged/cleared state of every alarm.
+type AlarmEngine struct {
+	mu      sync.Mutex
+	alarms  []*alarm
+	byName  map[string]*alarm
+	onEvent func(event AlarmEvent)
+	now     func() time.Time
+}
+
+// NewAlarmEngine creates an AlarmEngine for the given rules.
+func NewAlarmEngine(rules []AlarmRule) (*AlarmEngine, error) {
+	e := &AlarmEngine{byName: make(map[string]*alarm), now: time.Now}
+	for _, rule := range rules {
+		if rule.Tag == "" {
+			return nil, fmt.Errorf("alarm rule without tag")
+		}
+		switch rule.Type {
+		case AlarmHighHigh, AlarmHigh, AlarmLow, AlarmLowLow, AlarmRateOfChange, AlarmBitSet:
+		default:
+			return nil, fmt.Errorf("alarm on %s: unsupported type: %s", rule.Tag, rule.Type)
+		}
+		if rule.Hysteresis < 0 || rule.OnDelay < 0 || rule.OffDelay < 0 {
+			return nil, fmt.Errorf("alarm on %s: negative hysteresis or delay", rule.Tag)
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Tag + ":" + rule.Type
+		}
+		if e.byName[rule.Name] != nil {
+			return nil, fmt.Errorf("duplicate alarm: %s", rule.Name)
+		}
+		a := &alarm{rule: rule, state: AlarmStateNormal}
+		e.alarms = append(e.alarms, a)
+		e.byName[rule.Name] = a
+	}
+	return e, nil
+}
+
+// SetOnEvent sets the callback receiving alarm transitions. It is called from
+// Evaluate and Acknowledge, after the engine lock is released.
+func (e *AlarmEngine) SetOnEvent(callback func(event AlarmEvent)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onEvent = callback
+}
+
+// EvaluateGroups evaluates the alarms on every group of a read cycle
+func (e *AlarmEngine) EvaluateGroups(groups [][]DeviceRegister) []AlarmEvent {
+	var events []AlarmEvent
+	for _, group := range groups {
+		events = append(events, e.Evaluate(group)...)
+	}
+	return events
+}
+
+// Evaluate evaluates the alarms on the given registers and returns the resulting
+// transitions. Registers with bad quality are ignored, leaving their alarms unchanged.
+func (e *AlarmEngine) Evaluate(registers []DeviceRegister) []AlarmEvent {
+	e.mu.Lock()
+	now := e.now()
+	var events []AlarmEvent
+	for _, reg := range registers {
+		decoded, err := reg.DecodeValue()
+		if err != nil || decoded.Quality == QualityBad {
+			continue
+		}
+		sampled := reg.SourceTime
+		if sampled.IsZero() {
+			sampled = now
+		}
+		for _, a := range e.alarms {
+			if a.rule.Tag != reg.Tag {
+				continue
+			}
+			value, ok := a.sample(decoded, sampled)
+			if !ok {
+				continue
+			}
+			a.value = value
+			if event, changed := a.update(a.condition(value), now); changed {
+				events = append(events, event)
+			}
+		}
+	}
+	callback := e.onEvent
+	e.mu.Unlock()
+	if callback != nil {
+		for _, event := range events {
+			callback(event)
+		}
+	}
+	return events
+}
+
+// Acknowledge acknowledges an alarm by name. Acknowledging an active alarm moves it to
+// acknowledged, acknowledging a cleared alarm returns it to normal.
+func (e *AlarmEngine) Acknowledge(name string) error {
+	e.mu.Lock()
+	a := e.byName[name]
+	if a == nil {
+		e.mu.Unlock()
+		return fmt.Errorf("unknown alarm: %s", name)
+	}
+	switch a.state {
+	case AlarmStateActive:
+		a.state = AlarmStateAcknowledged
+	case AlarmStateCleared:
+		a.state = AlarmStateNormal
+	default:
+		e.mu.Unlock()
+		return nil
+	}
+	event := a.event(AlarmEventAcknowledged, e.now())
+	callback := e.onEvent
+	e.mu.Unlock()
+	if callback != nil {
+		callback(event)
+	}
+	return nil
+}
+
+// Alarms returns the state of every alarm sorted by name
+func (e *AlarmEngine) Alarms() []AlarmStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	statuses := make([]AlarmStatus, 0, len(e.alarms))
+	for _, a := range e.alarms {
+		statuses = append(statuses, AlarmStatus{Rule: a.rule, State: a.state, Value: a.value, ActiveSince: a.activeSince})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Rule.Name < statuses[j].Rule.Name })
+	return statuses
+}
+
+// sample returns the value the alarm condition is evaluated on. Rate-of-change alarms
+// need two samples and report false for the first one.
+func (a *alarm) sample(decoded DecodedValue, sampled time.Time) (float64, bool) {
+	switch a.rule.Type {
+	case AlarmBitSet:
+		if a.rule.Flag != "" {
+			flag, ok := decoded.Flags[a.rule.Flag]
+			return float64(flag), ok
+		}
+		_, bits, ok := integerBits(decoded.AsType)
+		if !ok || a.rule.Bit >= 64 {
+			return 0, false
+		}
+		return float64(bits >> a.rule.Bit & 1), true
+	case AlarmRateOfChange:
+		previous, previousTime := a.lastValue, a.lastTime
+		a.lastValue, a.lastTime = decoded.Float64, sampled
+		dt := sampled.Sub(previousTime).Seconds()
+		if previousTime.IsZero() || dt <= 0 {
+			return 0, false
+		}
+		return math.Abs(decoded.Float64-previous) / dt, true
+	default:
+		return decoded.Float64, true
+	}
+}
+
+// condition reports whether the alarm condition holds for value, applying the
+// hysteresis when the alarm is already active.
+func (a *alarm) condition(value float64) bool {
+	hysteresis := 0.0
+	if a.active() {
+		hysteresis = a.rule.Hysteresis
+	}
+	switch a.rule.Type {
+	case AlarmHighHigh, AlarmHigh:
+		return value >= a.rule.Limit-hysteresis
+	case AlarmLow, AlarmLowLow:
+		return value <= a.rule.Limit+hysteresis
+	case AlarmRateOfChange:
+		return value > a.rule.Limit-hysteresis
+	case AlarmBitSet:
+		return value != 0
+	}
+	return false
+}
+
+// update moves the alarm state machine after the condition has disagreed with the
+// state for the on or off delay.
+func (a *alarm) update(condition bool, now time.Time) (AlarmEvent, bool) {
+	if condition == a.active() {
+		a.pending = time.Time{}
+		return AlarmEvent{}, false
+	}
+	if a.pending.IsZero() {
+		a.pending = now
+	}
+	delay := a.rule.OffDelay
+	if condition {
+		delay = a.rule.OnDelay
+	}
+	if now.Sub(a.pending) < delay {
+		return AlarmEvent{}, false
+	}
+	a.pending = time.Time{}
+
+	if condition {
+		a.state = AlarmStateActive
+		a.activeSince = now
+		return a.event(AlarmEventActivated, now), true
+	}
+	if a.state == AlarmStateAcknowledged {
+		a.state = AlarmStateNormal
+	} else {
+		a.state = AlarmStateCleared
+	}
+	return a.event(AlarmEventCleared, now), true
+}
+
+func (a *alarm) event(name string, now time.Time) AlarmEvent {
+	return AlarmEvent{Rule: a.rule, Event: name, State: a.state, Value: a.value, Time: now}
+}
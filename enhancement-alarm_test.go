@@ -0,0 +1,159 @@
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+// alarmSample builds a good int16 register sampled at the given time
+func alarmSample(tag string, value int16, sampled time.Time) DeviceRegister {
+	reg := DeviceRegister{Tag: tag, DataType: "int16", Weight: 1, Value: []byte{byte(uint16(value) >> 8), byte(value)}}
+	reg.setGood(sampled, sampled)
+	return reg
+}
+
+func TestAlarmEngineLimits(t *testing.T) {
+	engine, err := NewAlarmEngine([]AlarmRule{
+		{Tag: "temperature", Type: AlarmHigh, Limit: 80, Hysteresis: 5, OnDelay: 10 * time.Second},
+		{Tag: "temperature", Type: AlarmHighHigh, Limit: 95},
+		{Tag: "temperature", Type: AlarmLow, Limit: 5, OffDelay: 10 * time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return clock }
+	var received []AlarmEvent
+	engine.SetOnEvent(func(event AlarmEvent) { received = append(received, event) })
+
+	step := func(value int16, advance time.Duration) []AlarmEvent {
+		clock = clock.Add(advance)
+		return engine.Evaluate([]DeviceRegister{alarmSample("temperature", value, clock)})
+	}
+	expect := func(events []AlarmEvent, name, event string, state AlarmState) {
+		t.Helper()
+		if len(events) != 1 || events[0].Rule.Name != name || events[0].Event != event || events[0].State != state {
+			t.Fatalf("events = %+v, expected %s %s -> %s", events, name, event, state)
+		}
+	}
+
+	if events := step(50, 0); len(events) != 0 {
+		t.Fatalf("unexpected events in normal range: %+v", events)
+	}
+	// The on delay holds back the high alarm
+	if events := step(85, time.Second); len(events) != 0 {
+		t.Fatalf("high alarm activated before its on delay: %+v", events)
+	}
+	expect(step(86, 10*time.Second), "temperature:hi", AlarmEventActivated, AlarmStateActive)
+	// Hysteresis keeps the alarm active between 75 and 80
+	if events := step(77, time.Second); len(events) != 0 {
+		t.Fatalf("high alarm cleared inside its hysteresis: %+v", events)
+	}
+	expect(step(74, time.Second), "temperature:hi", AlarmEventCleared, AlarmStateCleared)
+
+	// An unacknowledged cleared alarm returns to normal on acknowledge
+	if err := engine.Acknowledge("temperature:hi"); err != nil {
+		t.Fatal(err)
+	}
+	if received[len(received)-1].Event != AlarmEventAcknowledged || received[len(received)-1].State != AlarmStateNormal {
+		t.Errorf("last event = %+v, expected acknowledged -> normal", received[len(received)-1])
+	}
+
+	// Acknowledged while active, the alarm returns to normal directly when cleared
+	expect(step(99, time.Second), "temperature:hihi", AlarmEventActivated, AlarmStateActive)
+	if err := engine.Acknowledge("temperature:hihi"); err != nil {
+		t.Fatal(err)
+	}
+	expect(step(60, time.Second), "temperature:hihi", AlarmEventCleared, AlarmStateNormal)
+
+	// The off delay holds the low alarm active
+	expect(step(2, time.Second), "temperature:lo", AlarmEventActivated, AlarmStateActive)
+	if events := step(20, time.Second); len(events) != 0 {
+		t.Fatalf("low alarm cleared before its off delay: %+v", events)
+	}
+	expect(step(20, 10*time.Second), "temperature:lo", AlarmEventCleared, AlarmStateCleared)
+
+	// Bad quality samples are ignored
+	bad := alarmSample("temperature", 100, clock)
+	bad.setBad(ReasonTimeout, 0, "timeout", clock)
+	if events := engine.Evaluate([]DeviceRegister{bad}); len(events) != 0 {
+		t.Errorf("bad sample raised events: %+v", events)
+	}
+
+	statuses := engine.Alarms()
+	if len(statuses) != 3 || statuses[0].Rule.Name != "temperature:hi" || statuses[1].State != AlarmStateNormal || statuses[2].State != AlarmStateCleared {
+		t.Errorf("Alarms() = %+v", statuses)
+	}
+	if err := engine.Acknowledge("missing"); err == nil {
+		t.Errorf("expected error acknowledging an unknown alarm")
+	}
+}
+
+func TestAlarmEngineRateAndBits(t *testing.T) {
+	engine, err := NewAlarmEngine([]AlarmRule{
+		{Name: "pressure rising", Tag: "pressure", Type: AlarmRateOfChange, Limit: 2},
+		{Tag: "status", Type: AlarmBitSet, Bit: 3},
+		{Name: "door", Tag: "status", Type: AlarmBitSet, Flag: "door"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if events := engine.Evaluate([]DeviceRegister{alarmSample("pressure", 10, clock)}); len(events) != 0 {
+		t.Fatalf("rate alarm needs two samples: %+v", events)
+	}
+	clock = clock.Add(2 * time.Second)
+	if events := engine.Evaluate([]DeviceRegister{alarmSample("pressure", 13, clock)}); len(events) != 0 {
+		t.Fatalf("1.5/s must not trip a 2/s rate alarm: %+v", events)
+	}
+	clock = clock.Add(2 * time.Second)
+	events := engine.Evaluate([]DeviceRegister{alarmSample("pressure", 20, clock)})
+	if len(events) != 1 || events[0].Rule.Name != "pressure rising" || events[0].Value != 3.5 {
+		t.Fatalf("events = %+v, expected rate alarm at 3.5/s", events)
+	}
+
+	status := alarmSample("status", 0x0018, clock)
+	status.BitFields = []BitField{{Name: "door", Bit: 4}}
+	events = engine.Evaluate([]DeviceRegister{status})
+	if len(events) != 2 {
+		t.Fatalf("events = %+v, expected bit 3 and door alarms", events)
+	}
+
+	if _, err := NewAlarmEngine([]AlarmRule{{Tag: "x", Type: "unknown"}}); err == nil {
+		t.Errorf("expected error for an unknown alarm type")
+	}
+	if _, err := NewAlarmEngine([]AlarmRule{{Tag: "x", Type: AlarmHigh}, {Tag: "x", Type: AlarmHigh}}); err == nil {
+		t.Errorf("expected error for duplicate alarm names")
+	}
+}
+
+func TestRegisterManagerAlarms(t *testing.T) {
+	client := newMemoryClient()
+	client.setRegisters(1, 0, 2300, 25)
+	manager := NewRegisterManager(client, 10)
+	registers := []DeviceRegister{
+		{Tag: "voltage", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16", Weight: 0.1},
+		{Tag: "current", SlaverId: 1, Function: 3, ReadAddress: 1, ReadQuantity: 1, DataType: "uint16", Weight: 0.1},
+	}
+	if err := manager.LoadRegisters(registers); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetVirtualTags([]VirtualTag{{Tag: "power", Expression: "voltage * current"}}); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewAlarmEngine([]AlarmRule{{Tag: "power", Type: AlarmHigh, Limit: 500}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []AlarmEvent
+	engine.SetOnEvent(func(event AlarmEvent) { events = append(events, event) })
+	manager.SetAlarmEngine(engine)
+
+	if errs := manager.ReadGroupedData(); len(errs) > 0 {
+		t.Fatalf("ReadGroupedData failed: %v", errs)
+	}
+	if len(events) != 1 || events[0].Event != AlarmEventActivated || !FuzzyEqual(events[0].Value, 575) {
+		t.Errorf("events = %+v, expected power high alarm at 575", events)
+	}
+}
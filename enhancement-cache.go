@@ -0,0 +1,159 @@
+package modbus
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TagValue is the last known value of a tag as held by a TagCache
+type TagValue struct {
+	Register      DeviceRegister `json:"register"`                // Register as last read
+	Value         DecodedValue   `json:"value"`                   // Decoded last value
+	Quality       Quality        `json:"quality"`                 // Quality including staleness
+	QualityReason string         `json:"qualityReason,omitempty"` // Reason code when Quality is not good
+	Age           time.Duration  `json:"age"`                     // Time since the value was sampled, 0 if never read
+}
+
+// TagCache is a thread-safe last-known-value cache fed by register reads. Values are
+// looked up by tag, alias or UUID; good values older than the staleness threshold are
+// reported with uncertain quality and reason "stale".
+type TagCache struct {
+	mu         sync.RWMutex
+	registers  map[string]DeviceRegister // Last read registers by tag
+	aliases    map[string]string         // Alias to tag
+	uuids      map[string]string         // UUID to tag
+	staleAfter time.Duration             // Default staleness threshold, 0 disables
+	tagStale   map[string]time.Duration  // Per-tag staleness thresholds
+	now        func() time.Time
+}
+
+// NewTagCache creates a TagCache with the default staleness threshold. A threshold of
+// zero disables staleness checks.
+func NewTagCache(staleAfter time.Duration) *TagCache {
+	return &TagCache{
+		registers:  make(map[string]DeviceRegister),
+		aliases:    make(map[string]string),
+		uuids:      make(map[string]string),
+		staleAfter: staleAfter,
+		tagStale:   make(map[string]time.Duration),
+		now:        time.Now,
+	}
+}
+
+// SetStaleAfter sets the staleness threshold of a single tag, overriding the default.
+func (c *TagCache) SetStaleAfter(tag string, staleAfter time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tagStale[tag] = staleAfter
+}
+
+// Update stores the registers of a read cycle. The raw values are copied, so the
+// registers may be reused by the caller.
+func (c *TagCache) Update(registers []DeviceRegister) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, reg := range registers {
+		reg.Value = append([]byte(nil), reg.Value...)
+		c.registers[reg.Tag] = reg
+		if reg.Alias != "" {
+			c.aliases[reg.Alias] = reg.Tag
+		}
+		if reg.UUID != "" {
+			c.uuids[reg.UUID] = reg.Tag
+		}
+	}
+}
+
+// UpdateGroups stores every group of a read cycle
+func (c *TagCache) UpdateGroups(groups [][]DeviceRegister) {
+	for _, group := range groups {
+		c.Update(group)
+	}
+}
+
+// Get returns the last value of a tag
+func (c *TagCache) Get(tag string) (TagValue, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.value(tag)
+}
+
+// GetByAlias returns the last value of the tag with the given alias
+func (c *TagCache) GetByAlias(alias string) (TagValue, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.value(c.aliases[alias])
+}
+
+// GetByUUID returns the last value of the tag with the given UUID
+func (c *TagCache) GetByUUID(uuid string) (TagValue, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.value(c.uuids[uuid])
+}
+
+// Lookup returns the last value of a tag, trying the key as tag, alias and UUID in turn
+func (c *TagCache) Lookup(key string) (TagValue, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if v, ok := c.value(key); ok {
+		return v, true
+	}
+	if v, ok := c.value(c.aliases[key]); ok {
+		return v, true
+	}
+	return c.value(c.uuids[key])
+}
+
+// Snapshot returns the last values of all tags sorted by tag
+func (c *TagCache) Snapshot() []TagValue {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	tags := make([]string, 0, len(c.registers))
+	for tag := range c.registers {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	values := make([]TagValue, 0, len(tags))
+	for _, tag := range tags {
+		v, _ := c.value(tag)
+		values = append(values, v)
+	}
+	return values
+}
+
+// Tags returns the cached tags sorted by name
+func (c *TagCache) Tags() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	tags := make([]string, 0, len(c.registers))
+	for tag := range c.registers {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// value builds the TagValue of a tag. Caller must hold the read lock.
+func (c *TagCache) value(tag string) (TagValue, bool) {
+	reg, ok := c.registers[tag]
+	if !ok {
+		return TagValue{}, false
+	}
+	staleAfter, found := c.tagStale[tag]
+	if !found {
+		staleAfter = c.staleAfter
+	}
+	now := c.now()
+	decoded, _ := reg.DecodeValue()
+	v := TagValue{Register: reg, Value: decoded}
+	v.Quality, v.QualityReason = reg.QualityAt(now, staleAfter)
+	if decoded.Quality == QualityBad {
+		v.Quality, v.QualityReason = decoded.Quality, decoded.QualityReason
+	}
+	if !reg.SourceTime.IsZero() {
+		v.Age = now.Sub(reg.SourceTime)
+	}
+	return v, true
+}
@@ -0,0 +1,80 @@
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTagCache(t *testing.T) {
+	client := newMemoryClient()
+	client.setRegisters(1, 0, 215, 1)
+	manager := NewRegisterManager(client, 10)
+	registers := []DeviceRegister{
+		{UUID: "u-1", Tag: "temperature", Alias: "Boiler temperature", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16", Weight: 0.1},
+		{UUID: "u-2", Tag: "state", SlaverId: 1, Function: 3, ReadAddress: 1, ReadQuantity: 1, DataType: "uint16", Weight: 1},
+	}
+	if err := manager.LoadRegisters(registers); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewTagCache(10 * time.Second)
+	cache.SetStaleAfter("state", time.Minute)
+	manager.SetTagCache(cache)
+	manager.SetChangeFilter(ChangeFilter{OnChangeOnly: true})
+	manager.Start()
+	defer manager.Stop()
+
+	if _, ok := cache.Get("temperature"); ok {
+		t.Fatal("cache must be empty before the first read")
+	}
+	if errs := manager.ReadGroupedData(); len(errs) > 0 {
+		t.Fatalf("ReadGroupedData failed: %v", errs)
+	}
+	// An unchanged second cycle is filtered from the stream but still refreshes the cache
+	if errs := manager.ReadGroupedData(); len(errs) > 0 {
+		t.Fatalf("ReadGroupedData failed: %v", errs)
+	}
+
+	for _, key := range []string{"temperature", "Boiler temperature", "u-1"} {
+		v, ok := cache.Lookup(key)
+		if !ok {
+			t.Fatalf("Lookup(%q) found nothing", key)
+		}
+		if v.Register.Tag != "temperature" || !FuzzyEqual(v.Value.Float64, 21.5) || v.Quality != QualityGood {
+			t.Errorf("Lookup(%q) = %s %v %s, expected temperature 21.5 good", key, v.Register.Tag, v.Value.Float64, v.Quality)
+		}
+	}
+	if v, ok := cache.GetByAlias("Boiler temperature"); !ok || v.Register.UUID != "u-1" {
+		t.Errorf("GetByAlias failed: %+v", v)
+	}
+	if _, ok := cache.GetByUUID("unknown"); ok {
+		t.Errorf("GetByUUID found an unknown UUID")
+	}
+
+	// Age the values: the default threshold makes temperature stale, state has a longer one
+	sampled := time.Now()
+	cache.now = func() time.Time { return sampled.Add(30 * time.Second) }
+	temperature, _ := cache.Get("temperature")
+	if temperature.Quality != QualityUncertain || temperature.QualityReason != ReasonStale {
+		t.Errorf("temperature quality = %s/%q, expected uncertain/stale", temperature.Quality, temperature.QualityReason)
+	}
+	if temperature.Age < 29*time.Second {
+		t.Errorf("temperature age = %v, expected about 30s", temperature.Age)
+	}
+	state, _ := cache.Get("state")
+	if state.Quality != QualityGood {
+		t.Errorf("state quality = %s, expected good with its own threshold", state.Quality)
+	}
+
+	// A failed read keeps the last value with bad quality
+	client.readErr = &ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeServerDeviceFailure}
+	manager.ReadGroupedData()
+	state, _ = cache.Get("state")
+	if state.Quality != QualityBad || state.QualityReason != ReasonException || state.Value.Float64 != 1 {
+		t.Errorf("state = %v %s/%q, expected last value 1 with bad exception quality", state.Value.Float64, state.Quality, state.QualityReason)
+	}
+
+	snapshot := cache.Snapshot()
+	if len(snapshot) != 2 || snapshot[0].Register.Tag != "state" || snapshot[1].Register.Tag != "temperature" {
+		t.Errorf("Snapshot returned %d values, expected state and temperature", len(snapshot))
+	}
+}
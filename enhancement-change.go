@@ -0,0 +1,128 @@
+package modbus
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"time"
+)
+
+// ChangeFilter configures report-by-exception delivery of polled registers.
+type ChangeFilter struct {
+	OnChangeOnly      bool          // Deliver only registers that changed beyond their deadband
+	IntegrityInterval time.Duration // Deliver every register at this interval even if unchanged, 0 disables
+}
+
+// TagChange describes a register delivered by a ChangeDetector.
+type TagChange struct {
+	Register  DeviceRegister // Register as read in this cycle
+	Value     DecodedValue   // Decoded current value
+	Previous  *DecodedValue  // Last delivered value, nil on first delivery
+	Integrity bool           // True when delivered by the integrity interval rather than a change
+}
+
+// reportedValue is the last value delivered for a tag
+type reportedValue struct {
+	value  DecodedValue
+	raw    []byte
+	status string
+}
+
+// ChangeDetector filters polled register groups down to the registers whose value
+// changed beyond their deadband since the last delivery. Registers configure their
+// deadband with DeviceRegister.Deadband (absolute) and DeadbandPercent.
+type ChangeDetector struct {
+	mu            sync.Mutex
+	filter        ChangeFilter
+	last          map[string]reportedValue
+	lastIntegrity time.Time
+	now           func() time.Time
+}
+
+// NewChangeDetector creates a ChangeDetector with the given filter.
+func NewChangeDetector(filter ChangeFilter) *ChangeDetector {
+	return &ChangeDetector{
+		filter: filter,
+		last:   make(map[string]reportedValue),
+		now:    time.Now,
+	}
+}
+
+// Reset forgets all delivered values, so the next cycle reports every register.
+func (d *ChangeDetector) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last = make(map[string]reportedValue)
+	d.lastIntegrity = time.Time{}
+}
+
+// Filter returns the groups reduced to the registers to deliver, dropping empty groups,
+// together with the matching changes. When the integrity interval has elapsed every
+// register is delivered. With OnChangeOnly unset all groups are returned unchanged.
+func (d *ChangeDetector) Filter(groups [][]DeviceRegister) ([][]DeviceRegister, []TagChange) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	integrity := d.filter.IntegrityInterval > 0 && now.Sub(d.lastIntegrity) >= d.filter.IntegrityInterval
+	if integrity {
+		d.lastIntegrity = now
+	}
+
+	var filtered [][]DeviceRegister
+	var changes []TagChange
+	for _, group := range groups {
+		var kept []DeviceRegister
+		for _, reg := range group {
+			value, _ := reg.DecodeValue()
+			previous, seen := d.last[reg.Tag]
+			changed := !seen || d.changed(reg, value, previous)
+			if !changed && !integrity && d.filter.OnChangeOnly {
+				continue
+			}
+			change := TagChange{Register: reg, Value: value, Integrity: !changed}
+			if seen {
+				prev := previous.value
+				change.Previous = &prev
+			}
+			if changed || integrity {
+				d.last[reg.Tag] = reportedValue{
+					value:  value,
+					raw:    append([]byte(nil), reg.Value...),
+					status: reg.Status,
+				}
+				changes = append(changes, change)
+			}
+			kept = append(kept, reg)
+		}
+		if len(kept) > 0 {
+			filtered = append(filtered, kept)
+		}
+	}
+	return filtered, changes
+}
+
+// changed reports whether a register differs from its last delivered value.
+// Caller must hold the mutex.
+func (d *ChangeDetector) changed(reg DeviceRegister, value DecodedValue, previous reportedValue) bool {
+	if isValidStatus(reg.Status) != isValidStatus(previous.status) {
+		return true
+	}
+	if reg.Deadband <= 0 && reg.DeadbandPercent <= 0 || reg.DataType == "string" {
+		return !bytes.Equal(reg.Value, previous.raw)
+	}
+	delta := math.Abs(value.Float64 - previous.value.Float64)
+	if reg.Deadband > 0 && delta > reg.Deadband {
+		return true
+	}
+	if reg.DeadbandPercent > 0 {
+		base := math.Abs(previous.value.Float64)
+		if base == 0 {
+			return delta > 0
+		}
+		if delta > base*reg.DeadbandPercent/100 {
+			return true
+		}
+	}
+	return false
+}
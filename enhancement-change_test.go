@@ -0,0 +1,148 @@
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChangeDetectorDeadband(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	detector := NewChangeDetector(ChangeFilter{OnChangeOnly: true, IntegrityInterval: time.Minute})
+	detector.now = func() time.Time { return clock }
+
+	read := func(temperature, pressure, state uint16) ([][]DeviceRegister, []TagChange) {
+		group := []DeviceRegister{
+			{Tag: "temperature", DataType: "uint16", Weight: 0.1, Deadband: 0.5, Value: []byte{byte(temperature >> 8), byte(temperature)}, Status: "VALID:OK"},
+			{Tag: "pressure", DataType: "uint16", Weight: 1, DeadbandPercent: 10, Value: []byte{byte(pressure >> 8), byte(pressure)}, Status: "VALID:OK"},
+			{Tag: "state", DataType: "uint16", Weight: 1, Value: []byte{byte(state >> 8), byte(state)}, Status: "VALID:OK"},
+		}
+		return detector.Filter([][]DeviceRegister{group})
+	}
+	tags := func(changes []TagChange) []string {
+		var out []string
+		for _, change := range changes {
+			out = append(out, change.Register.Tag)
+		}
+		return out
+	}
+
+	// First cycle reports everything without a previous value
+	groups, changes := read(200, 100, 1)
+	if len(groups) != 1 || len(groups[0]) != 3 || len(changes) != 3 {
+		t.Fatalf("first cycle delivered %v, expected all registers", tags(changes))
+	}
+	for _, change := range changes {
+		if change.Previous != nil {
+			t.Errorf("%s: Previous = %v, expected nil on first delivery", change.Register.Tag, change.Previous)
+		}
+	}
+
+	// Changes inside the deadbands are suppressed
+	clock = clock.Add(10 * time.Second)
+	groups, changes = read(204, 109, 1)
+	if len(groups) != 0 || len(changes) != 0 {
+		t.Fatalf("expected nothing delivered inside the deadbands, got %v", tags(changes))
+	}
+
+	// Changes beyond the deadbands are delivered with the last delivered value
+	clock = clock.Add(10 * time.Second)
+	groups, changes = read(206, 111, 2)
+	if len(groups) != 1 || len(changes) != 3 {
+		t.Fatalf("expected all registers changed, got %v", tags(changes))
+	}
+	temperature := changes[0]
+	if temperature.Previous == nil || !FuzzyEqual(temperature.Previous.Float64, 20) || !FuzzyEqual(temperature.Value.Float64, 20.6) {
+		t.Errorf("temperature change = %+v, expected 20 -> 20.6", temperature)
+	}
+	if temperature.Integrity {
+		t.Errorf("temperature change flagged as integrity")
+	}
+
+	// Only the state changes
+	clock = clock.Add(10 * time.Second)
+	_, changes = read(206, 111, 3)
+	if len(changes) != 1 || changes[0].Register.Tag != "state" || changes[0].Previous.Float64 != 2 {
+		t.Errorf("expected only state 2 -> 3, got %v", tags(changes))
+	}
+
+	// A failed read is a change even if the raw value is the same
+	group := []DeviceRegister{{Tag: "state", DataType: "uint16", Weight: 1, Value: []byte{0, 3}, Status: "INVALID:timeout"}}
+	if _, changes = detector.Filter([][]DeviceRegister{group}); len(changes) != 1 {
+		t.Errorf("expected a status change to be reported")
+	}
+
+	// The integrity interval delivers everything again
+	clock = clock.Add(time.Minute)
+	groups, changes = read(206, 111, 3)
+	if len(groups) != 1 || len(groups[0]) != 3 || len(changes) != 3 {
+		t.Fatalf("expected integrity delivery of all registers, got %v", tags(changes))
+	}
+	if !changes[0].Integrity || !changes[1].Integrity {
+		t.Errorf("unchanged registers must be flagged as integrity deliveries")
+	}
+	if changes[2].Integrity {
+		t.Errorf("state recovered from a failed read and must be reported as a change")
+	}
+}
+
+func TestRegisterManagerChangeFilter(t *testing.T) {
+	client := newMemoryClient()
+	client.setRegisters(1, 0, 10, 20)
+	manager := NewRegisterManager(client, 10)
+	registers := []DeviceRegister{
+		{Tag: "a", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16", Weight: 1},
+		{Tag: "b", SlaverId: 1, Function: 3, ReadAddress: 1, ReadQuantity: 1, DataType: "uint16", Weight: 1, Deadband: 5},
+	}
+	if err := manager.LoadRegisters(registers); err != nil {
+		t.Fatal(err)
+	}
+	manager.SetChangeFilter(ChangeFilter{OnChangeOnly: true})
+
+	reads := make(chan []string, 10)
+	changes := make(chan []TagChange, 10)
+	manager.SetOnReadCallback(func(registers []DeviceRegister) {
+		var tags []string
+		for _, register := range registers {
+			tags = append(tags, register.Tag)
+		}
+		reads <- tags
+	})
+	manager.SetOnChangeCallback(func(c []TagChange) { changes <- c })
+	manager.Start()
+	defer manager.Stop()
+
+	receive := func() ([]string, []TagChange) {
+		var tags []string
+		select {
+		case tags = <-reads:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for read callback")
+		}
+		select {
+		case c := <-changes:
+			return tags, c
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for change callback")
+		}
+		return nil, nil
+	}
+
+	if errs := manager.ReadGroupedData(); len(errs) > 0 {
+		t.Fatalf("ReadGroupedData failed: %v", errs)
+	}
+	if tags, c := receive(); len(tags) != 2 || len(c) != 2 {
+		t.Fatalf("first cycle delivered %v, expected a and b", tags)
+	}
+
+	client.setRegisters(1, 0, 11, 23)
+	if errs := manager.ReadGroupedData(); len(errs) > 0 {
+		t.Fatalf("ReadGroupedData failed: %v", errs)
+	}
+	tags, c := receive()
+	if len(tags) != 1 || tags[0] != "a" {
+		t.Fatalf("second cycle delivered %v, expected only a", tags)
+	}
+	if len(c) != 1 || c[0].Previous == nil || c[0].Previous.Float64 != 10 || c[0].Value.Float64 != 11 {
+		t.Errorf("change = %+v, expected a 10 -> 11", c)
+	}
+}
@@ -0,0 +1,28 @@
+package modbus
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// DialFunc opens the connection of a TCP-family handler, e.g. through an SSH tunnel
+// or a SOCKS proxy. network is "tcp", or "unix" for unix:// addresses.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// ConnFactory returns a DialFunc that ignores the address and opens connections with
+// factory, for handlers on connections made elsewhere
+func ConnFactory(factory func() (net.Conn, error)) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return factory()
+	}
+}
+
+// splitNetworkAddress returns the network and address to dial: "unix" and the path
+// for unix:// addresses, "tcp" and the address otherwise
+func splitNetworkAddress(address string) (network, addr string) {
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		return "unix", path
+	}
+	return "tcp", address
+}
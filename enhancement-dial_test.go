@@ -0,0 +1,82 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestTCPClientHandlerUnixSocket(t *testing.T) {
+	slaves := &testRTUSlaves{registers: map[uint8][]uint16{1: {11, 12}}}
+	gateway := NewTCPRTUGateway()
+	gateway.Route(newTestRTUBus(t, slaves), 1)
+	path := filepath.Join(t.TempDir(), "modbus.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- gateway.Serve(ln) }()
+	defer func() {
+		gateway.Close()
+		if err := <-served; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+	}()
+
+	client, handler := newTestTCPClient("unix://"+path, 1)
+	defer handler.Close()
+	if results, err := client.ReadHoldingRegisters(1, 1); err != nil || binary.BigEndian.Uint16(results) != 12 {
+		t.Errorf("read over a unix socket = % X, %v", results, err)
+	}
+}
+
+func TestTCPClientHandlerDial(t *testing.T) {
+	slaves := &testRTUSlaves{registers: map[uint8][]uint16{1: {21, 22}}}
+	gateway := NewTCPRTUGateway()
+	gateway.Route(newTestRTUBus(t, slaves), 1)
+	addr := startTestGateway(t, gateway)
+
+	var mu sync.Mutex
+	var dialed []string
+	client, handler := newTestTCPClient("plc.example:502", 1)
+	defer handler.Close()
+	handler.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, network+" "+address)
+		mu.Unlock()
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	if results, err := client.ReadHoldingRegisters(0, 1); err != nil || binary.BigEndian.Uint16(results) != 21 {
+		t.Errorf("read through a custom dialer = % X, %v", results, err)
+	}
+	mu.Lock()
+	if len(dialed) != 1 || dialed[0] != "tcp plc.example:502" {
+		t.Errorf("dialed %v", dialed)
+	}
+	mu.Unlock()
+
+	// RTU over TCP handlers take the same factories; a failing factory fails the request
+	rtuHandler := NewRTUOverTCPClientHandler("ignored")
+	rtuHandler.Dial = ConnFactory(func() (net.Conn, error) { return nil, errors.New("tunnel down") })
+	if _, err := NewClient(rtuHandler).ReadHoldingRegisters(0, 1); err == nil || err.Error() != "tunnel down" {
+		t.Errorf("expected the factory error, got %v", err)
+	}
+}
+
+func TestSplitNetworkAddress(t *testing.T) {
+	for _, tc := range []struct{ address, network, addr string }{
+		{"127.0.0.1:502", "tcp", "127.0.0.1:502"},
+		{"unix:///run/modbus.sock", "unix", "/run/modbus.sock"},
+		{"plc:502", "tcp", "plc:502"},
+	} {
+		if network, addr := splitNetworkAddress(tc.address); network != tc.network || addr != tc.addr {
+			t.Errorf("splitNetworkAddress(%q) = %q, %q", tc.address, network, addr)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// BitField names a range of bits inside an integer register, e.g. bit 3 = "overtemp"
+// or bits 4..6 = "mode". Bits are counted from the least significant bit.
+type BitField struct {
+	Name   string           `json:"name"`             // Name of the field, used as key in DecodedValue.Flags
+	Bit    uint16           `json:"bit"`              // Position of the lowest bit of the field
+	Width  uint16           `json:"width,omitempty"`  // Number of bits in the field, 1 if zero
+	Labels map[int64]string `json:"labels,omitempty"` // Optional labels for the field values
+}
+
+// width returns the number of bits in the field, defaulting to a single bit
+func (f BitField) width() uint16 {
+	if f.Width == 0 {
+		return 1
+	}
+	return f.Width
+}
+
+// mask returns the field mask shifted to its position
+func (f BitField) mask() uint64 {
+	if f.width() >= 64 {
+		return math.MaxUint64 << f.Bit
+	}
+	return (uint64(1)<<f.width() - 1) << f.Bit
+}
+
+// integerBits returns the signed value and the bit pattern of an integer AsType value
+func integerBits(v any) (int64, uint64, bool) {
+	switch n := v.(type) {
+	case bool:
+		if n {
+			return 1, 1, true
+		}
+		return 0, 0, true
+	case uint8:
+		return int64(n), uint64(n), true
+	case int8:
+		return int64(n), uint64(uint8(n)), true
+	case uint16:
+		return int64(n), uint64(n), true
+	case int16:
+		return int64(n), uint64(uint16(n)), true
+	case uint32:
+		return int64(n), uint64(n), true
+	case int32:
+		return int64(n), uint64(uint32(n)), true
+	case uint64:
+		return int64(n), n, true
+	case int64:
+		return n, uint64(n), true
+	}
+	return 0, 0, false
+}
+
+// decodeLabels fills the enum label and named bit fields of a decoded value
+func (r DeviceRegister) decodeLabels(res *DecodedValue) {
+	if len(r.Enum) == 0 && len(r.BitFields) == 0 {
+		return
+	}
+	signed, bits, ok := integerBits(res.AsType)
+	if !ok {
+		return
+	}
+	if label, found := r.Enum[signed]; found {
+		res.Label = label
+	}
+	if len(r.BitFields) == 0 {
+		return
+	}
+	res.Flags = make(map[string]uint64, len(r.BitFields))
+	for _, field := range r.BitFields {
+		value := (bits & field.mask()) >> field.Bit
+		res.Flags[field.Name] = value
+		if label, found := field.Labels[int64(value)]; found {
+			if res.FlagLabels == nil {
+				res.FlagLabels = make(map[string]string)
+			}
+			res.FlagLabels[field.Name] = label
+		}
+	}
+}
+
+// enumValue resolves an enum label to its raw value
+func (r DeviceRegister) enumValue(label string) (int64, bool) {
+	for value, name := range r.Enum {
+		if strings.EqualFold(name, label) {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// encodeBitFields converts a map of field names to values (numbers, bools or field
+// labels) into raw bits and the mask of the fields being written.
+func (r DeviceRegister) encodeBitFields(fields map[string]any) (uint64, uint64, error) {
+	var bits, mask uint64
+	for name, value := range fields {
+		var field *BitField
+		for i := range r.BitFields {
+			if r.BitFields[i].Name == name {
+				field = &r.BitFields[i]
+				break
+			}
+		}
+		if field == nil {
+			return 0, 0, fmt.Errorf("unknown bit field: %s", name)
+		}
+
+		var raw uint64
+		label, isLabel := value.(string)
+		resolved := false
+		if isLabel {
+			for v, l := range field.Labels {
+				if strings.EqualFold(l, label) {
+					raw, resolved = uint64(v), true
+					break
+				}
+			}
+		}
+		if !resolved {
+			f, err := toFloat64(value)
+			if err != nil {
+				return 0, 0, fmt.Errorf("bit field %s: %w", name, err)
+			}
+			if f < 0 || f != math.Trunc(f) {
+				return 0, 0, fmt.Errorf("bit field %s: invalid value %v", name, value)
+			}
+			raw = uint64(f)
+		}
+		if raw > field.mask()>>field.Bit {
+			return 0, 0, fmt.Errorf("bit field %s: value %d does not fit in %d bits", name, raw, field.width())
+		}
+		bits |= raw << field.Bit
+		mask |= field.mask()
+	}
+	return bits, mask, nil
+}
+
+// toFieldMap converts the supported map types to map[string]any
+func toFieldMap(value any) (map[string]any, bool) {
+	switch m := value.(type) {
+	case map[string]any:
+		return m, true
+	case map[string]bool:
+		out := make(map[string]any, len(m))
+		for k, v := range m {
+			out[k] = v
+		}
+		return out, true
+	case map[string]uint64:
+		out := make(map[string]any, len(m))
+		for k, v := range m {
+			out[k] = v
+		}
+		return out, true
+	case map[string]string:
+		out := make(map[string]any, len(m))
+		for k, v := range m {
+			out[k] = v
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// putUintN writes v big-endian into all of dst
+func putUintN(dst []byte, v uint64) {
+	for i := len(dst) - 1; i >= 0; i-- {
+		dst[i] = byte(v)
+		v >>= 8
+	}
+}
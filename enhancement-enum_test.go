@@ -0,0 +1,87 @@
+package modbus
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDeviceRegisterEnumAndBitFields(t *testing.T) {
+	var register DeviceRegister
+	config := `{
+		"tag": "status", "dataType": "uint16", "readQuantity": 1, "weight": 1,
+		"enum": {"0": "Idle", "1": "Running", "2": "Fault"},
+		"bitFields": [
+			{"name": "running", "bit": 0},
+			{"name": "overtemp", "bit": 3},
+			{"name": "mode", "bit": 4, "width": 3, "labels": {"0": "Off", "1": "Manual", "2": "Auto"}}
+		]
+	}`
+	if err := json.Unmarshal([]byte(config), &register); err != nil {
+		t.Fatal(err)
+	}
+
+	register.Value = []byte{0x00, 0x01}
+	decoded, err := register.DecodeValue()
+	if err != nil {
+		t.Fatalf("DecodeValue failed: %v", err)
+	}
+	if decoded.Label != "Running" {
+		t.Errorf("Label = %q, expected Running", decoded.Label)
+	}
+
+	register.Value = []byte{0x00, 0x29} // 0b0010_1001: running, overtemp, mode=2
+	decoded, err = register.DecodeValue()
+	if err != nil {
+		t.Fatalf("DecodeValue failed: %v", err)
+	}
+	if decoded.Label != "" {
+		t.Errorf("Label = %q, expected none for an unlisted value", decoded.Label)
+	}
+	expected := map[string]uint64{"running": 1, "overtemp": 1, "mode": 2}
+	for name, value := range expected {
+		if decoded.Flags[name] != value {
+			t.Errorf("Flags[%s] = %d, expected %d", name, decoded.Flags[name], value)
+		}
+	}
+	if decoded.FlagLabels["mode"] != "Auto" {
+		t.Errorf("FlagLabels[mode] = %q, expected Auto", decoded.FlagLabels["mode"])
+	}
+
+	encoded, err := register.EncodeValue("fault")
+	if err != nil {
+		t.Fatalf("EncodeValue(label) failed: %v", err)
+	}
+	if encoded[0] != 0x00 || encoded[1] != 0x02 {
+		t.Errorf("EncodeValue(fault) = % X, expected 00 02", encoded)
+	}
+	if _, err := register.EncodeValue("Unknown"); err == nil {
+		t.Errorf("expected error encoding an unknown label")
+	}
+	if _, err := register.EncodeValue(map[string]any{"mode": 9}); err == nil {
+		t.Errorf("expected error encoding a value wider than its bit field")
+	}
+}
+
+func TestRegisterManagerWriteBitFields(t *testing.T) {
+	client := newMemoryClient()
+	client.setRegisters(1, 0, 0x8001)
+	manager := NewRegisterManager(client, 10)
+	registers := []DeviceRegister{
+		{
+			Tag: "control", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16",
+			BitFields: []BitField{
+				{Name: "reset", Bit: 3},
+				{Name: "mode", Bit: 4, Width: 3, Labels: map[int64]string{0: "Off", 1: "Manual", 2: "Auto"}},
+			},
+		},
+	}
+	if err := manager.LoadRegisters(registers); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.WriteTag("control", map[string]any{"mode": "Auto", "reset": true}); err != nil {
+		t.Fatalf("WriteTag failed: %v", err)
+	}
+	if got := client.register(1, 0); got != 0x8029 {
+		t.Errorf("control word = %04X, expected 8029 (bits outside the fields preserved)", got)
+	}
+}
@@ -0,0 +1,294 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RegisterSink consumes batches of polled registers. WriteRegisters has the signature
+// of OnDeliverFunc, so a sink can be passed to RegisterStream.SetOnDeliver directly.
+type RegisterSink interface {
+	WriteRegisters(registers []DeviceRegister) error
+}
+
+// RegisterEncoder serializes batches of registers for a sink
+type RegisterEncoder interface {
+	Encode(registers []DeviceRegister) ([]byte, error)
+	ContentType() string
+}
+
+// LineProtocolEncoder encodes registers as InfluxDB line protocol, one line per
+// register. The measurement is the device name, tags carry the slave id, tag name,
+// alias and quality, and the "value" field is typed from the register definition:
+// boolean, string, integer for unscaled integer registers, and float otherwise. Enum labels
+// are added as a "label" field. Registers with bad quality are skipped.
+type LineProtocolEncoder struct {
+	Measurement string           // Measurement of devices without a name, "modbus" if empty
+	DeviceNames map[uint8]string // Measurement per slave id
+}
+
+// ContentType returns the MIME type of line protocol
+func (e LineProtocolEncoder) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// Encode encodes the registers, timestamped with their SourceTime in nanoseconds
+func (e LineProtocolEncoder) Encode(registers []DeviceRegister) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, reg := range registers {
+		decoded, err := reg.DecodeValue()
+		if err != nil || decoded.Quality == QualityBad {
+			continue
+		}
+		value, ok := lineProtocolValue(reg, decoded)
+		if !ok {
+			continue
+		}
+		buf.WriteString(escapeLineProtocol(e.measurement(reg.SlaverId), ", "))
+		buf.WriteString(",slave=" + strconv.Itoa(int(reg.SlaverId)))
+		buf.WriteString(",tag=" + escapeLineProtocol(reg.Tag, ",= "))
+		if reg.Alias != "" {
+			buf.WriteString(",alias=" + escapeLineProtocol(reg.Alias, ",= "))
+		}
+		buf.WriteString(",quality=" + decoded.Quality.String())
+		buf.WriteString(" value=" + value)
+		if decoded.Label != "" {
+			buf.WriteString(",label=" + quoteLineProtocol(decoded.Label))
+		}
+		if at := registerTime(reg); !at.IsZero() {
+			buf.WriteString(" " + strconv.FormatInt(at.UnixNano(), 10))
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func (e LineProtocolEncoder) measurement(slave uint8) string {
+	if name, ok := e.DeviceNames[slave]; ok && name != "" {
+		return name
+	}
+	if e.Measurement != "" {
+		return e.Measurement
+	}
+	return "modbus"
+}
+
+// lineProtocolValue formats a decoded value as a line protocol field value
+func lineProtocolValue(reg DeviceRegister, decoded DecodedValue) (string, bool) {
+	value, ok := typedValue(reg, decoded)
+	if !ok {
+		return "", false
+	}
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return quoteLineProtocol(v), true
+	case int64:
+		return strconv.FormatInt(v, 10) + "i", true
+	}
+	return strconv.FormatFloat(value.(float64), 'g', -1, 64), true
+}
+
+// typedValue returns the value of a register as bool, string, int64 for integer
+// registers, or float64. NaN and infinite values are reported as missing.
+func typedValue(reg DeviceRegister, decoded DecodedValue) (any, bool) {
+	switch v := decoded.AsType.(type) {
+	case bool, string:
+		return v, true
+	}
+	if integerRegister(reg) {
+		if signed, _, ok := integerBits(decoded.AsType); ok {
+			return signed, true
+		}
+	}
+	if math.IsNaN(decoded.Float64) || math.IsInf(decoded.Float64, 0) {
+		return nil, false
+	}
+	return decoded.Float64, true
+}
+
+// integerRegister reports whether the values of a register are integers: an integer
+// data type without Weight or Transforms. It depends on the register definition only,
+// so the field type of a tag does not change from value to value.
+func integerRegister(reg DeviceRegister) bool {
+	if len(reg.Transforms) > 0 {
+		return false
+	}
+	switch reg.DataType {
+	case "byte":
+		return true
+	case "bitfield", "uint8", "int8", "uint16", "int16", "uint32", "int32", "uint48", "uint64", "int64", "bcd", "bcd32":
+		return reg.Weight == 0 || reg.Weight == 1
+	}
+	return false
+}
+
+// escapeLineProtocol backslash-escapes the given characters
+func escapeLineProtocol(s, chars string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c == '\n' {
+			c = ' '
+		}
+		if strings.ContainsRune(chars, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// quoteLineProtocol quotes a string field value
+func quoteLineProtocol(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// registerTime returns the sample time of a register, or its last read attempt
+func registerTime(reg DeviceRegister) time.Time {
+	if !reg.SourceTime.IsZero() {
+		return reg.SourceTime
+	}
+	return reg.ReceiveTime
+}
+
+// RegisterRecord is the JSON Lines representation of a register value
+type RegisterRecord struct {
+	Time          time.Time `json:"time"`
+	Slave         uint8     `json:"slave"`
+	Tag           string    `json:"tag"`
+	Alias         string    `json:"alias,omitempty"`
+	UUID          string    `json:"uuid,omitempty"`
+	DataType      string    `json:"dataType"`
+	Value         any       `json:"value"`   // Typed value: bool, string, integer or float
+	Float64       float64   `json:"float64"` // Scaled value
+	Label         string    `json:"label,omitempty"`
+	Quality       Quality   `json:"quality"`
+	QualityReason string    `json:"qualityReason,omitempty"`
+}
+
+// newRegisterRecord builds the record of a register. Values that cannot be decoded
+// are left empty.
+func newRegisterRecord(reg DeviceRegister) RegisterRecord {
+	decoded, err := reg.DecodeValue()
+	record := RegisterRecord{
+		Time:          registerTime(reg),
+		Slave:         reg.SlaverId,
+		Tag:           reg.Tag,
+		Alias:         reg.Alias,
+		UUID:          reg.UUID,
+		DataType:      reg.DataType,
+		Label:         decoded.Label,
+		Quality:       decoded.Quality,
+		QualityReason: decoded.QualityReason,
+	}
+	if err == nil {
+		record.Value, _ = typedValue(reg, decoded)
+		record.Float64 = decoded.Float64
+	}
+	if math.IsNaN(record.Float64) || math.IsInf(record.Float64, 0) {
+		record.Float64 = 0
+	}
+	return record
+}
+
+// JSONLinesEncoder encodes registers as JSON Lines, one RegisterRecord per line.
+// Registers with bad quality are included with their last value.
+type JSONLinesEncoder struct{}
+
+// ContentType returns the MIME type of JSON Lines
+func (JSONLinesEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// Encode encodes the registers
+func (JSONLinesEncoder) Encode(registers []DeviceRegister) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, reg := range registers {
+		record := newRegisterRecord(reg)
+		if err := enc.Encode(record); err != nil {
+			return nil, fmt.Errorf("encode %s: %w", reg.Tag, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// WriterSink writes encoded batches to an io.Writer, e.g. a file or os.Stdout
+type WriterSink struct {
+	mu      sync.Mutex
+	w       io.Writer
+	encoder RegisterEncoder
+}
+
+// NewWriterSink creates a sink writing batches encoded by encoder to w
+func NewWriterSink(w io.Writer, encoder RegisterEncoder) *WriterSink {
+	return &WriterSink{w: w, encoder: encoder}
+}
+
+// WriteRegisters encodes and writes a batch
+func (s *WriterSink) WriteRegisters(registers []DeviceRegister) error {
+	data, err := s.encoder.Encode(registers)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(data)
+	return err
+}
+
+// HTTPSink posts encoded batches to an HTTP endpoint, e.g. the InfluxDB write API
+// "http://influx:8086/api/v2/write?org=o&bucket=b&precision=ns" with an
+// "Authorization: Token ..." header.
+type HTTPSink struct {
+	URL     string
+	Encoder RegisterEncoder
+	Header  http.Header  // Extra request headers
+	Client  *http.Client // HTTP client, a client with a 10s timeout if nil
+}
+
+// NewHTTPSink creates a sink posting batches encoded by encoder to url
+func NewHTTPSink(url string, encoder RegisterEncoder) *HTTPSink {
+	return &HTTPSink{URL: url, Encoder: encoder, Header: make(http.Header)}
+}
+
+// WriteRegisters encodes and posts a batch. Responses other than 2xx are errors.
+func (s *HTTPSink) WriteRegisters(registers []DeviceRegister) error {
+	data, err := s.Encoder.Encode(registers)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for key, values := range s.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", s.Encoder.ContentType())
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("http sink: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportRegisters() []DeviceRegister {
+	at := time.Unix(1700000000, 500)
+	registers := []DeviceRegister{
+		{Tag: "temperature", Alias: "Boiler temp", SlaverId: 1, DataType: "uint16", Weight: 0.1, Value: []byte{0x00, 0xD7}},
+		{Tag: "count", SlaverId: 1, DataType: "uint32", Weight: 1, Value: []byte{0x00, 0x01, 0x00, 0x00}},
+		{Tag: "running", SlaverId: 2, DataType: "bool", Weight: 1, Value: []byte{0x00, 0x01}},
+		{Tag: "name", SlaverId: 2, DataType: "string", ReadQuantity: 2, Value: []byte(`a"b `), StringTrim: "space"},
+		{Tag: "mode", SlaverId: 2, DataType: "int16", Weight: 1, Value: []byte{0x00, 0x02}, Enum: map[int64]string{2: "Auto"}},
+		{Tag: "failed", SlaverId: 2, DataType: "uint16", Weight: 1, Value: []byte{0x00, 0x01}},
+	}
+	for i := range registers[:5] {
+		registers[i].setGood(at, at)
+	}
+	registers[5].setBad(ReasonTimeout, 0, "timeout", at)
+	return registers
+}
+
+func TestLineProtocolEncoder(t *testing.T) {
+	encoder := LineProtocolEncoder{DeviceNames: map[uint8]string{1: "boiler room"}}
+	data, err := encoder.Encode(exportRegisters())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		`boiler\ room,slave=1,tag=temperature,alias=Boiler\ temp,quality=good value=21.5 1700000000000000500`,
+		`boiler\ room,slave=1,tag=count,quality=good value=65536i 1700000000000000500`,
+		`modbus,slave=2,tag=running,quality=good value=true 1700000000000000500`,
+		`modbus,slave=2,tag=name,quality=good value="a\"b" 1700000000000000500`,
+		`modbus,slave=2,tag=mode,quality=good value=2i,label="Auto" 1700000000000000500`,
+	}, "\n") + "\n"
+	if string(data) != expected {
+		t.Errorf("line protocol:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestLineProtocolEncoderFieldType(t *testing.T) {
+	// The field type of a tag follows its definition, not the value: a scaled register
+	// is a float even when the value is whole, an unscaled one an integer
+	at := time.Unix(1700000000, 0)
+	registers := []DeviceRegister{
+		{Tag: "flow", SlaverId: 1, DataType: "uint16", Weight: 0.1, Value: []byte{0x00, 0x00}},
+		{Tag: "flow", SlaverId: 1, DataType: "uint16", Weight: 0.1, Value: []byte{0x00, 0x05}},
+		{Tag: "level", SlaverId: 1, DataType: "int16", Weight: 1, Transforms: []ValueTransform{{Type: TransformScaleFactor, ScaleFactor: 0}}, Value: []byte{0x00, 0x07}},
+		{Tag: "raw", SlaverId: 1, DataType: "uint16", Value: []byte{0x00, 0x07}},
+	}
+	for i := range registers {
+		registers[i].setGood(at, at)
+	}
+	data, err := LineProtocolEncoder{}.Encode(registers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		`modbus,slave=1,tag=flow,quality=good value=0 1700000000000000000`,
+		`modbus,slave=1,tag=flow,quality=good value=0.5 1700000000000000000`,
+		`modbus,slave=1,tag=level,quality=good value=7 1700000000000000000`,
+		`modbus,slave=1,tag=raw,quality=good value=7i 1700000000000000000`,
+	}, "\n") + "\n"
+	if string(data) != expected {
+		t.Errorf("line protocol:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestJSONLinesSinkOverHTTP(t *testing.T) {
+	var body []byte
+	var contentType, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		contentType, auth = r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL, JSONLinesEncoder{})
+	sink.Header.Set("Authorization", "Token secret")
+	if err := sink.WriteRegisters(exportRegisters()); err != nil {
+		t.Fatalf("WriteRegisters failed: %v", err)
+	}
+	if contentType != "application/x-ndjson" || auth != "Token secret" {
+		t.Errorf("headers: Content-Type %q, Authorization %q", contentType, auth)
+	}
+
+	var records []RegisterRecord
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var record RegisterRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 6 {
+		t.Fatalf("got %d records, expected 6", len(records))
+	}
+	if r := records[0]; r.Tag != "temperature" || r.Alias != "Boiler temp" || r.Value != 21.5 || r.Quality != QualityGood {
+		t.Errorf("temperature record = %+v", r)
+	}
+	if r := records[2]; r.Value != true {
+		t.Errorf("running record value = %v, expected true", r.Value)
+	}
+	if r := records[5]; r.Quality != QualityBad || r.QualityReason != ReasonTimeout {
+		t.Errorf("failed record = %+v, expected bad quality", r)
+	}
+}
+
+func TestHTTPSinkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bucket not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := NewHTTPSink(server.URL, LineProtocolEncoder{}).WriteRegisters(exportRegisters())
+	if err == nil || !strings.Contains(err.Error(), "bucket not found") {
+		t.Errorf("expected error with the response body, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := NewWriterSink(&buf, JSONLinesEncoder{}).WriteRegisters(exportRegisters()[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), `{"time":`) || !strings.HasSuffix(buf.String(), "}\n") {
+		t.Errorf("writer sink output = %q", buf.String())
+	}
+}
@@ -0,0 +1,503 @@
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed arithmetic/logical expression over tag values, used by
+// virtual tags. Values are float64; comparisons and logical operators return 1 or 0
+// and treat any non-zero value as true.
+//
+// Supported syntax:
+//
+//	numbers      1, 2.5, 1e3, 0x10, true, false
+//	tags         voltage, phase_a.current, {tag with spaces}
+//	arithmetic   + - * / % and unary -
+//	comparisons  < <= > >= == !=
+//	logical      && || !
+//	conditional  cond ? a : b, if(cond, a, b)
+//	functions    min(a, b, ...), max(a, b, ...), abs, sqrt, round, floor, ceil, pow(a, b)
+type Expression struct {
+	source string
+	root   exprNode
+	vars   []string
+}
+
+// ParseExpression parses an expression.
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, vars: make(map[string]bool)}
+	root, err := p.parseConditional()
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", source, err)
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("expression %q: unexpected %q at position %d", source, tok.text, tok.pos)
+	}
+	vars := make([]string, 0, len(p.vars))
+	for name := range p.vars {
+		vars = append(vars, name)
+	}
+	sort.Strings(vars)
+	return &Expression{source: source, root: root, vars: vars}, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Variables returns the sorted names of the tags referenced by the expression
+func (e *Expression) Variables() []string {
+	return append([]string(nil), e.vars...)
+}
+
+// Eval evaluates the expression, resolving tags with lookup.
+func (e *Expression) Eval(lookup func(tag string) (float64, bool)) (float64, error) {
+	return e.root.eval(lookup)
+}
+
+type exprNode interface {
+	eval(lookup func(string) (float64, bool)) (float64, error)
+}
+
+type numberNode float64
+
+func (n numberNode) eval(func(string) (float64, bool)) (float64, error) {
+	return float64(n), nil
+}
+
+type varNode string
+
+func (n varNode) eval(lookup func(string) (float64, bool)) (float64, error) {
+	v, ok := lookup(string(n))
+	if !ok {
+		return 0, fmt.Errorf("unknown tag: %s", string(n))
+	}
+	return v, nil
+}
+
+type unaryNode struct {
+	op string
+	x  exprNode
+}
+
+func (n unaryNode) eval(lookup func(string) (float64, bool)) (float64, error) {
+	x, err := n.x.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	if n.op == "!" {
+		return boolFloat(x == 0), nil
+	}
+	return -x, nil
+}
+
+type binaryNode struct {
+	op   string
+	l, r exprNode
+}
+
+func (n binaryNode) eval(lookup func(string) (float64, bool)) (float64, error) {
+	l, err := n.l.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	// Logical operators short-circuit
+	switch {
+	case n.op == "&&" && l == 0:
+		return 0, nil
+	case n.op == "||" && l != 0:
+		return 1, nil
+	}
+	r, err := n.r.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return math.Mod(l, r), nil
+	case "<":
+		return boolFloat(l < r), nil
+	case "<=":
+		return boolFloat(l <= r), nil
+	case ">":
+		return boolFloat(l > r), nil
+	case ">=":
+		return boolFloat(l >= r), nil
+	case "==":
+		return boolFloat(l == r), nil
+	case "!=":
+		return boolFloat(l != r), nil
+	case "&&", "||":
+		return boolFloat(r != 0), nil
+	}
+	return 0, fmt.Errorf("unsupported operator: %s", n.op)
+}
+
+type condNode struct {
+	cond, then, otherwise exprNode
+}
+
+func (n condNode) eval(lookup func(string) (float64, bool)) (float64, error) {
+	c, err := n.cond.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	if c != 0 {
+		return n.then.eval(lookup)
+	}
+	return n.otherwise.eval(lookup)
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+func (n callNode) eval(lookup func(string) (float64, bool)) (float64, error) {
+	if n.name == "if" {
+		return condNode{n.args[0], n.args[1], n.args[2]}.eval(lookup)
+	}
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(lookup)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	switch n.name {
+	case "min":
+		v := args[0]
+		for _, a := range args[1:] {
+			v = math.Min(v, a)
+		}
+		return v, nil
+	case "max":
+		v := args[0]
+		for _, a := range args[1:] {
+			v = math.Max(v, a)
+		}
+		return v, nil
+	case "abs":
+		return math.Abs(args[0]), nil
+	case "sqrt":
+		if args[0] < 0 {
+			return 0, fmt.Errorf("sqrt of negative value %v", args[0])
+		}
+		return math.Sqrt(args[0]), nil
+	case "round":
+		return math.Round(args[0]), nil
+	case "floor":
+		return math.Floor(args[0]), nil
+	case "ceil":
+		return math.Ceil(args[0]), nil
+	case "pow":
+		return math.Pow(args[0], args[1]), nil
+	}
+	return 0, fmt.Errorf("unknown function: %s", n.name)
+}
+
+// exprFunctions maps function names to their minimum and maximum argument count, -1 for variadic
+var exprFunctions = map[string][2]int{
+	"min":   {1, -1},
+	"max":   {1, -1},
+	"abs":   {1, 1},
+	"sqrt":  {1, 1},
+	"round": {1, 1},
+	"floor": {1, 1},
+	"ceil":  {1, 1},
+	"pow":   {2, 2},
+	"if":    {3, 3},
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenOperator
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+// exprOperators lists the operators, two-character operators first
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "+", "-", "*", "/", "%", "<", ">", "!", "(", ")", ",", "?", ":"}
+
+func tokenizeExpression(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			if strings.HasPrefix(src[i:], "0x") || strings.HasPrefix(src[i:], "0X") {
+				i += 2
+				for i < len(src) && strings.ContainsRune("0123456789abcdefABCDEF", rune(src[i])) {
+					i++
+				}
+				v, err := strconv.ParseUint(src[start+2:i], 16, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid number %q at position %d", src[start:i], start)
+				}
+				tokens = append(tokens, exprToken{kind: tokenNumber, text: src[start:i], num: float64(v), pos: start})
+				continue
+			}
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+			}
+			v, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", src[start:i], start)
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: src[start:i], num: v, pos: start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] == '.' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: src[start:i], pos: start})
+		case c == '{':
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated tag name at position %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: strings.TrimSpace(src[i+1 : i+end]), pos: i})
+			i += end + 1
+		default:
+			matched := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, exprToken{kind: tokenOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, exprToken{kind: tokenEOF, pos: len(src)}), nil
+}
+
+// exprParser is a recursive descent parser over the expression tokens
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	vars   map[string]bool
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is one of the given operators
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokenOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		tok := p.peek()
+		if tok.kind == tokenEOF {
+			return fmt.Errorf("expected %q at end of expression", op)
+		}
+		return fmt.Errorf("expected %q at position %d, got %q", op, tok.pos, tok.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseConditional() (exprNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	return condNode{cond, then, otherwise}, nil
+}
+
+// exprPrecedence lists binary operators from the lowest to the highest precedence
+var exprPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) parseBinary(level int) (exprNode, error) {
+	if level == len(exprPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(exprPrecedence[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op, left, right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if op, ok := p.accept("-", "!", "+"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			return x, nil
+		}
+		return unaryNode{op, x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber:
+		return numberNode(tok.num), nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return numberNode(1), nil
+		case "false":
+			return numberNode(0), nil
+		}
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(tok)
+		}
+		p.vars[tok.text] = true
+		return varNode(tok.text), nil
+	case tokenOperator:
+		if tok.text == "(" {
+			x, err := p.parseConditional()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+	return nil, fmt.Errorf("unexpected end of expression")
+}
+
+func (p *exprParser) parseCall(name exprToken) (exprNode, error) {
+	arity, ok := exprFunctions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	var args []exprNode
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseConditional()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) < arity[0] || arity[1] >= 0 && len(args) > arity[1] {
+		return nil, fmt.Errorf("function %s called with %d arguments", name.text, len(args))
+	}
+	return callNode{name.text, args}, nil
+}
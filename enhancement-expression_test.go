@@ -0,0 +1,73 @@
+package modbus
+
+import (
+	"testing"
+)
+
+func TestExpressionEval(t *testing.T) {
+	vars := map[string]float64{"v": 230, "i": 2.5, "a.b": 3, "alarm 1": 1, "alarm2": 0, "zero": 0}
+	lookup := func(tag string) (float64, bool) {
+		v, ok := vars[tag]
+		return v, ok
+	}
+	tests := []struct {
+		expr     string
+		expected float64
+	}{
+		{"v * i", 575},
+		{"1 + 2 * 3 - 4 / 2", 5},
+		{"(1 + 2) * 3", 9},
+		{"-v + 30", -200},
+		{"10 % 4", 2},
+		{"0x10 + 1e1", 26},
+		{"a.b * 2", 6},
+		{"{alarm 1} || alarm2", 1},
+		{"{alarm 1} && alarm2", 0},
+		{"!alarm2", 1},
+		{"v > 200 && i <= 2.5", 1},
+		{"v == 230 ? 1 : 2", 1},
+		{"v != 230 ? 1 : alarm2 ? 2 : 3", 3},
+		{"min(v, i, a.b)", 2.5},
+		{"max(v, i, a.b)", 230},
+		{"abs(-4)", 4},
+		{"round(2.5) + floor(2.7) + ceil(2.1) + sqrt(16) + pow(2, 3)", 20},
+		{"if(zero, 1 / zero, 7)", 7},
+		{"zero && 1 / zero", 0},
+		{"true + false", 1},
+	}
+	for _, tc := range tests {
+		expr, err := ParseExpression(tc.expr)
+		if err != nil {
+			t.Errorf("ParseExpression(%q) failed: %v", tc.expr, err)
+			continue
+		}
+		got, err := expr.Eval(lookup)
+		if err != nil {
+			t.Errorf("Eval(%q) failed: %v", tc.expr, err)
+			continue
+		}
+		if !FuzzyEqual(got, tc.expected) {
+			t.Errorf("Eval(%q) = %v, expected %v", tc.expr, got, tc.expected)
+		}
+	}
+
+	expr, _ := ParseExpression("v * i + {alarm 1} + v")
+	if vars := expr.Variables(); len(vars) != 3 || vars[0] != "alarm 1" || vars[1] != "i" || vars[2] != "v" {
+		t.Errorf("Variables() = %v, expected [alarm 1 i v]", vars)
+	}
+
+	for _, src := range []string{"", "1 +", "(1", "v i", "foo(1)", "abs(1, 2)", "1 ? 2", "{open", "1 # 2"} {
+		if _, err := ParseExpression(src); err == nil {
+			t.Errorf("ParseExpression(%q) succeeded, expected error", src)
+		}
+	}
+	for _, src := range []string{"v / zero", "missing + 1", "sqrt(-1)"} {
+		expr, err := ParseExpression(src)
+		if err != nil {
+			t.Fatalf("ParseExpression(%q) failed: %v", src, err)
+		}
+		if _, err := expr.Eval(lookup); err == nil {
+			t.Errorf("Eval(%q) succeeded, expected error", src)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// serialReader reads a serial port on its own goroutine, so frames can be read with
+// timeouts and inter-frame gaps from any io.Reader, with or without read timeouts of
+// its own.
+type serialReader struct {
+	rx      chan []byte // Chunks read from the port
+	pending []byte      // Bytes received but not yet consumed
+	done    chan struct{}
+	err     error // Read error that stopped the reader
+}
+
+func newSerialReader(port io.Reader) *serialReader {
+	r := &serialReader{rx: make(chan []byte, 64), done: make(chan struct{})}
+	go r.readLoop(port)
+	return r
+}
+
+func (r *serialReader) readLoop(port io.Reader) {
+	defer close(r.done)
+	buf := make([]byte, rtuMaxSize)
+	for {
+		n, err := port.Read(buf)
+		if n > 0 {
+			r.rx <- append([]byte(nil), buf[:n]...)
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+				continue // Ports with read timeouts return periodically
+			}
+			r.err = err
+			return
+		}
+	}
+}
+
+// closedErr returns the error that stopped the reader, nil while it is running
+func (r *serialReader) closedErr() error {
+	select {
+	case <-r.done:
+		return fmt.Errorf("port closed: %v", r.err)
+	default:
+		return nil
+	}
+}
+
+// discard drops stale bytes, e.g. a late response to a request that timed out
+func (r *serialReader) discard() {
+	r.pending = nil
+	for {
+		select {
+		case <-r.rx:
+		default:
+			return
+		}
+	}
+}
+
+// flush drops stale bytes and everything received until the line has been silent for
+// gap, to resynchronize on the start of the next frame
+func (r *serialReader) flush(gap time.Duration) {
+	r.pending = nil
+	timer := time.NewTimer(gap)
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.rx:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(gap)
+		case <-timer.C:
+			return
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// readFrame reads one frame, using frameLength to tell where it ends. Frames of
+// unknown length end with a silence of gap. A timeout of zero waits for the first
+// byte forever.
+func (r *serialReader) readFrame(frameLength func([]byte) int, timeout, gap time.Duration) ([]byte, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	frame := r.pending
+	r.pending = nil
+	for {
+		length := frameLength(frame)
+		if length > 0 && len(frame) >= length {
+			r.pending = frame[length:]
+			return frame[:length], nil
+		}
+		var silence <-chan time.Time
+		if length < 0 && len(frame) > 0 {
+			silence = time.After(gap)
+		}
+		select {
+		case chunk := <-r.rx:
+			frame = append(frame, chunk...)
+			if len(frame) > rtuMaxSize {
+				return nil, fmt.Errorf("frame exceeds %d bytes", rtuMaxSize)
+			}
+		case <-silence:
+			return frame, nil
+		case <-deadline:
+			if len(frame) == 0 {
+				return nil, fmt.Errorf("no response: %w", os.ErrDeadlineExceeded)
+			}
+			return nil, fmt.Errorf("incomplete frame % X: %w", frame, os.ErrDeadlineExceeded)
+		case <-r.done:
+			return nil, r.closedErr()
+		}
+	}
+}
+
+// RTUBus is a serial bus shared by gateway connections. Requests are serialized, and
+// the response of the addressed slave is framed from its function code, so any
+// io.ReadWriteCloser works as the port, with or without read timeouts of its own.
+type RTUBus struct {
+	Timeout    time.Duration // Response timeout, 1s if zero
+	FrameDelay time.Duration // Silence between frames, e.g. 3.5 characters; also ends frames of unknown length, 20ms if zero
+
+	mu       sync.Mutex
+	port     io.ReadWriteCloser
+	packager *RTUPackager
+	reader   *serialReader
+}
+
+// NewRTUBus creates a bus on an open serial port
+func NewRTUBus(port io.ReadWriteCloser, timeout time.Duration) *RTUBus {
+	return &RTUBus{
+		Timeout:  timeout,
+		port:     port,
+		packager: NewRTUPackager(),
+		reader:   newSerialReader(port),
+	}
+}
+
+// Transact sends a request PDU to a slave and returns its response PDU, including
+// exception responses. Requests to the broadcast address 0 are sent without waiting
+// for a response. A missing response is reported as a timeout error wrapping
+// os.ErrDeadlineExceeded.
+func (b *RTUBus) Transact(slave uint8, pdu []byte) ([]byte, error) {
+	if len(pdu) == 0 {
+		return nil, fmt.Errorf("rtu bus: empty request")
+	}
+	frame, err := b.packager.Pack(slave, pdu)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reader.discard()
+	if err := b.reader.closedErr(); err != nil {
+		return nil, fmt.Errorf("rtu bus: %w", err)
+	}
+	if _, err := b.port.Write(frame); err != nil {
+		return nil, fmt.Errorf("rtu bus: write: %w", err)
+	}
+	if slave == 0 {
+		time.Sleep(frameDelay(b.FrameDelay))
+		return nil, nil
+	}
+	response, err := b.reader.readFrame(rtuResponseLength, b.timeout(), frameDelay(b.FrameDelay))
+	if err != nil {
+		return nil, fmt.Errorf("rtu bus: %w", err)
+	}
+	respSlave, respPDU, err := b.packager.Unpack(response)
+	if err != nil {
+		return nil, fmt.Errorf("rtu bus: %w", err)
+	}
+	if respSlave != slave || len(respPDU) == 0 || respPDU[0]&0x7F != pdu[0] {
+		return nil, fmt.Errorf("rtu bus: response % X does not match request to slave %d function %d", response, slave, pdu[0])
+	}
+	return respPDU, nil
+}
+
+// Close closes the serial port
+func (b *RTUBus) Close() error {
+	return b.port.Close()
+}
+
+func (b *RTUBus) timeout() time.Duration {
+	if b.Timeout > 0 {
+		return b.Timeout
+	}
+	return time.Second
+}
+
+// frameDelay returns the configured inter-frame silence, 20ms if zero
+func frameDelay(d time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return 20 * time.Millisecond
+}
+
+// rtuResponseLength returns the length of the RTU response frame starting with frame,
+// 0 when more bytes are needed to tell, or -1 for function codes without a known
+// length, which end with the silence after the frame.
+func rtuResponseLength(frame []byte) int {
+	if len(frame) < 2 {
+		return 0
+	}
+	function := frame[1]
+	if function&0x80 != 0 {
+		return 5
+	}
+	switch function {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs, FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		0x0C, 0x11, 0x14, 0x15, FuncCodeReadWriteMultipleRegisters:
+		if len(frame) < 3 {
+			return 0
+		}
+		return 5 + int(frame[2])
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister, FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters, 0x08, 0x0B:
+		return 8
+	case FuncCodeReadExceptionStatus:
+		return 5
+	case FuncCodeMaskWriteRegister:
+		return 10
+	case FuncCodeReadFIFOQueue:
+		if len(frame) < 4 {
+			return 0
+		}
+		return 6 + int(binary.BigEndian.Uint16(frame[2:4]))
+	}
+	return -1
+}
+
+// mbapServer accepts Modbus TCP connections and answers their requests, for the
+// gateway and the proxy
+type mbapServer struct {
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+	packager *TCPPackager
+}
+
+func newMBAPServer() mbapServer {
+	return mbapServer{
+		conns:    make(map[net.Conn]struct{}),
+		packager: NewTCPPackager(),
+	}
+}
+
+// serve accepts connections on ln until close and serves each on its own goroutine.
+// It always returns a non-nil error; after close the error is net.ErrClosed.
+func (s *mbapServer) serve(ln net.Listener, serveConn func(conn net.Conn)) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	s.listener = ln
+	s.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return net.ErrClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+				s.wg.Done()
+			}()
+			serveConn(conn)
+		}()
+	}
+}
+
+// close stops the listener, closes the connections and waits for their handlers
+func (s *mbapServer) close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// serveConn answers the requests of a connection with handle until the connection
+// fails or is idle for idleTimeout. Requests handle returns nil for are not answered.
+// Errors are returned with the prefix, and EOF or a closed connection as nil.
+func (s *mbapServer) serveConn(conn net.Conn, idleTimeout time.Duration, prefix string,
+	handle func(unit uint8, pdu []byte) []byte) error {
+	r := bufio.NewReader(conn)
+	for {
+		if idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		frame, err := readMBAPFrame(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("%s: %s: %w", prefix, conn.RemoteAddr(), err)
+		}
+		transactionID, unit, pdu, err := s.packager.Unpack(frame)
+		if err != nil || len(pdu) == 0 {
+			return fmt.Errorf("%s: %s: invalid frame % X", prefix, conn.RemoteAddr(), frame)
+		}
+		response := handle(unit, pdu)
+		if response == nil {
+			continue
+		}
+		out, _ := s.packager.Pack(transactionID, unit, response)
+		if _, err := conn.Write(out); err != nil {
+			return fmt.Errorf("%s: %s: %w", prefix, conn.RemoteAddr(), err)
+		}
+	}
+}
+
+// TCPRTUGateway accepts Modbus TCP connections and forwards requests to slaves on
+// serial buses by unit id. Requests for units without a route are answered with
+// exception 0x0A (gateway path unavailable), and requests the slave does not answer
+// with exception 0x0B (gateway target device failed to respond).
+type TCPRTUGateway struct {
+	IdleTimeout time.Duration   // Close client connections idle for this long, never if zero
+	OnError     func(err error) // Optional callback for connection and bus errors
+
+	mu     sync.Mutex
+	routes map[uint8]*RTUBus
+	server mbapServer
+}
+
+// NewTCPRTUGateway creates a gateway without routes
+func NewTCPRTUGateway() *TCPRTUGateway {
+	return &TCPRTUGateway{
+		routes: make(map[uint8]*RTUBus),
+		server: newMBAPServer(),
+	}
+}
+
+// Route forwards requests for the unit ids to bus
+func (g *TCPRTUGateway) Route(bus *RTUBus, units ...uint8) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, unit := range units {
+		g.routes[unit] = bus
+	}
+}
+
+// RouteRange forwards requests for unit ids first to last to bus
+func (g *TCPRTUGateway) RouteRange(bus *RTUBus, first, last uint8) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for unit := int(first); unit <= int(last); unit++ {
+		g.routes[uint8(unit)] = bus
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves connections until Close
+func (g *TCPRTUGateway) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(ln)
+}
+
+// Serve accepts connections on ln until Close. It always returns a non-nil error;
+// after Close the error is net.ErrClosed.
+func (g *TCPRTUGateway) Serve(ln net.Listener) error {
+	return g.server.serve(ln, func(conn net.Conn) {
+		if err := g.server.serveConn(conn, g.IdleTimeout, "gateway", g.forward); err != nil {
+			g.reportError(err)
+		}
+	})
+}
+
+// Close stops the listener, closes client connections and waits for their handlers.
+// The buses are left open.
+func (g *TCPRTUGateway) Close() error {
+	return g.server.close()
+}
+
+// forward sends a request to the bus of the unit and returns the response PDU, nil
+// for broadcasts
+func (g *TCPRTUGateway) forward(unit uint8, pdu []byte) []byte {
+	g.mu.Lock()
+	bus := g.routes[unit]
+	g.mu.Unlock()
+	if bus == nil {
+		return exceptionPDU(pdu[0], ExceptionCodeGatewayPathUnavailable)
+	}
+	response, err := bus.Transact(unit, pdu)
+	if err != nil {
+		g.reportError(fmt.Errorf("gateway: unit %d: %w", unit, err))
+		return exceptionPDU(pdu[0], ExceptionCodeGatewayTargetDeviceFailedToRespond)
+	}
+	return response
+}
+
+func (g *TCPRTUGateway) reportError(err error) {
+	if g.OnError != nil {
+		g.OnError(err)
+	}
+}
+
+// exceptionPDU builds the exception response to a function code
+func exceptionPDU(function, exceptionCode byte) []byte {
+	return []byte{function | 0x80, exceptionCode}
+}
+
+// readMBAPFrame reads a complete Modbus TCP frame: the MBAP header and the PDU
+func readMBAPFrame(r io.Reader) ([]byte, error) {
+	frame := make([]byte, 7, 7+253)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(frame[4:6]))
+	if length < 2 || length > 254 {
+		return nil, fmt.Errorf("invalid MBAP length: %d", length)
+	}
+	frame = frame[:6+length]
+	if _, err := io.ReadFull(r, frame[7:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
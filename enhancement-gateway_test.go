@@ -0,0 +1,220 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRTUSlaves simulates slaves with holding registers, and optionally input registers,
+// on the far end of a serial line. Slaves missing from registers do not answer.
+type testRTUSlaves struct {
+	mu        sync.Mutex
+	registers map[uint8][]uint16
+	inputs    map[uint8][]uint16
+	requests  int
+}
+
+// serve answers RTU requests for FC 3, 4, 6 and 16, and exception 0x01 otherwise
+func (s *testRTUSlaves) serve(port io.ReadWriter) {
+	packager := NewRTUPackager()
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(port, header); err != nil {
+			return
+		}
+		frame := header
+		if header[1] == FuncCodeWriteMultipleRegisters {
+			rest := make([]byte, int(header[6])+1)
+			if _, err := io.ReadFull(port, rest); err != nil {
+				return
+			}
+			frame = append(frame, rest...)
+		}
+		slave, pdu, err := packager.Unpack(frame)
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.requests++
+		registers, ok := s.registers[slave]
+		s.mu.Unlock()
+		if !ok {
+			continue
+		}
+		address := binary.BigEndian.Uint16(pdu[1:3])
+		var response []byte
+		switch pdu[0] {
+		case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+			if pdu[0] == FuncCodeReadInputRegisters {
+				s.mu.Lock()
+				registers = s.inputs[slave]
+				s.mu.Unlock()
+			}
+			quantity := binary.BigEndian.Uint16(pdu[3:5])
+			if int(address)+int(quantity) > len(registers) {
+				response = exceptionPDU(pdu[0], ExceptionCodeIllegalDataAddress)
+				break
+			}
+			response = []byte{pdu[0], byte(quantity * 2)}
+			s.mu.Lock()
+			for _, v := range registers[address : address+quantity] {
+				response = binary.BigEndian.AppendUint16(response, v)
+			}
+			s.mu.Unlock()
+		case FuncCodeWriteSingleRegister:
+			s.mu.Lock()
+			registers[address] = binary.BigEndian.Uint16(pdu[3:5])
+			s.mu.Unlock()
+			response = pdu
+		case FuncCodeWriteMultipleRegisters:
+			quantity := binary.BigEndian.Uint16(pdu[3:5])
+			s.mu.Lock()
+			for i := uint16(0); i < quantity; i++ {
+				registers[address+i] = binary.BigEndian.Uint16(pdu[6+2*i:])
+			}
+			s.mu.Unlock()
+			response = pdu[:5]
+		default:
+			response = exceptionPDU(pdu[0], ExceptionCodeIllegalFunction)
+		}
+		out, _ := packager.Pack(slave, response)
+		// Answer in two writes, as a slow serial line would
+		port.Write(out[:3])
+		port.Write(out[3:])
+	}
+}
+
+// newTestRTUBus returns a bus wired to simulated slaves
+func newTestRTUBus(t *testing.T, slaves *testRTUSlaves) *RTUBus {
+	busEnd, slaveEnd := net.Pipe()
+	go slaves.serve(slaveEnd)
+	bus := NewRTUBus(busEnd, 100*time.Millisecond)
+	t.Cleanup(func() {
+		bus.Close()
+		slaveEnd.Close()
+	})
+	return bus
+}
+
+func startTestGateway(t *testing.T, gateway *TCPRTUGateway) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- gateway.Serve(ln) }()
+	t.Cleanup(func() {
+		gateway.Close()
+		if err := <-served; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+func newTestTCPClient(addr string, unit uint8) (Client, *TCPClientHandler) {
+	handler := NewTCPClientHandler(addr)
+	handler.Timeout = 2 * time.Second
+	handler.SetSlaverId(unit)
+	return NewClient(handler), handler
+}
+
+func TestTCPRTUGateway(t *testing.T) {
+	busA := &testRTUSlaves{registers: map[uint8][]uint16{1: {10, 20, 30}, 2: {40}}}
+	busB := &testRTUSlaves{registers: map[uint8][]uint16{7: {70, 71}}}
+	gateway := NewTCPRTUGateway()
+	gateway.RouteRange(newTestRTUBus(t, busA), 1, 5)
+	gateway.Route(newTestRTUBus(t, busB), 7)
+	addr := startTestGateway(t, gateway)
+
+	client, handler := newTestTCPClient(addr, 1)
+	defer handler.Close()
+	results, err := client.ReadHoldingRegisters(0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := []uint16{binary.BigEndian.Uint16(results), binary.BigEndian.Uint16(results[4:])}; got[0] != 10 || got[1] != 30 {
+		t.Errorf("unit 1 registers = % X", results)
+	}
+	if _, err := client.WriteMultipleRegisters(1, 2, []byte{0x01, 0x02, 0x03, 0x04}); err != nil {
+		t.Fatal(err)
+	}
+	if v := busA.registers[1][2]; v != 0x0304 {
+		t.Errorf("register 2 after write = %#x", v)
+	}
+
+	// Another connection reaches another bus
+	client7, handler7 := newTestTCPClient(addr, 7)
+	defer handler7.Close()
+	if results, err := client7.ReadHoldingRegisters(1, 1); err != nil || binary.BigEndian.Uint16(results) != 71 {
+		t.Errorf("unit 7 read = % X, %v", results, err)
+	}
+
+	// Slave exceptions are passed through
+	_, err = client.ReadHoldingRegisters(2, 5)
+	var mbErr *ModbusError
+	if !errors.As(err, &mbErr) || mbErr.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Errorf("expected illegal data address exception, got %v", err)
+	}
+
+	// No route: 0x0A, no response from a routed slave: 0x0B
+	client.SetSlaveId(9)
+	_, err = client.ReadHoldingRegisters(0, 1)
+	if !errors.As(err, &mbErr) || mbErr.ExceptionCode != ExceptionCodeGatewayPathUnavailable {
+		t.Errorf("expected gateway path unavailable, got %v", err)
+	}
+	client.SetSlaveId(3)
+	_, err = client.ReadHoldingRegisters(0, 1)
+	if !errors.As(err, &mbErr) || mbErr.ExceptionCode != ExceptionCodeGatewayTargetDeviceFailedToRespond {
+		t.Errorf("expected gateway target failed to respond, got %v", err)
+	}
+
+	// The bus recovers after a timeout
+	client.SetSlaveId(2)
+	if results, err := client.ReadHoldingRegisters(0, 1); err != nil || binary.BigEndian.Uint16(results) != 40 {
+		t.Errorf("unit 2 read after timeout = % X, %v", results, err)
+	}
+}
+
+func TestRTUBusConcurrentRequests(t *testing.T) {
+	slaves := &testRTUSlaves{registers: map[uint8][]uint16{1: make([]uint16, 10)}}
+	bus := newTestRTUBus(t, slaves)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pdu := []byte{FuncCodeWriteSingleRegister, 0, byte(i), 0, byte(i * 3)}
+			if response, err := bus.Transact(1, pdu); err != nil || len(response) != 5 {
+				t.Errorf("write %d: % X, %v", i, response, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, v := range slaves.registers[1] {
+		if v != uint16(i*3) {
+			t.Errorf("register %d = %d, expected %d", i, v, i*3)
+		}
+	}
+
+	for _, tc := range []struct {
+		frame  []byte
+		length int
+	}{
+		{[]byte{1}, 0},
+		{[]byte{1, 3}, 0},
+		{[]byte{1, 3, 4}, 9},
+		{[]byte{1, 0x83}, 5},
+		{[]byte{1, 6}, 8},
+		{[]byte{1, 0x2B}, -1},
+	} {
+		if got := rtuResponseLength(tc.frame); got != tc.length {
+			t.Errorf("rtuResponseLength(% X) = %d, expected %d", tc.frame, got, tc.length)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 )
 
 // Import the actual function from your package
@@ -108,6 +109,7 @@ func readGroup(client Client, group []DeviceRegister) ([]DeviceRegister, error)
 		return nil, fmt.Errorf("unsupported Modbus function code: %d", group[0].Function)
 	}
 
+	sourceTime := time.Now()
 	switch group[0].Function {
 	case 1:
 		data, err = client.ReadCoils(start, totalQuantity)
@@ -119,9 +121,12 @@ func readGroup(client Client, group []DeviceRegister) ([]DeviceRegister, error)
 		data, err = client.ReadInputRegisters(start, totalQuantity)
 	}
 
+	receiveTime := time.Now()
+
 	if err != nil {
+		reason, exceptionCode := classifyReadError(err)
 		for i := range group {
-			group[i].Status = fmt.Sprintf("INVALID:%s", err)
+			group[i].setBad(reason, exceptionCode, err.Error(), receiveTime)
 		}
 		return group, fmt.Errorf("modbus read error (slave %d, addr %d): %w",
 			group[0].SlaverId, start, err)
@@ -134,7 +139,9 @@ func readGroup(client Client, group []DeviceRegister) ([]DeviceRegister, error)
 		if offset+expectedLength > len(data) {
 			msg := fmt.Sprintf("Data out of bounds for register %d (SlaverId=%d, ReadAddress=%d, offset=%d, expected=%d, dataLength=%d)",
 				i, group[i].SlaverId, group[i].ReadAddress, offset, expectedLength, len(data))
-			group[i].Status = "INVALID:" + msg
+			for j := i; j < len(group); j++ {
+				group[j].setBad(ReasonDecodeError, 0, msg, receiveTime)
+			}
 			return group, errors.New(msg)
 		}
 
@@ -147,7 +154,7 @@ func readGroup(client Client, group []DeviceRegister) ([]DeviceRegister, error)
 
 		// Copy data safely
 		copy(group[i].Value, data[offset:offset+expectedLength])
-		group[i].Status = "VALID:OK"
+		group[i].setGood(sourceTime, receiveTime)
 		offset += expectedLength
 	}
 
@@ -0,0 +1,360 @@
+package modbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HistorianTier is one resolution kept by a Historian. A Step of zero keeps the raw
+// samples; otherwise samples are downsampled into Step wide min/max/avg buckets.
+// Points is the capacity of the ring buffer, so a tier covers about Step*Points.
+type HistorianTier struct {
+	Step   time.Duration `json:"step"`
+	Points int           `json:"points"`
+}
+
+// DefaultHistorianTiers keeps 3600 raw samples, 24 hours of minutes and 30 days of hours
+var DefaultHistorianTiers = []HistorianTier{
+	{Step: 0, Points: 3600},
+	{Step: time.Minute, Points: 1440},
+	{Step: time.Hour, Points: 720},
+}
+
+// HistoryPoint is a sample or a downsampled bucket of a tag
+type HistoryPoint struct {
+	Time  time.Time `json:"time"` // Sample time, or start of the bucket
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Count int       `json:"count"` // Number of raw samples in the bucket
+}
+
+// merge adds the samples of other to the bucket
+func (p *HistoryPoint) merge(other HistoryPoint) {
+	if p.Count == 0 {
+		*p = HistoryPoint{Time: p.Time, Min: other.Min, Max: other.Max, Avg: other.Avg, Count: other.Count}
+		return
+	}
+	total := p.Count + other.Count
+	p.Avg = (p.Avg*float64(p.Count) + other.Avg*float64(other.Count)) / float64(total)
+	p.Min = math.Min(p.Min, other.Min)
+	p.Max = math.Max(p.Max, other.Max)
+	p.Count = total
+}
+
+// historyRing is a fixed-capacity ring buffer of points, oldest first
+type historyRing struct {
+	Points []HistoryPoint `json:"points"`
+	Start  int            `json:"start"`
+	Size   int            `json:"size"`
+}
+
+func newHistoryRing(capacity int) *historyRing {
+	return &historyRing{Points: make([]HistoryPoint, capacity)}
+}
+
+func (r *historyRing) push(p HistoryPoint) {
+	if len(r.Points) == 0 {
+		return
+	}
+	if r.Size < len(r.Points) {
+		r.Points[(r.Start+r.Size)%len(r.Points)] = p
+		r.Size++
+		return
+	}
+	r.Points[r.Start] = p
+	r.Start = (r.Start + 1) % len(r.Points)
+}
+
+func (r *historyRing) at(i int) HistoryPoint {
+	return r.Points[(r.Start+i)%len(r.Points)]
+}
+
+// historyTier holds the points of one tag at one resolution
+type historyTier struct {
+	Step time.Duration `json:"step"`
+	Ring *historyRing  `json:"ring"`
+	Open *HistoryPoint `json:"open,omitempty"` // Bucket being filled, not yet in Ring
+}
+
+// add records a sample. Samples older than the newest point of the tier are ignored.
+func (t *historyTier) add(at time.Time, v float64) {
+	sample := HistoryPoint{Time: at, Min: v, Max: v, Avg: v, Count: 1}
+	if t.Step == 0 {
+		if t.Ring.Size > 0 && !at.After(t.Ring.at(t.Ring.Size-1).Time) {
+			return
+		}
+		t.Ring.push(sample)
+		return
+	}
+	start := at.Truncate(t.Step)
+	if t.Open != nil {
+		if start.Before(t.Open.Time) {
+			return
+		}
+		if start.Equal(t.Open.Time) {
+			t.Open.merge(sample)
+			return
+		}
+		t.Ring.push(*t.Open)
+	}
+	sample.Time = start
+	t.Open = &sample
+}
+
+// points returns the points of the tier between from and to, oldest first
+func (t *historyTier) points(from, to time.Time) []HistoryPoint {
+	var out []HistoryPoint
+	appendPoint := func(p HistoryPoint) {
+		if !p.Time.Before(from) && p.Time.Before(to) {
+			out = append(out, p)
+		}
+	}
+	for i := 0; i < t.Ring.Size; i++ {
+		appendPoint(t.Ring.at(i))
+	}
+	if t.Open != nil {
+		appendPoint(*t.Open)
+	}
+	return out
+}
+
+// oldest returns the time of the oldest point of the tier
+func (t *historyTier) oldest() (time.Time, bool) {
+	if t.Ring.Size > 0 {
+		return t.Ring.at(0).Time, true
+	}
+	if t.Open != nil {
+		return t.Open.Time, true
+	}
+	return time.Time{}, false
+}
+
+// Historian keeps the recent history of every tag in ring buffers at several
+// resolutions and answers range queries with min/max/avg downsampling.
+type Historian struct {
+	mu    sync.RWMutex
+	tiers []HistorianTier
+	tags  map[string][]*historyTier
+}
+
+// NewHistorian creates a Historian with the given tiers, DefaultHistorianTiers if none.
+func NewHistorian(tiers ...HistorianTier) (*Historian, error) {
+	if len(tiers) == 0 {
+		tiers = DefaultHistorianTiers
+	}
+	tiers = append([]HistorianTier(nil), tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Step < tiers[j].Step })
+	for i, tier := range tiers {
+		if tier.Step < 0 || tier.Points <= 0 {
+			return nil, fmt.Errorf("invalid historian tier: step %v, %d points", tier.Step, tier.Points)
+		}
+		if i > 0 && tier.Step == tiers[i-1].Step {
+			return nil, fmt.Errorf("duplicate historian tier step: %v", tier.Step)
+		}
+	}
+	return &Historian{tiers: tiers, tags: make(map[string][]*historyTier)}, nil
+}
+
+// Record adds the values of the registers to their history. Registers with bad
+// quality or values that cannot be decoded are skipped. Samples are timestamped with
+// SourceTime, or the current time when it is not set.
+func (h *Historian) Record(registers []DeviceRegister) {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, reg := range registers {
+		decoded, err := reg.DecodeValue()
+		if err != nil || decoded.Quality == QualityBad || math.IsNaN(decoded.Float64) || math.IsInf(decoded.Float64, 0) {
+			continue
+		}
+		at := reg.SourceTime
+		if at.IsZero() {
+			at = now
+		}
+		h.record(reg.Tag, at, decoded.Float64)
+	}
+}
+
+// RecordGroups adds every group of a read cycle
+func (h *Historian) RecordGroups(groups [][]DeviceRegister) {
+	for _, group := range groups {
+		h.Record(group)
+	}
+}
+
+// RecordValue adds a single sample of a tag
+func (h *Historian) RecordValue(tag string, at time.Time, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.record(tag, at, value)
+}
+
+// record adds a sample to every tier of a tag. Caller must hold the mutex.
+func (h *Historian) record(tag string, at time.Time, value float64) {
+	tiers, ok := h.tags[tag]
+	if !ok {
+		for _, tier := range h.tiers {
+			tiers = append(tiers, &historyTier{Step: tier.Step, Ring: newHistoryRing(tier.Points)})
+		}
+		h.tags[tag] = tiers
+	}
+	for _, tier := range tiers {
+		tier.add(at, value)
+	}
+}
+
+// Tags returns the tags with history, sorted by name
+func (h *Historian) Tags() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	tags := make([]string, 0, len(h.tags))
+	for tag := range h.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// History returns the history of a tag in [from, to). With a step of zero the points
+// of the finest tier covering from are returned as stored; otherwise they are
+// aggregated into step wide buckets aligned to multiples of step. The finest tier
+// that is not coarser than step and still holds data back to from is used, or the
+// tier reaching furthest back when none does.
+func (h *Historian) History(tag string, from, to time.Time, step time.Duration) ([]HistoryPoint, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("invalid history range: %v to %v", from, to)
+	}
+	if step < 0 {
+		return nil, fmt.Errorf("invalid history step: %v", step)
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	tiers, ok := h.tags[tag]
+	if !ok {
+		return nil, fmt.Errorf("no history for tag: %s", tag)
+	}
+
+	var best *historyTier
+	var furthest *historyTier
+	var furthestTime time.Time
+	for _, tier := range tiers {
+		oldest, ok := tier.oldest()
+		if !ok {
+			continue
+		}
+		if furthest == nil || oldest.Before(furthestTime) {
+			furthest, furthestTime = tier, oldest
+		}
+		// A tier that never wrapped still holds everything recorded
+		covers := !oldest.After(from) || tier.Ring.Size < len(tier.Ring.Points)
+		if best == nil && (step == 0 || tier.Step <= step) && covers {
+			best = tier
+		}
+	}
+	if best == nil {
+		best = furthest
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	points := best.points(from, to)
+	if step == 0 || step <= best.Step {
+		return points, nil
+	}
+	var out []HistoryPoint
+	for _, p := range points {
+		start := p.Time.Truncate(step)
+		if n := len(out); n > 0 && out[n-1].Time.Equal(start) {
+			out[n-1].merge(p)
+			continue
+		}
+		bucket := HistoryPoint{Time: start}
+		bucket.merge(p)
+		out = append(out, bucket)
+	}
+	return out, nil
+}
+
+// historianSnapshot is the on-disk format of a Historian
+type historianSnapshot struct {
+	Tiers []HistorianTier           `json:"tiers"`
+	Tags  map[string][]*historyTier `json:"tags"`
+}
+
+// SaveFile writes the history to path, replacing it atomically.
+func (h *Historian) SaveFile(path string) error {
+	h.mu.RLock()
+	data, err := json.Marshal(historianSnapshot{Tiers: h.tiers, Tags: h.tags})
+	h.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("historian: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("historian: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("historian: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("historian: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("historian: %w", err)
+	}
+	return nil
+}
+
+// LoadFile replaces the history with the content of a file written by SaveFile. The
+// tiers of the file, and of every tag in it, must match the tiers of the Historian.
+func (h *Historian) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("historian: %w", err)
+	}
+	var snapshot historianSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("historian: %w", err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(snapshot.Tiers) != len(h.tiers) {
+		return fmt.Errorf("historian: file has %d tiers, expected %d", len(snapshot.Tiers), len(h.tiers))
+	}
+	for i, tier := range snapshot.Tiers {
+		if tier != h.tiers[i] {
+			return fmt.Errorf("historian: file tier %v/%d does not match %v/%d", tier.Step, tier.Points, h.tiers[i].Step, h.tiers[i].Points)
+		}
+	}
+	for tag, tiers := range snapshot.Tags {
+		if len(tiers) != len(h.tiers) {
+			return fmt.Errorf("historian: tag %s has %d tiers, expected %d", tag, len(tiers), len(h.tiers))
+		}
+		for i, tier := range tiers {
+			if tier == nil || tier.Ring == nil || len(tier.Ring.Points) != h.tiers[i].Points ||
+				tier.Ring.Size < 0 || tier.Ring.Size > len(tier.Ring.Points) || tier.Ring.Start < 0 || tier.Ring.Start >= len(tier.Ring.Points) {
+				return fmt.Errorf("historian: tag %s has a corrupt tier %d", tag, i)
+			}
+			if tier.Step != h.tiers[i].Step {
+				return fmt.Errorf("historian: tag %s tier %d has step %v, expected %v", tag, i, tier.Step, h.tiers[i].Step)
+			}
+		}
+	}
+	if snapshot.Tags == nil {
+		snapshot.Tags = make(map[string][]*historyTier)
+	}
+	h.tags = snapshot.Tags
+	return nil
+}
@@ -0,0 +1,159 @@
+package modbus
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistorianDownsampling(t *testing.T) {
+	historian, err := NewHistorian(
+		HistorianTier{Step: 0, Points: 120},
+		HistorianTier{Step: time.Minute, Points: 60},
+		HistorianTier{Step: 10 * time.Minute, Points: 10},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// One sample every 10 seconds for 30 minutes: value = minute index
+	for i := 0; i < 180; i++ {
+		historian.RecordValue("flow", start.Add(time.Duration(i)*10*time.Second), float64(i/6))
+	}
+
+	// Recent raw samples are still in the raw tier
+	raw, err := historian.History("flow", start.Add(29*time.Minute), start.Add(30*time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 6 || raw[0].Count != 1 || raw[0].Avg != 29 {
+		t.Errorf("raw history = %+v, expected 6 samples of 29", raw)
+	}
+
+	// The raw tier wrapped after 20 minutes, so older ranges come from the minute tier
+	minutes, err := historian.History("flow", start, start.Add(30*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(minutes) != 30 || minutes[3].Count != 6 || minutes[3].Avg != 3 || !minutes[3].Time.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("minute history has %d points, point 3 = %+v", len(minutes), minutes[3])
+	}
+
+	// Five minute buckets are aggregated from the minute tier
+	buckets, err := historian.History("flow", start, start.Add(30*time.Minute), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 6 {
+		t.Fatalf("5 minute history has %d points, expected 6", len(buckets))
+	}
+	if b := buckets[1]; b.Min != 5 || b.Max != 9 || b.Avg != 7 || b.Count != 30 {
+		t.Errorf("bucket 1 = %+v, expected min 5, max 9, avg 7 over 30 samples", b)
+	}
+
+	if _, err := historian.History("missing", start, start.Add(time.Hour), 0); err == nil {
+		t.Errorf("expected error for a tag without history")
+	}
+	if _, err := historian.History("flow", start, start, 0); err == nil {
+		t.Errorf("expected error for an empty range")
+	}
+
+	// Persisted history loads back identically
+	path := filepath.Join(t.TempDir(), "history.json")
+	if err := historian.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := NewHistorian(
+		HistorianTier{Step: 0, Points: 120},
+		HistorianTier{Step: time.Minute, Points: 60},
+		HistorianTier{Step: 10 * time.Minute, Points: 10},
+	)
+	if err := restored.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := restored.History("flow", start, start.Add(30*time.Minute), 5*time.Minute)
+	if len(again) != len(buckets) || again[1] != buckets[1] {
+		t.Errorf("restored history differs: %+v", again)
+	}
+	other, _ := NewHistorian()
+	if err := other.LoadFile(path); err == nil {
+		t.Errorf("expected error loading a file with different tiers")
+	}
+}
+
+func TestHistorianLoadCorruptFile(t *testing.T) {
+	historian, _ := NewHistorian(HistorianTier{Step: 0, Points: 4})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		historian.RecordValue("flow", start.Add(time.Duration(i)*time.Second), float64(i))
+	}
+	path := filepath.Join(t.TempDir(), "history.json")
+	if err := historian.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(tier *historyTier)
+	}{
+		{"negative size", func(tier *historyTier) { tier.Ring.Size = -1 }},
+		{"size beyond capacity", func(tier *historyTier) { tier.Ring.Size = 5 }},
+		{"negative start", func(tier *historyTier) { tier.Ring.Start = -1 }},
+		{"start beyond capacity", func(tier *historyTier) { tier.Ring.Start = 4 }},
+		{"missing points", func(tier *historyTier) { tier.Ring.Points = tier.Ring.Points[:2] }},
+		{"different step", func(tier *historyTier) { tier.Step = time.Minute }},
+	} {
+		var snapshot historianSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		tc.modify(snapshot.Tags["flow"][0])
+		corrupt, _ := json.Marshal(snapshot)
+		if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := historian.LoadFile(path); err == nil {
+			t.Errorf("%s: expected an error loading a corrupt file", tc.name)
+		}
+		// The history in memory is kept
+		if points, err := historian.History("flow", start, start.Add(time.Minute), 0); err != nil || len(points) != 3 {
+			t.Errorf("%s: history after a failed load = %+v, %v", tc.name, points, err)
+		}
+	}
+}
+
+func TestRegisterManagerHistorian(t *testing.T) {
+	client := newMemoryClient()
+	client.setRegisters(1, 0, 100)
+	manager := NewRegisterManager(client, 10)
+	if err := manager.LoadRegisters([]DeviceRegister{
+		{Tag: "level", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16", Weight: 0.5},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	historian, err := NewHistorian()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetHistorian(historian)
+	for _, v := range []uint16{100, 120, 140} {
+		client.setRegisters(1, 0, v)
+		if errs := manager.ReadGroupedData(); len(errs) > 0 {
+			t.Fatalf("ReadGroupedData failed: %v", errs)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	points, err := historian.History("level", time.Now().Add(-time.Hour), time.Now().Add(time.Second), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 || points[0].Avg != 50 || points[2].Avg != 70 {
+		t.Errorf("history = %+v, expected 50, 60, 70", points)
+	}
+}
@@ -0,0 +1,510 @@
+package modbus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// HTTPHandler exposes tags and raw Modbus access as a REST/JSON API:
+//
+//	GET  /devices                          devices with their tags
+//	GET  /tags                             tag definitions
+//	GET  /values                           current values of all tags
+//	GET  /tags/{tag}                       current value of a tag
+//	PUT  /tags/{tag}                       write a typed value, e.g. 21.5 or {"value": true}
+//	GET  /units/{unit}/{table}/{address}   raw read, ?count=N, default 1
+//	PUT  /units/{unit}/{table}/{address}   raw write, e.g. [1, 2] or {"values": [true]}
+//
+// Tables are coils, discrete-inputs, holding-registers and input-registers. Values are
+// served from Cache when set, and read from the device otherwise with one request per
+// register group. Raw requests use API when set, and the client of Manager otherwise,
+// serialized with polling. Errors are returned as {"error": "..."} with status 404 for
+// unknown tags, 400 for invalid requests, 503 for writes to tags whose scale factor has
+// not been read, 502 for Modbus exceptions and communication errors, and 504 for timeouts.
+type HTTPHandler struct {
+	Manager     *RegisterManager // Tags and device access, optional when API is set
+	Cache       *TagCache        // Last known values, optional
+	API         ModbusApi        // Raw access, optional
+	DeviceNames map[uint8]string // Device name per slave id, "slave<id>" if missing
+
+	once sync.Once
+	mux  *http.ServeMux
+}
+
+// NewHTTPHandler creates a handler for the tags and client of manager
+func NewHTTPHandler(manager *RegisterManager) *HTTPHandler {
+	return &HTTPHandler{Manager: manager}
+}
+
+// ServeHTTP implements http.Handler
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.once.Do(func() {
+		h.mux = http.NewServeMux()
+		h.mux.HandleFunc("GET /devices", h.devices)
+		h.mux.HandleFunc("GET /tags", h.tags)
+		h.mux.HandleFunc("GET /values", h.values)
+		h.mux.HandleFunc("GET /tags/{tag}", h.readTag)
+		h.mux.HandleFunc("PUT /tags/{tag}", h.writeTag)
+		h.mux.HandleFunc("POST /tags/{tag}", h.writeTag)
+		h.mux.HandleFunc("GET /units/{unit}/{table}/{address}", h.readRaw)
+		h.mux.HandleFunc("PUT /units/{unit}/{table}/{address}", h.writeRaw)
+		h.mux.HandleFunc("POST /units/{unit}/{table}/{address}", h.writeRaw)
+	})
+	h.mux.ServeHTTP(w, r)
+}
+
+// HTTPDevice is a device as listed by GET /devices
+type HTTPDevice struct {
+	Slave uint8    `json:"slave"`
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags"`
+}
+
+// HTTPTag is a tag definition as listed by GET /tags
+type HTTPTag struct {
+	Tag      string `json:"tag"`
+	Alias    string `json:"alias,omitempty"`
+	UUID     string `json:"uuid,omitempty"`
+	Slave    uint8  `json:"slave"`
+	Function uint8  `json:"function"`
+	Address  uint16 `json:"address"`
+	Quantity uint16 `json:"quantity"`
+	DataType string `json:"dataType"`
+	Writable bool   `json:"writable"`
+}
+
+// httpError is an error with the HTTP status to report it with
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string { return e.err.Error() }
+func (e *httpError) Unwrap() error { return e.err }
+
+func badRequest(format string, args ...any) error {
+	return &httpError{status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+func (h *HTTPHandler) registers() ([]DeviceRegister, error) {
+	if h.Manager == nil {
+		return nil, &httpError{status: http.StatusNotFound, err: errors.New("no register manager configured")}
+	}
+	return h.Manager.Registers(), nil
+}
+
+func (h *HTTPHandler) devices(w http.ResponseWriter, r *http.Request) {
+	registers, err := h.registers()
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	bySlave := make(map[uint8]*HTTPDevice)
+	var devices []*HTTPDevice
+	for _, reg := range registers {
+		device, ok := bySlave[reg.SlaverId]
+		if !ok {
+			name := h.DeviceNames[reg.SlaverId]
+			if name == "" {
+				name = "slave" + strconv.Itoa(int(reg.SlaverId))
+			}
+			device = &HTTPDevice{Slave: reg.SlaverId, Name: name}
+			bySlave[reg.SlaverId] = device
+			devices = append(devices, device)
+		}
+		device.Tags = append(device.Tags, reg.Tag)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Slave < devices[j].Slave })
+	for _, device := range devices {
+		sort.Strings(device.Tags)
+	}
+	writeHTTPJSON(w, http.StatusOK, devices)
+}
+
+func (h *HTTPHandler) tags(w http.ResponseWriter, r *http.Request) {
+	registers, err := h.registers()
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	tags := make([]HTTPTag, len(registers))
+	for i, reg := range registers {
+		tags[i] = HTTPTag{
+			Tag:      reg.Tag,
+			Alias:    reg.Alias,
+			UUID:     reg.UUID,
+			Slave:    reg.SlaverId,
+			Function: reg.Function,
+			Address:  reg.ReadAddress,
+			Quantity: reg.ReadQuantity,
+			DataType: reg.DataType,
+			Writable: reg.Function == FuncCodeReadCoils || reg.Function == FuncCodeReadHoldingRegisters,
+		}
+	}
+	// Registers come grouped for polling, in no fixed order
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, b := tags[i], tags[j]
+		if a.Slave != b.Slave {
+			return a.Slave < b.Slave
+		}
+		if a.Function != b.Function {
+			return a.Function < b.Function
+		}
+		return a.Address < b.Address
+	})
+	writeHTTPJSON(w, http.StatusOK, tags)
+}
+
+func (h *HTTPHandler) values(w http.ResponseWriter, r *http.Request) {
+	if h.Cache != nil {
+		snapshot := h.Cache.Snapshot()
+		records := make([]RegisterRecord, len(snapshot))
+		for i, v := range snapshot {
+			records[i] = cachedRecord(v)
+		}
+		writeHTTPJSON(w, http.StatusOK, records)
+		return
+	}
+	if _, err := h.registers(); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	// Failed reads are reported by the quality of the records
+	registers, _ := h.Manager.ReadTags()
+	records := make([]RegisterRecord, len(registers))
+	for i, reg := range registers {
+		records[i] = newRegisterRecord(reg)
+	}
+	writeHTTPJSON(w, http.StatusOK, records)
+}
+
+func (h *HTTPHandler) readTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+	if h.Cache != nil {
+		v, ok := h.Cache.Lookup(tag)
+		if !ok {
+			writeHTTPError(w, &httpError{status: http.StatusNotFound, err: fmt.Errorf("no value for tag: %s", tag)})
+			return
+		}
+		writeHTTPJSON(w, http.StatusOK, cachedRecord(v))
+		return
+	}
+	if err := h.checkTag(tag); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	reg, err := h.Manager.ReadTag(tag)
+	if err != nil {
+		writeHTTPError(w, deviceError(err))
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, newRegisterRecord(reg))
+}
+
+func (h *HTTPHandler) writeTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+	if err := h.checkTag(tag); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		writeHTTPError(w, badRequest("read body: %v", err))
+		return
+	}
+	if err := h.Manager.WriteTag(tag, parseTagValue(body)); err != nil {
+		var valueErr *ValueError
+		if errors.As(err, &valueErr) {
+			err = &httpError{status: http.StatusBadRequest, err: err}
+		} else if errors.Is(err, ErrScaleFactorUnavailable) {
+			err = &httpError{status: http.StatusServiceUnavailable, err: err}
+		}
+		writeHTTPError(w, deviceError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkTag reports unknown tags as not found
+func (h *HTTPHandler) checkTag(tag string) error {
+	registers, err := h.registers()
+	if err != nil {
+		return err
+	}
+	for _, reg := range registers {
+		if reg.Tag == tag {
+			return nil
+		}
+	}
+	return &httpError{status: http.StatusNotFound, err: fmt.Errorf("unknown tag: %s", tag)}
+}
+
+// cachedRecord builds the record of a cached value, with its staleness-aware quality
+func cachedRecord(v TagValue) RegisterRecord {
+	record := newRegisterRecord(v.Register)
+	record.Quality, record.QualityReason = v.Quality, v.QualityReason
+	return record
+}
+
+// rawRequest is a parsed raw access path
+type rawRequest struct {
+	unit    uint8
+	table   string
+	address uint16
+}
+
+func parseRawRequest(r *http.Request) (rawRequest, error) {
+	unit, err := strconv.ParseUint(r.PathValue("unit"), 10, 8)
+	if err != nil {
+		return rawRequest{}, badRequest("invalid unit: %s", r.PathValue("unit"))
+	}
+	address, err := strconv.ParseUint(r.PathValue("address"), 0, 16)
+	if err != nil {
+		return rawRequest{}, badRequest("invalid address: %s", r.PathValue("address"))
+	}
+	req := rawRequest{unit: uint8(unit), table: r.PathValue("table"), address: uint16(address)}
+	switch req.table {
+	case "coils", "discrete-inputs", "holding-registers", "input-registers":
+		return req, nil
+	}
+	return rawRequest{}, badRequest("unknown table: %s", req.table)
+}
+
+func (req rawRequest) bits() bool {
+	return req.table == "coils" || req.table == "discrete-inputs"
+}
+
+func (h *HTTPHandler) readRaw(w http.ResponseWriter, r *http.Request) {
+	req, err := parseRawRequest(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	count := uint64(1)
+	if s := r.URL.Query().Get("count"); s != "" {
+		if count, err = strconv.ParseUint(s, 10, 16); err != nil {
+			writeHTTPError(w, badRequest("invalid count: %s", s))
+			return
+		}
+	}
+	limit := uint64(125)
+	if req.bits() {
+		limit = 2000
+	}
+	if count == 0 || count > limit {
+		writeHTTPError(w, badRequest("count must be between 1 and %d", limit))
+		return
+	}
+	values, err := h.rawRead(req, uint16(count))
+	if err != nil {
+		writeHTTPError(w, deviceError(err))
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, map[string]any{
+		"unit": req.unit, "table": req.table, "address": req.address, "values": values,
+	})
+}
+
+func (h *HTTPHandler) writeRaw(w http.ResponseWriter, r *http.Request) {
+	req, err := parseRawRequest(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if req.table != "coils" && req.table != "holding-registers" {
+		writeHTTPError(w, badRequest("table %s is read-only", req.table))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		writeHTTPError(w, badRequest("read body: %v", err))
+		return
+	}
+	value := parseTagValue(body)
+	if object, ok := value.(map[string]any); ok {
+		value = object["values"]
+	}
+	var list []any
+	switch v := value.(type) {
+	case []any:
+		list = v
+	case []bool:
+		for _, b := range v {
+			list = append(list, b)
+		}
+	case nil:
+	default:
+		list = []any{v}
+	}
+	if len(list) == 0 {
+		writeHTTPError(w, badRequest("no values to write"))
+		return
+	}
+	limit := 123
+	if req.bits() {
+		limit = 1968
+	}
+	if len(list) > limit {
+		writeHTTPError(w, badRequest("at most %d values can be written", limit))
+		return
+	}
+
+	if req.bits() {
+		bits := make([]bool, len(list))
+		for i, v := range list {
+			if bits[i], err = toBool(v); err != nil {
+				writeHTTPError(w, badRequest("value %d: %v", i, err))
+				return
+			}
+		}
+		err = h.rawWriteCoils(req, bits)
+	} else {
+		registers := make([]uint16, len(list))
+		for i, v := range list {
+			f, err := toFloat64(v)
+			if err != nil || f != math.Trunc(f) || f < 0 || f > math.MaxUint16 {
+				writeHTTPError(w, badRequest("value %d: %v is not a register value", i, v))
+				return
+			}
+			registers[i] = uint16(f)
+		}
+		err = h.rawWriteRegisters(req, registers)
+	}
+	if err != nil {
+		writeHTTPError(w, deviceError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// withClient runs fn with the client of the manager
+func (h *HTTPHandler) withClient(unit uint8, fn func(client Client) error) error {
+	if h.Manager == nil {
+		return &httpError{status: http.StatusNotFound, err: errors.New("no Modbus client configured")}
+	}
+	return h.Manager.withClient(func(client Client) error {
+		client.SetSlaveId(unit)
+		return fn(client)
+	})
+}
+
+// rawRead reads count coils or inputs as []bool, or registers as []uint16
+func (h *HTTPHandler) rawRead(req rawRequest, count uint16) (any, error) {
+	if api := h.API; api != nil {
+		unit := uint16(req.unit)
+		switch req.table {
+		case "coils":
+			return api.ReadCoils(unit, req.address, count)
+		case "discrete-inputs":
+			return api.ReadDiscreteInputs(unit, req.address, count)
+		case "holding-registers":
+			return api.ReadHoldingRegisters(unit, req.address, count)
+		default:
+			return api.ReadInputRegisters(unit, req.address, count)
+		}
+	}
+	var values any
+	err := h.withClient(req.unit, func(client Client) error {
+		var data []byte
+		var err error
+		switch req.table {
+		case "coils":
+			data, err = client.ReadCoils(req.address, count)
+		case "discrete-inputs":
+			data, err = client.ReadDiscreteInputs(req.address, count)
+		case "holding-registers":
+			data, err = client.ReadHoldingRegisters(req.address, count)
+		default:
+			data, err = client.ReadInputRegisters(req.address, count)
+		}
+		if err != nil {
+			return err
+		}
+		if req.bits() {
+			values, err = unpackBits(data, count)
+		} else {
+			values, err = unpackRegisters(data, count)
+		}
+		return err
+	})
+	return values, err
+}
+
+func (h *HTTPHandler) rawWriteCoils(req rawRequest, values []bool) error {
+	if api := h.API; api != nil {
+		if len(values) == 1 {
+			return api.WriteSingleCoil(uint16(req.unit), req.address, values[0])
+		}
+		return api.WriteMultipleCoils(uint16(req.unit), req.address, values)
+	}
+	return h.withClient(req.unit, func(client Client) error {
+		if len(values) == 1 {
+			coil := uint16(0x0000)
+			if values[0] {
+				coil = 0xFF00
+			}
+			_, err := client.WriteSingleCoil(req.address, coil)
+			return err
+		}
+		_, err := client.WriteMultipleCoils(req.address, uint16(len(values)), packBits(values))
+		return err
+	})
+}
+
+func (h *HTTPHandler) rawWriteRegisters(req rawRequest, values []uint16) error {
+	if api := h.API; api != nil {
+		if len(values) == 1 {
+			return api.WriteSingleRegister(uint16(req.unit), req.address, values[0])
+		}
+		return api.WriteMultipleRegisters(uint16(req.unit), req.address, values)
+	}
+	return h.withClient(req.unit, func(client Client) error {
+		if len(values) == 1 {
+			_, err := client.WriteSingleRegister(req.address, values[0])
+			return err
+		}
+		_, err := client.WriteMultipleRegisters(req.address, uint16(len(values)), packRegisters(values))
+		return err
+	})
+}
+
+// deviceError attaches the HTTP status of a device error: 504 for timeouts and 502 for
+// exceptions and communication errors. Errors with a status are returned unchanged.
+func deviceError(err error) error {
+	var he *httpError
+	if errors.As(err, &he) {
+		return err
+	}
+	status := http.StatusBadGateway
+	if reason, _ := classifyReadError(err); reason == ReasonTimeout {
+		status = http.StatusGatewayTimeout
+	}
+	return &httpError{status: status, err: err}
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var he *httpError
+	if errors.As(err, &he) {
+		status = he.status
+	}
+	body := map[string]any{"error": err.Error()}
+	var mbErr *ModbusError
+	if errors.As(err, &mbErr) {
+		body["exceptionCode"] = mbErr.ExceptionCode
+	}
+	writeHTTPJSON(w, status, body)
+}
+
+func writeHTTPJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
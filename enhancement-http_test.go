@@ -0,0 +1,265 @@
+package modbus
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func httpTestManager(t *testing.T) (*memoryClient, *RegisterManager) {
+	client := newMemoryClient()
+	client.setRegisters(1, 0, 215, 100)
+	manager := NewRegisterManager(client, 10)
+	if err := manager.LoadRegisters([]DeviceRegister{
+		{Tag: "temperature", Alias: "Boiler temp", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16", Weight: 0.1},
+		{Tag: "setpoint", SlaverId: 1, Function: 3, ReadAddress: 1, ReadQuantity: 1, DataType: "uint16", Weight: 0.1},
+		{Tag: "pump", SlaverId: 2, Function: 1, ReadAddress: 0, ReadQuantity: 1, DataType: "bool", Weight: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return client, manager
+}
+
+func doHTTP(t *testing.T, handler http.Handler, method, path, body string, out any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid JSON %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestHTTPHandlerTags(t *testing.T) {
+	client, manager := httpTestManager(t)
+	handler := NewHTTPHandler(manager)
+	handler.DeviceNames = map[uint8]string{1: "boiler"}
+
+	var devices []HTTPDevice
+	if code := doHTTP(t, handler, "GET", "/devices", "", &devices); code != http.StatusOK {
+		t.Fatalf("GET /devices status %d", code)
+	}
+	if len(devices) != 2 || devices[0].Name != "boiler" || devices[1].Name != "slave2" ||
+		strings.Join(devices[0].Tags, ",") != "setpoint,temperature" {
+		t.Errorf("devices = %+v", devices)
+	}
+	var tags []HTTPTag
+	doHTTP(t, handler, "GET", "/tags", "", &tags)
+	if len(tags) != 3 || tags[0].Alias != "Boiler temp" || !tags[2].Writable {
+		t.Errorf("tags = %+v", tags)
+	}
+
+	var record RegisterRecord
+	if code := doHTTP(t, handler, "GET", "/tags/temperature", "", &record); code != http.StatusOK {
+		t.Fatalf("GET /tags/temperature status %d", code)
+	}
+	if record.Value != 21.5 || record.Quality != QualityGood {
+		t.Errorf("temperature = %+v", record)
+	}
+
+	if code := doHTTP(t, handler, "PUT", "/tags/setpoint", `{"value": 42.5}`, nil); code != http.StatusNoContent {
+		t.Errorf("PUT /tags/setpoint status %d", code)
+	}
+	if v := client.register(1, 1); v != 425 {
+		t.Errorf("setpoint register = %d, expected 425", v)
+	}
+	if code := doHTTP(t, handler, "PUT", "/tags/pump", `"on"`, nil); code != http.StatusNoContent {
+		t.Errorf("PUT /tags/pump status %d", code)
+	}
+	if !client.coils[2][0] {
+		t.Errorf("pump coil not set")
+	}
+
+	var failure map[string]any
+	if code := doHTTP(t, handler, "GET", "/tags/missing", "", &failure); code != http.StatusNotFound || failure["error"] == nil {
+		t.Errorf("GET /tags/missing = %d %v", code, failure)
+	}
+	if code := doHTTP(t, handler, "PUT", "/tags/setpoint", `"warm"`, nil); code != http.StatusBadRequest {
+		t.Errorf("PUT with an invalid value status %d, expected 400", code)
+	}
+	client.readErr = &ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeIllegalDataAddress}
+	if code := doHTTP(t, handler, "GET", "/tags/temperature", "", &failure); code != http.StatusBadGateway || failure["exceptionCode"] != float64(2) {
+		t.Errorf("GET with an exception = %d %v", code, failure)
+	}
+	var records []RegisterRecord
+	doHTTP(t, handler, "GET", "/values", "", &records)
+	if len(records) != 3 || records[0].Quality != QualityBad || records[0].QualityReason != ReasonException {
+		t.Errorf("values during an exception = %+v", records)
+	}
+
+	// With a cache, values are served without reading the device
+	client.readErr = nil
+	cache := NewTagCache(0)
+	manager.SetTagCache(cache)
+	manager.ReadGroupedData()
+	client.readErr = os.ErrDeadlineExceeded
+	handler.Cache = cache
+	if code := doHTTP(t, handler, "GET", "/tags/Boiler%20temp", "", &record); code != http.StatusOK || record.Tag != "temperature" {
+		t.Errorf("GET cached alias = %d %+v", code, record)
+	}
+	doHTTP(t, handler, "GET", "/values", "", &records)
+	if len(records) != 3 || records[1].Tag != "setpoint" || records[1].Value != 42.5 {
+		t.Errorf("cached values = %+v", records)
+	}
+}
+
+// readCountingClient counts the read requests sent to the device
+type readCountingClient struct {
+	*memoryClient
+	reads *atomic.Int32
+}
+
+func (c readCountingClient) ReadCoils(address, quantity uint16) ([]byte, error) {
+	c.reads.Add(1)
+	return c.memoryClient.ReadCoils(address, quantity)
+}
+
+func (c readCountingClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	c.reads.Add(1)
+	return c.memoryClient.ReadHoldingRegisters(address, quantity)
+}
+
+func TestHTTPHandlerValuesReadGroups(t *testing.T) {
+	client, polled := httpTestManager(t)
+	var reads atomic.Int32
+	manager := NewRegisterManager(readCountingClient{client, &reads}, 10)
+	if err := manager.LoadRegisters(polled.Registers()); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHTTPHandler(manager)
+
+	var records []RegisterRecord
+	if code := doHTTP(t, handler, "GET", "/values", "", &records); code != http.StatusOK {
+		t.Fatalf("GET /values status %d", code)
+	}
+	if len(records) != 3 {
+		t.Fatalf("values = %+v", records)
+	}
+	for _, record := range records {
+		if record.Tag == "temperature" && (record.Value != 21.5 || record.Quality != QualityGood) {
+			t.Errorf("temperature = %+v", record)
+		}
+	}
+	// temperature and setpoint are one group, pump another
+	if n := reads.Load(); n != 2 {
+		t.Errorf("GET /values sent %d read requests, expected one per group (2)", n)
+	}
+}
+
+// crcErrorClient fails register writes like an RTU device answering with a bad CRC
+type crcErrorClient struct {
+	*memoryClient
+}
+
+func (c crcErrorClient) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	return nil, errors.New("modbus: response crc '1234' does not match expected '5678'")
+}
+
+func TestHTTPHandlerWriteErrors(t *testing.T) {
+	client := newMemoryClient()
+	manager := NewRegisterManager(crcErrorClient{client}, 10)
+	if err := manager.LoadRegisters([]DeviceRegister{
+		{Tag: "setpoint", SlaverId: 1, Function: 3, ReadAddress: 1, ReadQuantity: 1, DataType: "uint16", Weight: 0.1},
+		{Tag: "flow", SlaverId: 1, Function: 4, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16", Weight: 1},
+		{
+			Tag: "W", SlaverId: 1, Function: 3, ReadAddress: 10, ReadQuantity: 1, DataType: "int16", Weight: 1,
+			Transforms: []ValueTransform{{Type: TransformScaleFactor, ScaleFactorTag: "W_SF"}},
+		},
+		{Tag: "W_SF", SlaverId: 1, Function: 3, ReadAddress: 11, ReadQuantity: 1, DataType: "int16"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHTTPHandler(manager)
+	for _, tc := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/tags/setpoint", "42", http.StatusBadGateway},
+		{"/tags/setpoint", "100000", http.StatusBadRequest},
+		{"/tags/setpoint", `"warm"`, http.StatusBadRequest},
+		{"/tags/flow", "1", http.StatusBadRequest},
+		{"/tags/W", "50", http.StatusServiceUnavailable},
+	} {
+		if code := doHTTP(t, handler, "PUT", tc.path, tc.body, nil); code != tc.status {
+			t.Errorf("PUT %s %s status %d, expected %d", tc.path, tc.body, code, tc.status)
+		}
+	}
+}
+
+func TestHTTPHandlerRaw(t *testing.T) {
+	client, manager := httpTestManager(t)
+	handler := NewHTTPHandler(manager)
+
+	var result struct {
+		Unit   uint8    `json:"unit"`
+		Values []uint16 `json:"values"`
+	}
+	if code := doHTTP(t, handler, "GET", "/units/1/holding-registers/0?count=2", "", &result); code != http.StatusOK {
+		t.Fatalf("raw read status %d", code)
+	}
+	if result.Unit != 1 || len(result.Values) != 2 || result.Values[0] != 215 || result.Values[1] != 100 {
+		t.Errorf("raw read = %+v", result)
+	}
+
+	if code := doHTTP(t, handler, "PUT", "/units/3/holding-registers/0x10", `[1, 2, 3]`, nil); code != http.StatusNoContent {
+		t.Fatalf("raw write status %d", code)
+	}
+	if client.register(3, 16) != 1 || client.register(3, 18) != 3 {
+		t.Errorf("registers after raw write = %v", client.registers[3])
+	}
+	if code := doHTTP(t, handler, "PUT", "/units/3/coils/5", `{"values": [true, false, true]}`, nil); code != http.StatusNoContent {
+		t.Fatalf("raw coil write status %d", code)
+	}
+	var bits struct {
+		Values []bool `json:"values"`
+	}
+	doHTTP(t, handler, "GET", "/units/3/coils/5?count=3", "", &bits)
+	if len(bits.Values) != 3 || !bits.Values[0] || bits.Values[1] || !bits.Values[2] {
+		t.Errorf("raw coil read = %+v", bits)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/units/1/holding-registers/0?count=126", "", http.StatusBadRequest},
+		{"GET", "/units/256/coils/0", "", http.StatusBadRequest},
+		{"GET", "/units/1/files/0", "", http.StatusBadRequest},
+		{"PUT", "/units/1/input-registers/0", "1", http.StatusBadRequest},
+		{"PUT", "/units/1/holding-registers/0", "70000", http.StatusBadRequest},
+		{"PUT", "/units/1/holding-registers/0", jsonList("1", 124), http.StatusBadRequest},
+		{"PUT", "/units/1/coils/0", jsonList("true", 1969), http.StatusBadRequest},
+		{"DELETE", "/units/1/coils/0", "", http.StatusMethodNotAllowed},
+	} {
+		if code := doHTTP(t, handler, tc.method, tc.path, tc.body, nil); code != tc.status {
+			t.Errorf("%s %s status %d, expected %d", tc.method, tc.path, code, tc.status)
+		}
+	}
+
+	if client.register(1, 123) != 0 || client.coils[1][1968] {
+		t.Errorf("writes over the protocol limit reached the device")
+	}
+	if code := doHTTP(t, handler, "PUT", "/units/4/holding-registers/0", jsonList("7", 123), nil); code != http.StatusNoContent {
+		t.Errorf("raw write of 123 registers status %d", code)
+	}
+	if code := doHTTP(t, handler, "PUT", "/units/4/coils/0", jsonList("true", 1968), nil); code != http.StatusNoContent {
+		t.Errorf("raw write of 1968 coils status %d", code)
+	}
+
+	client.readErr = os.ErrDeadlineExceeded
+	if code := doHTTP(t, handler, "GET", "/units/1/input-registers/0", "", nil); code != http.StatusGatewayTimeout {
+		t.Errorf("raw read timeout status %d, expected 504", code)
+	}
+}
+
+// jsonList returns a JSON array of n copies of value
+func jsonList(value string, n int) string {
+	return "[" + strings.TrimSuffix(strings.Repeat(value+",", n), ",") + "]"
+}
@@ -0,0 +1,394 @@
+package modbus
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MQTTBridgeOptions configures an MQTTBridge. Topic templates may contain the
+// placeholders {device}, {slave}, {tag}, {alias} and {uuid}; values are inserted with
+// '/', '+' and '#' replaced by '_'.
+type MQTTBridgeOptions struct {
+	MQTT                 MQTTOptions      // Broker connection; the will and Reconnect are set by the bridge
+	TopicTemplate        string           // Value topics, "modbus/{device}/{tag}" if empty
+	CommandTemplate      string           // Write command topics, TopicTemplate + "/set" if empty, "-" disables commands
+	StatusTopic          string           // Bridge online state and will, "modbus/status" if empty
+	DeviceStatusTemplate string           // Device online state, "modbus/{device}/status" if empty
+	DeviceNames          map[uint8]string // Device name per slave id, "slave<id>" if missing
+	QoS                  byte             // QoS of value and status messages, 0 or 1
+	Retain               bool             // Retain value messages so subscribers get the last value
+}
+
+// MQTTBridge publishes register values of a RegisterManager as JSON RegisterRecord
+// messages and performs typed writes for messages on the command topics. The bridge
+// announces "online" on StatusTopic and registers "offline" as its will, and publishes
+// the online state of each device, derived from the quality of its reads. Device states
+// are retained but cannot have a will of their own: they are current only while
+// StatusTopic is "online". After a lost connection the bridge reconnects and announces
+// itself and the device states again.
+type MQTTBridge struct {
+	manager     *RegisterManager
+	opts        MQTTBridgeOptions
+	command     []string // Command template split into levels
+	commands    chan mqttCommand
+	publishes   chan []DeviceRegister
+	reconnected chan struct{}
+	done        chan struct{}
+	wg          sync.WaitGroup
+
+	mu      sync.Mutex
+	client  *MQTTClient
+	devices map[uint8]bool // Last published online state per slave id
+	onError func(err error)
+}
+
+type mqttCommand struct {
+	topic   string
+	payload []byte
+}
+
+// NewMQTTBridge creates a bridge for manager. Call Start to connect.
+func NewMQTTBridge(manager *RegisterManager, opts MQTTBridgeOptions) (*MQTTBridge, error) {
+	if opts.TopicTemplate == "" {
+		opts.TopicTemplate = "modbus/{device}/{tag}"
+	}
+	if opts.CommandTemplate == "" {
+		opts.CommandTemplate = opts.TopicTemplate + "/set"
+	}
+	if opts.StatusTopic == "" {
+		opts.StatusTopic = "modbus/status"
+	}
+	if opts.DeviceStatusTemplate == "" {
+		opts.DeviceStatusTemplate = "modbus/{device}/status"
+	}
+	if opts.QoS > 1 {
+		return nil, fmt.Errorf("mqtt bridge: unsupported QoS %d", opts.QoS)
+	}
+	if !strings.Contains(opts.TopicTemplate, "{tag}") && !strings.Contains(opts.TopicTemplate, "{uuid}") {
+		return nil, fmt.Errorf("mqtt bridge: topic template %q must contain {tag} or {uuid}", opts.TopicTemplate)
+	}
+	b := &MQTTBridge{
+		manager:     manager,
+		opts:        opts,
+		commands:    make(chan mqttCommand, 64),
+		publishes:   make(chan []DeviceRegister, 64),
+		reconnected: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		devices:     make(map[uint8]bool),
+	}
+	if opts.CommandTemplate != "-" {
+		levels := strings.Split(opts.CommandTemplate, "/")
+		key := false
+		for _, level := range levels {
+			switch level {
+			case "{tag}", "{alias}", "{uuid}":
+				key = true
+			case "{device}", "{slave}":
+			default:
+				if strings.ContainsAny(level, "{}+#") {
+					return nil, fmt.Errorf("mqtt bridge: placeholders must fill a whole level of command template %q", opts.CommandTemplate)
+				}
+			}
+		}
+		if !key {
+			return nil, fmt.Errorf("mqtt bridge: command template %q must contain {tag}, {alias} or {uuid}", opts.CommandTemplate)
+		}
+		b.command = levels
+	}
+	return b, nil
+}
+
+// SetOnError sets the callback for publish and command errors
+func (b *MQTTBridge) SetOnError(callback func(err error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onError = callback
+}
+
+// Start connects to the broker, announces the bridge online and subscribes to the
+// command topics. Registers are published and commands written by worker goroutines,
+// so PublishRegisters can be used as the OnReadCallback of the manager.
+func (b *MQTTBridge) Start() error {
+	opts := b.opts.MQTT
+	opts.Will = &MQTTMessage{Topic: b.opts.StatusTopic, Payload: []byte("offline"), QoS: 1, Retain: true}
+	opts.Reconnect = true
+	onReconnect := opts.OnReconnect
+	opts.OnReconnect = func() {
+		// The broker has published the will; the publisher announces the bridge again
+		select {
+		case b.reconnected <- struct{}{}:
+		default:
+		}
+		if onReconnect != nil {
+			onReconnect()
+		}
+	}
+	client, err := ConnectMQTT(opts)
+	if err != nil {
+		return err
+	}
+	if err := client.Publish(b.opts.StatusTopic, []byte("online"), 1, true); err != nil {
+		client.Close()
+		return err
+	}
+	b.mu.Lock()
+	b.client = client
+	b.mu.Unlock()
+	b.wg.Add(1)
+	go b.runPublisher(client)
+	if b.command == nil {
+		return nil
+	}
+	b.wg.Add(1)
+	go b.runCommands()
+	filter := make([]string, len(b.command))
+	for i, level := range b.command {
+		if strings.HasPrefix(level, "{") {
+			level = "+"
+		}
+		filter[i] = level
+	}
+	err = client.Subscribe(strings.Join(filter, "/"), 1, func(topic string, payload []byte) {
+		select {
+		case b.commands <- mqttCommand{topic: topic, payload: payload}:
+		default:
+			b.reportError(fmt.Errorf("mqtt bridge: command queue full, dropped command on %s", topic))
+		}
+	})
+	if err != nil {
+		b.Close()
+		return err
+	}
+	return nil
+}
+
+// Close announces the devices and the bridge offline and disconnects
+func (b *MQTTBridge) Close() error {
+	select {
+	case <-b.done:
+		return nil
+	default:
+		close(b.done)
+	}
+	b.wg.Wait()
+	b.mu.Lock()
+	client := b.client
+	slaves := b.knownDevices()
+	b.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	for _, slave := range slaves {
+		b.publishState(client, slave, false)
+	}
+	client.Publish(b.opts.StatusTopic, []byte("offline"), 1, true)
+	return client.Close()
+}
+
+// PublishRegisters queues the registers for publishing to their value topics and for
+// updating the online state of their devices. It has the signature of OnReadCallback and
+// does not block: when the queue is full the registers are dropped with an error.
+func (b *MQTTBridge) PublishRegisters(registers []DeviceRegister) {
+	b.mu.Lock()
+	started := b.client != nil
+	b.mu.Unlock()
+	if !started {
+		return
+	}
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	batch := make([]DeviceRegister, len(registers))
+	for i, reg := range registers {
+		reg.Value = append([]byte(nil), reg.Value...)
+		batch[i] = reg
+	}
+	select {
+	case b.publishes <- batch:
+	default:
+		b.reportError(fmt.Errorf("mqtt bridge: publish queue full, dropped %d registers", len(registers)))
+	}
+}
+
+// PublishChanges publishes the registers of changed tags. It has the signature of
+// the OnChangeCallback, for publishing by exception.
+func (b *MQTTBridge) PublishChanges(changes []TagChange) {
+	registers := make([]DeviceRegister, len(changes))
+	for i, change := range changes {
+		registers[i] = change.Register
+	}
+	b.PublishRegisters(registers)
+}
+
+func (b *MQTTBridge) runPublisher(client *MQTTClient) {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-b.reconnected:
+			b.announce(client)
+		case registers := <-b.publishes:
+			b.publish(client, registers)
+		}
+	}
+}
+
+// publish publishes registers and the online state of their devices, leaving out the
+// virtual tags of slave 0
+func (b *MQTTBridge) publish(client *MQTTClient, registers []DeviceRegister) {
+	online := make(map[uint8]bool)
+	var order []uint8
+	for _, reg := range registers {
+		data, err := JSONLinesEncoder{}.Encode([]DeviceRegister{reg})
+		if err != nil {
+			b.reportError(fmt.Errorf("mqtt bridge: %w", err))
+			continue
+		}
+		topic := b.topic(b.opts.TopicTemplate, reg)
+		if err := client.Publish(topic, bytes.TrimSpace(data), b.opts.QoS, b.opts.Retain); err != nil {
+			b.reportError(fmt.Errorf("mqtt bridge: publish %s: %w", topic, err))
+		}
+		if reg.SlaverId == 0 {
+			// Virtual tags belong to no device
+			continue
+		}
+		if _, seen := online[reg.SlaverId]; !seen {
+			order = append(order, reg.SlaverId)
+		}
+		quality, _ := reg.quality()
+		online[reg.SlaverId] = online[reg.SlaverId] || quality != QualityBad
+	}
+	for _, slave := range order {
+		b.publishDevice(client, slave, online[slave])
+	}
+}
+
+// announce publishes the bridge online and the last state of every device again
+func (b *MQTTBridge) announce(client *MQTTClient) {
+	if err := client.Publish(b.opts.StatusTopic, []byte("online"), 1, true); err != nil {
+		b.reportError(fmt.Errorf("mqtt bridge: publish %s: %w", b.opts.StatusTopic, err))
+	}
+	b.mu.Lock()
+	slaves := b.knownDevices()
+	states := make(map[uint8]bool, len(slaves))
+	for _, slave := range slaves {
+		states[slave] = b.devices[slave]
+	}
+	b.mu.Unlock()
+	for _, slave := range slaves {
+		b.publishState(client, slave, states[slave])
+	}
+}
+
+// knownDevices returns the slave ids with a published state. Caller must hold the mutex.
+func (b *MQTTBridge) knownDevices() []uint8 {
+	slaves := make([]uint8, 0, len(b.devices))
+	for slave := range b.devices {
+		slaves = append(slaves, slave)
+	}
+	sort.Slice(slaves, func(i, j int) bool { return slaves[i] < slaves[j] })
+	return slaves
+}
+
+// publishDevice publishes the online state of a device when it changed
+func (b *MQTTBridge) publishDevice(client *MQTTClient, slave uint8, online bool) {
+	b.mu.Lock()
+	last, known := b.devices[slave]
+	b.devices[slave] = online
+	b.mu.Unlock()
+	if known && last == online {
+		return
+	}
+	b.publishState(client, slave, online)
+}
+
+// publishState publishes the online state of a device
+func (b *MQTTBridge) publishState(client *MQTTClient, slave uint8, online bool) {
+	payload := "offline"
+	if online {
+		payload = "online"
+	}
+	topic := b.topic(b.opts.DeviceStatusTemplate, DeviceRegister{SlaverId: slave})
+	if err := client.Publish(topic, []byte(payload), b.opts.QoS, true); err != nil {
+		b.reportError(fmt.Errorf("mqtt bridge: publish %s: %w", topic, err))
+	}
+}
+
+// topic expands a template for a register
+func (b *MQTTBridge) topic(template string, reg DeviceRegister) string {
+	return strings.NewReplacer(
+		"{device}", mqttTopicLevel(b.deviceName(reg.SlaverId)),
+		"{slave}", strconv.Itoa(int(reg.SlaverId)),
+		"{tag}", mqttTopicLevel(reg.Tag),
+		"{alias}", mqttTopicLevel(reg.Alias),
+		"{uuid}", mqttTopicLevel(reg.UUID),
+	).Replace(template)
+}
+
+func (b *MQTTBridge) deviceName(slave uint8) string {
+	if name, ok := b.opts.DeviceNames[slave]; ok && name != "" {
+		return name
+	}
+	return "slave" + strconv.Itoa(int(slave))
+}
+
+// mqttTopicLevel makes a value safe to use as a single topic level
+func mqttTopicLevel(s string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(s)
+}
+
+func (b *MQTTBridge) runCommands() {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-b.done:
+			return
+		case cmd := <-b.commands:
+			if err := b.handleCommand(cmd.topic, cmd.payload); err != nil {
+				b.reportError(fmt.Errorf("mqtt bridge: command %s: %w", cmd.topic, err))
+			}
+		}
+	}
+}
+
+// handleCommand resolves the register of a command topic and writes the payload
+func (b *MQTTBridge) handleCommand(topic string, payload []byte) error {
+	levels := strings.Split(topic, "/")
+	if len(levels) != len(b.command) {
+		return fmt.Errorf("topic does not match %s", b.opts.CommandTemplate)
+	}
+	var match func(DeviceRegister) bool
+	for i, level := range b.command {
+		value := levels[i]
+		switch level {
+		case "{tag}":
+			match = func(reg DeviceRegister) bool { return mqttTopicLevel(reg.Tag) == value }
+		case "{alias}":
+			match = func(reg DeviceRegister) bool { return reg.Alias != "" && mqttTopicLevel(reg.Alias) == value }
+		case "{uuid}":
+			match = func(reg DeviceRegister) bool { return reg.UUID != "" && mqttTopicLevel(reg.UUID) == value }
+		}
+	}
+	for _, reg := range b.manager.Registers() {
+		if match(reg) && b.topic(b.opts.CommandTemplate, reg) == topic {
+			return b.manager.WriteTag(reg.Tag, parseTagValue(payload))
+		}
+	}
+	return fmt.Errorf("no register for topic")
+}
+
+func (b *MQTTBridge) reportError(err error) {
+	b.mu.Lock()
+	callback := b.onError
+	b.mu.Unlock()
+	if callback != nil {
+		callback(err)
+	}
+}
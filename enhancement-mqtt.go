@@ -0,0 +1,700 @@
+package modbus
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+	mqttMaxRemLen   = 268435455
+	mqttDefaultPort = "1883"
+)
+
+// MQTTMessage is a message to publish, e.g. the will of a connection
+type MQTTMessage struct {
+	Topic   string
+	Payload []byte
+	QoS     byte // 0 or 1
+	Retain  bool
+}
+
+// MQTTOptions configures an MQTTClient
+type MQTTOptions struct {
+	Broker           string        // tcp://host:port, mqtts://host:port or host:port
+	ClientID         string        // Client identifier
+	Username         string        // Optional user name
+	Password         string        // Optional password
+	KeepAlive        time.Duration // Keep alive interval, 30s if zero
+	Timeout          time.Duration // Connect and acknowledgement timeout, 10s if zero
+	Will             *MQTTMessage  // Last will published by the broker if the connection is lost
+	TLSConfig        *tls.Config   // TLS configuration for mqtts:// brokers
+	Reconnect        bool          // Reconnect when the connection is lost
+	MaxInflight      int           // Unacknowledged QoS 1 publishes kept for resending, 100 if zero
+	ReconnectDelay   time.Duration // First delay before reconnecting, 1s if zero, doubled up to a minute
+	OnConnectionLost func(err error)
+	OnReconnect      func() // Called after a reconnect, once subscriptions are renewed
+}
+
+// MQTTHandler receives messages of a subscription
+type MQTTHandler func(topic string, payload []byte)
+
+type mqttSubscription struct {
+	filter  string
+	qos     byte
+	handler MQTTHandler
+}
+
+// mqttPacket is an unacknowledged QoS 1 publish, kept to be resent after a reconnect
+type mqttPacket struct {
+	seq    uint64 // Order of publishing
+	header byte
+	body   []byte
+}
+
+var (
+	errMQTTNotConnected = errors.New("mqtt: not connected")
+	errMQTTInflightFull = errors.New("mqtt: too many unacknowledged publishes")
+	errMQTTNoPingresp   = errors.New("mqtt: no PINGRESP from the broker")
+)
+
+// MQTTClient is a minimal MQTT 3.1.1 client supporting QoS 0 and 1, retained messages,
+// wills and subscriptions. OnConnectionLost is called when the connection drops. With
+// Reconnect set the client then reconnects, renews its subscriptions and resends
+// unacknowledged QoS 1 messages with the DUP flag; otherwise Done is closed. A
+// connection is considered lost when nothing is received for 1.5 times KeepAlive or
+// a PINGREQ is not answered within Timeout.
+type MQTTClient struct {
+	opts      MQTTOptions
+	writeMu   sync.Mutex
+	mu        sync.Mutex
+	conn      net.Conn // Current connection, nil while reconnecting
+	nextID    uint16
+	seq       uint64
+	pending   map[uint16]chan []byte // Acknowledgement waiters by packet id
+	inflight  map[uint16]mqttPacket  // Unacknowledged QoS 1 publishes by packet id
+	pingSent  time.Time              // When the unanswered PINGREQ was sent, zero if none
+	subs      []mqttSubscription
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// ConnectMQTT connects to the broker and returns the connected client
+func ConnectMQTT(opts MQTTOptions) (*MQTTClient, error) {
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = 30 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.ReconnectDelay <= 0 {
+		opts.ReconnectDelay = time.Second
+	}
+	if opts.MaxInflight <= 0 {
+		opts.MaxInflight = 100
+	}
+	if opts.Will != nil && opts.Will.QoS > 1 {
+		return nil, fmt.Errorf("mqtt: unsupported will QoS %d", opts.Will.QoS)
+	}
+	c := &MQTTClient{
+		opts:     opts,
+		pending:  make(map[uint16]chan []byte),
+		inflight: make(map[uint16]mqttPacket),
+		done:     make(chan struct{}),
+	}
+	conn, r, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	go c.readLoop(conn, r)
+	go c.keepAlive()
+	return c, nil
+}
+
+// dial opens a connection and performs the CONNECT handshake
+func (c *MQTTClient) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := dialMQTT(c.opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	if err := writeMQTTPacket(conn, mqttConnect<<4, c.connectBody()); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("mqtt: connect: %w", err)
+	}
+	r := bufio.NewReader(conn)
+	header, body, err := readMQTTPacket(r)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("mqtt: connect: %w", err)
+	}
+	if header>>4 != mqttConnack || len(body) != 2 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("mqtt: connect: unexpected packet type %d", header>>4)
+	}
+	if body[1] != 0 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("mqtt: connection refused, return code %d", body[1])
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, r, nil
+}
+
+func dialMQTT(opts MQTTOptions) (net.Conn, error) {
+	broker := opts.Broker
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: invalid broker %q: %w", opts.Broker, err)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := mqttDefaultPort
+		if u.Scheme != "tcp" && u.Scheme != "mqtt" {
+			port = "8883"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err := dialer.Dial("tcp", host)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt: %w", err)
+		}
+		return conn, nil
+	case "ssl", "tls", "mqtts":
+		conn, err := tls.DialWithDialer(dialer, "tcp", host, opts.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt: %w", err)
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("mqtt: unsupported broker scheme: %s", u.Scheme)
+}
+
+// connectBody builds the variable header and payload of CONNECT
+func (c *MQTTClient) connectBody() []byte {
+	flags := byte(0x02) // Clean session
+	if w := c.opts.Will; w != nil {
+		flags |= 0x04 | w.QoS<<3
+		if w.Retain {
+			flags |= 0x20
+		}
+	}
+	if c.opts.Username != "" {
+		flags |= 0x80
+		if c.opts.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(c.opts.KeepAlive/time.Second))
+	body = appendMQTTString(body, c.opts.ClientID)
+	if w := c.opts.Will; w != nil {
+		body = appendMQTTString(body, w.Topic)
+		body = appendMQTTBytes(body, w.Payload)
+	}
+	if c.opts.Username != "" {
+		body = appendMQTTString(body, c.opts.Username)
+		if c.opts.Password != "" {
+			body = appendMQTTString(body, c.opts.Password)
+		}
+	}
+	return body
+}
+
+// Publish publishes a message. With QoS 1 it waits for the broker acknowledgement.
+// With Reconnect set, an unacknowledged QoS 1 message is resent after a reconnect even
+// when Publish has already returned an error, e.g. while the client is reconnecting.
+// Once MaxInflight messages are unacknowledged, QoS 1 publishes fail at once.
+func (c *MQTTClient) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	if qos > 1 {
+		return fmt.Errorf("mqtt: unsupported QoS %d", qos)
+	}
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("mqtt: invalid topic %q", topic)
+	}
+	header := byte(mqttPublish<<4) | qos<<1
+	if retain {
+		header |= 0x01
+	}
+	body := appendMQTTString(nil, topic)
+	if qos == 0 {
+		return c.write(header, append(body, payload...))
+	}
+	c.mu.Lock()
+	full := len(c.inflight) >= c.opts.MaxInflight
+	c.mu.Unlock()
+	if full {
+		return errMQTTInflightFull
+	}
+	id, ack := c.register()
+	body = binary.BigEndian.AppendUint16(body, id)
+	body = append(body, payload...)
+	c.mu.Lock()
+	c.seq++
+	c.inflight[id] = mqttPacket{seq: c.seq, header: header, body: body}
+	c.mu.Unlock()
+	if err := c.write(header, body); err != nil {
+		if c.opts.Reconnect {
+			// Kept to be resent after the reconnect
+			c.mu.Lock()
+			delete(c.pending, id)
+			c.mu.Unlock()
+		} else {
+			c.unregister(id)
+		}
+		return err
+	}
+	_, err := c.await(id, ack)
+	return err
+}
+
+// Subscribe subscribes to a topic filter, which may contain + and # wildcards, and
+// waits for the broker acknowledgement. Handlers run on the connection reader and must
+// not block, in particular not on a QoS 1 Publish.
+func (c *MQTTClient) Subscribe(filter string, qos byte, handler MQTTHandler) error {
+	if qos > 1 {
+		return fmt.Errorf("mqtt: unsupported QoS %d", qos)
+	}
+	c.mu.Lock()
+	c.subs = append(c.subs, mqttSubscription{filter: filter, qos: qos, handler: handler})
+	c.mu.Unlock()
+
+	id, ack := c.register()
+	if err := c.write(mqttSubscribe<<4|0x02, subscribeBody(id, filter, qos)); err != nil {
+		c.unregister(id)
+		return err
+	}
+	codes, err := c.await(id, ack)
+	if err != nil {
+		return err
+	}
+	if len(codes) != 1 || codes[0] == 0x80 {
+		return fmt.Errorf("mqtt: subscription to %q refused", filter)
+	}
+	return nil
+}
+
+// subscribeBody builds the body of SUBSCRIBE for a single filter
+func subscribeBody(id uint16, filter string, qos byte) []byte {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = appendMQTTString(body, filter)
+	return append(body, qos)
+}
+
+// Close disconnects cleanly, so the broker does not publish the will
+func (c *MQTTClient) Close() error {
+	err := c.write(mqttDisconnect<<4, nil)
+	c.shutdown(nil)
+	if errors.Is(err, errMQTTNotConnected) {
+		return nil
+	}
+	return err
+}
+
+// Done is closed when the client is closed, or when the connection is lost and
+// Reconnect is not set
+func (c *MQTTClient) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *MQTTClient) write(header byte, body []byte) error {
+	select {
+	case <-c.done:
+		return c.closedErr()
+	default:
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return errMQTTNotConnected
+	}
+	conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	if err := writeMQTTPacket(conn, header, body); err != nil {
+		c.connectionLost(conn, err)
+		return fmt.Errorf("mqtt: %w", err)
+	}
+	return nil
+}
+
+// register allocates a packet id and its acknowledgement channel
+func (c *MQTTClient) register() (uint16, chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		c.nextID++
+		if _, inflight := c.inflight[c.nextID]; c.nextID != 0 && c.pending[c.nextID] == nil && !inflight {
+			break
+		}
+	}
+	ack := make(chan []byte, 1)
+	c.pending[c.nextID] = ack
+	return c.nextID, ack
+}
+
+// unregister forgets a packet id, including an unacknowledged publish
+func (c *MQTTClient) unregister(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+	delete(c.inflight, id)
+}
+
+// await waits for the acknowledgement of a packet id
+func (c *MQTTClient) await(id uint16, ack chan []byte) ([]byte, error) {
+	timer := time.NewTimer(c.opts.Timeout)
+	defer timer.Stop()
+	select {
+	case payload := <-ack:
+		return payload, nil
+	case <-timer.C:
+		if c.opts.Reconnect {
+			// Keep an unacknowledged publish to resend it after a reconnect
+			c.mu.Lock()
+			delete(c.pending, id)
+			c.mu.Unlock()
+		} else {
+			c.unregister(id)
+		}
+		return nil, fmt.Errorf("mqtt: timeout waiting for acknowledgement of packet %d", id)
+	case <-c.done:
+		return nil, c.closedErr()
+	}
+}
+
+func (c *MQTTClient) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return fmt.Errorf("mqtt: connection lost: %w", c.err)
+	}
+	return errors.New("mqtt: connection closed")
+}
+
+// shutdown closes the client once, reporting err as the cause when not nil
+func (c *MQTTClient) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		conn := c.conn
+		c.conn = nil
+		c.mu.Unlock()
+		close(c.done)
+		if conn != nil {
+			conn.Close()
+		}
+		if err != nil && c.opts.OnConnectionLost != nil {
+			go c.opts.OnConnectionLost(err)
+		}
+	})
+}
+
+// connectionLost handles the loss of conn, once per connection: the client reconnects
+// with Reconnect set and shuts down otherwise
+func (c *MQTTClient) connectionLost(conn net.Conn, err error) {
+	c.mu.Lock()
+	current := c.conn == conn
+	if current {
+		c.conn = nil
+	}
+	c.mu.Unlock()
+	conn.Close()
+	if !current {
+		return
+	}
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	if !c.opts.Reconnect {
+		c.shutdown(err)
+		return
+	}
+	if c.opts.OnConnectionLost != nil {
+		go c.opts.OnConnectionLost(err)
+	}
+	go c.reconnect()
+}
+
+// reconnect dials until a connection is made or the client is closed, backing off
+// exponentially
+func (c *MQTTClient) reconnect() {
+	delay := c.opts.ReconnectDelay
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-c.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if delay *= 2; delay > time.Minute {
+			delay = time.Minute
+		}
+		conn, r, err := c.dial()
+		if err != nil {
+			continue
+		}
+		if err := c.resume(conn); err != nil {
+			conn.Close()
+			continue
+		}
+		go c.readLoop(conn, r)
+		if c.opts.OnReconnect != nil {
+			go c.opts.OnReconnect()
+		}
+		return
+	}
+}
+
+// resume renews the subscriptions on a new connection and resends unacknowledged QoS 1
+// messages, then makes it the connection of the client. Holding writeMu keeps other
+// packets from going out first.
+func (c *MQTTClient) resume(conn net.Conn) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.Lock()
+	subs := append([]mqttSubscription(nil), c.subs...)
+	packets := make([]mqttPacket, 0, len(c.inflight))
+	for _, p := range c.inflight {
+		packets = append(packets, p)
+	}
+	c.mu.Unlock()
+	sort.Slice(packets, func(i, j int) bool { return packets[i].seq < packets[j].seq })
+
+	conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	for _, sub := range subs {
+		// Nobody waits for these acknowledgements; the reader drops them
+		id, _ := c.register()
+		err := writeMQTTPacket(conn, mqttSubscribe<<4|0x02, subscribeBody(id, sub.filter, sub.qos))
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range packets {
+		if err := writeMQTTPacket(conn, p.header|0x08, p.body); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		return errors.New("mqtt: connection closed")
+	default:
+	}
+	c.conn = conn
+	c.pingSent = time.Time{}
+	return nil
+}
+
+// readLoop reads packets from conn until it fails. Every packet extends the read
+// deadline, and the keep alive pings make sure there are packets.
+func (c *MQTTClient) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(c.opts.KeepAlive * 3 / 2))
+		header, body, err := readMQTTPacket(r)
+		if err != nil {
+			c.connectionLost(conn, err)
+			return
+		}
+		switch header >> 4 {
+		case mqttPublish:
+			c.handlePublish(header, body)
+		case mqttPuback, mqttSuback:
+			if len(body) < 2 {
+				continue
+			}
+			id := binary.BigEndian.Uint16(body)
+			c.mu.Lock()
+			ack := c.pending[id]
+			delete(c.pending, id)
+			if header>>4 == mqttPuback {
+				delete(c.inflight, id)
+			}
+			c.mu.Unlock()
+			if ack != nil {
+				ack <- body[2:]
+			}
+		case mqttPingresp:
+			c.mu.Lock()
+			c.pingSent = time.Time{}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *MQTTClient) handlePublish(header byte, body []byte) {
+	topic, rest, ok := readMQTTString(body)
+	if !ok {
+		return
+	}
+	qos := header >> 1 & 0x03
+	if qos > 0 {
+		if len(rest) < 2 {
+			return
+		}
+		c.write(mqttPuback<<4, rest[:2])
+		rest = rest[2:]
+	}
+	c.mu.Lock()
+	subs := append([]mqttSubscription(nil), c.subs...)
+	c.mu.Unlock()
+	for _, sub := range subs {
+		if mqttTopicMatches(sub.filter, topic) {
+			sub.handler(topic, rest)
+		}
+	}
+}
+
+// keepAlive pings the broker and drops the connection when a ping is not answered
+func (c *MQTTClient) keepAlive() {
+	ticker := time.NewTicker(c.opts.KeepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		conn, sent := c.conn, c.pingSent
+		c.mu.Unlock()
+		if conn == nil {
+			continue
+		}
+		if !sent.IsZero() {
+			if time.Since(sent) >= c.opts.Timeout {
+				c.connectionLost(conn, errMQTTNoPingresp)
+			}
+			continue
+		}
+		c.mu.Lock()
+		c.pingSent = time.Now()
+		c.mu.Unlock()
+		c.write(mqttPingreq<<4, nil)
+	}
+}
+
+// mqttTopicMatches reports whether a topic matches a subscription filter
+func mqttTopicMatches(filter, topic string) bool {
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) {
+			return false
+		}
+		if f != "+" && f != tl[i] {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	return appendMQTTBytes(b, []byte(s))
+}
+
+func appendMQTTBytes(b []byte, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+func readMQTTString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, false
+	}
+	return string(b[2 : 2+n]), b[2+n:], true
+}
+
+// writeMQTTPacket writes a control packet with its remaining length
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+	if len(body) > mqttMaxRemLen {
+		return fmt.Errorf("packet of %d bytes is too large", len(body))
+	}
+	packet := make([]byte, 0, 5+len(body))
+	packet = append(packet, header)
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+// readMQTTPacket reads a control packet and returns its first header byte and body
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7F) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,536 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBroker is a minimal in-process MQTT 3.1.1 broker with retained messages and wills
+type testBroker struct {
+	ln          net.Listener
+	mu          sync.Mutex
+	sessions    map[*testSession]bool
+	retained    map[string]MQTTMessage
+	losePublish string // Client id whose next PUBLISH is lost with its connection
+	duplicates  int    // PUBLISH packets received with the DUP flag
+}
+
+type testSession struct {
+	conn     net.Conn
+	writeMu  sync.Mutex
+	clientID string
+	filters  []string
+	will     *MQTTMessage
+	silent   bool // Packets are read but not answered, like a half-open connection
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{ln: ln, sessions: make(map[*testSession]bool), retained: make(map[string]MQTTMessage)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(&testSession{conn: conn})
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		b.mu.Lock()
+		for s := range b.sessions {
+			s.conn.Close()
+		}
+		b.mu.Unlock()
+	})
+	return b
+}
+
+func (b *testBroker) addr() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func (s *testSession) send(header byte, body []byte) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	writeMQTTPacket(s.conn, header, body)
+}
+
+func (b *testBroker) serve(s *testSession) {
+	defer s.conn.Close()
+	r := bufio.NewReader(s.conn)
+	header, body, err := readMQTTPacket(r)
+	if err != nil || header>>4 != mqttConnect {
+		return
+	}
+	_, rest, _ := readMQTTString(body) // Protocol name
+	flags := rest[1]
+	rest = rest[4:]
+	s.clientID, rest, _ = readMQTTString(rest)
+	if flags&0x04 != 0 {
+		topic, more, _ := readMQTTString(rest)
+		payload, _, _ := readMQTTString(more)
+		s.will = &MQTTMessage{Topic: topic, Payload: []byte(payload), QoS: flags >> 3 & 0x03, Retain: flags&0x20 != 0}
+	}
+	b.mu.Lock()
+	b.sessions[s] = true
+	b.mu.Unlock()
+	s.send(mqttConnack<<4, []byte{0, 0})
+
+	clean := false
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, s)
+		b.mu.Unlock()
+		if !clean && s.will != nil {
+			b.route(*s.will)
+		}
+	}()
+	for {
+		header, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		silent := s.silent
+		b.mu.Unlock()
+		if silent {
+			continue
+		}
+		switch header >> 4 {
+		case mqttPublish:
+			b.mu.Lock()
+			lose := b.losePublish == s.clientID
+			if lose {
+				b.losePublish = ""
+			}
+			if header&0x08 != 0 {
+				b.duplicates++
+			}
+			b.mu.Unlock()
+			if lose {
+				return
+			}
+			topic, rest, _ := readMQTTString(body)
+			qos := header >> 1 & 0x03
+			if qos > 0 {
+				s.send(mqttPuback<<4, rest[:2])
+				rest = rest[2:]
+			}
+			b.route(MQTTMessage{Topic: topic, Payload: append([]byte(nil), rest...), QoS: qos, Retain: header&0x01 != 0})
+		case mqttSubscribe:
+			filter, _, _ := readMQTTString(body[2:])
+			b.mu.Lock()
+			s.filters = append(s.filters, filter)
+			var retained []MQTTMessage
+			for topic, msg := range b.retained {
+				if mqttTopicMatches(filter, topic) {
+					retained = append(retained, msg)
+				}
+			}
+			b.mu.Unlock()
+			s.send(mqttSuback<<4, append(append([]byte(nil), body[:2]...), 1))
+			for _, msg := range retained {
+				s.send(mqttPublish<<4|0x01, append(appendMQTTString(nil, msg.Topic), msg.Payload...))
+			}
+		case mqttPingreq:
+			s.send(mqttPingresp<<4, nil)
+		case mqttDisconnect:
+			clean = true
+			return
+		}
+	}
+}
+
+// drop closes the connections of a client without DISCONNECT, like a network failure
+func (b *testBroker) drop(clientID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.sessions {
+		if s.clientID == clientID {
+			s.conn.Close()
+		}
+	}
+}
+
+// silence stops answering the current connections of a client without closing them
+func (b *testBroker) silence(clientID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.sessions {
+		if s.clientID == clientID {
+			s.silent = true
+		}
+	}
+}
+
+// route delivers a message at QoS 0 to matching sessions and stores retained messages
+func (b *testBroker) route(msg MQTTMessage) {
+	b.mu.Lock()
+	if msg.Retain {
+		b.retained[msg.Topic] = msg
+	}
+	var targets []*testSession
+	for s := range b.sessions {
+		for _, filter := range s.filters {
+			if mqttTopicMatches(filter, msg.Topic) {
+				targets = append(targets, s)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	for _, s := range targets {
+		s.send(mqttPublish<<4, append(appendMQTTString(nil, msg.Topic), msg.Payload...))
+	}
+}
+
+// subscribeMessages subscribes a new client and returns a channel of received messages
+func subscribeMessages(t *testing.T, broker *testBroker, filter string) (*MQTTClient, chan MQTTMessage) {
+	client, err := ConnectMQTT(MQTTOptions{Broker: broker.addr(), ClientID: "observer", Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	messages := make(chan MQTTMessage, 100)
+	if err := client.Subscribe(filter, 1, func(topic string, payload []byte) {
+		messages <- MQTTMessage{Topic: topic, Payload: payload}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return client, messages
+}
+
+// waitMessages returns the payload of the last message received on each topic, waiting
+// until every topic has had a message
+func waitMessages(t *testing.T, messages chan MQTTMessage, topics ...string) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	timeout := time.After(2 * time.Second)
+	for {
+		missing := ""
+		for _, topic := range topics {
+			if _, ok := got[topic]; !ok {
+				missing = topic
+			}
+		}
+		if missing == "" {
+			return got
+		}
+		select {
+		case msg := <-messages:
+			got[msg.Topic] = string(msg.Payload)
+		case <-timeout:
+			t.Fatalf("no message on %s", missing)
+		}
+	}
+}
+
+func TestMQTTTopicMatches(t *testing.T) {
+	for _, tc := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a/b/c", true},
+		{"+/b/+/set", "x/b/y/set", true},
+		{"a/b", "a", false},
+	} {
+		if got := mqttTopicMatches(tc.filter, tc.topic); got != tc.match {
+			t.Errorf("mqttTopicMatches(%q, %q) = %v", tc.filter, tc.topic, got)
+		}
+	}
+}
+
+func TestMQTTBridge(t *testing.T) {
+	broker := newTestBroker(t)
+	client := newMemoryClient()
+	client.setRegisters(1, 0, 215, 0)
+	manager := NewRegisterManager(client, 10)
+	if err := manager.LoadRegisters([]DeviceRegister{
+		{Tag: "temperature", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16", Weight: 0.1},
+		{Tag: "setpoint", SlaverId: 1, Function: 3, ReadAddress: 1, ReadQuantity: 1, DataType: "uint16", Weight: 0.1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetVirtualTags([]VirtualTag{{Tag: "fahrenheit", Expression: "temperature * 1.8 + 32"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	bridge, err := NewMQTTBridge(manager, MQTTBridgeOptions{
+		MQTT:        MQTTOptions{Broker: broker.addr(), ClientID: "bridge", Timeout: 2 * time.Second},
+		DeviceNames: map[uint8]string{1: "boiler"},
+		QoS:         1,
+		Retain:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 10)
+	bridge.SetOnError(func(err error) { errs <- err })
+	if err := bridge.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Close()
+
+	manager.SetOnReadCallback(bridge.PublishRegisters)
+	if e := manager.ReadGroupedData(); len(e) > 0 {
+		t.Fatalf("ReadGroupedData failed: %v", e)
+	}
+	manager.Start()
+	defer manager.Stop()
+
+	// A late subscriber receives the retained values and states
+	_, messages := subscribeMessages(t, broker, "modbus/#")
+	got := waitMessages(t, messages, "modbus/boiler/temperature", "modbus/status", "modbus/boiler/status")
+	var record RegisterRecord
+	if err := json.Unmarshal([]byte(got["modbus/boiler/temperature"]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Tag != "temperature" || record.Value != 21.5 || record.Quality != QualityGood {
+		t.Errorf("temperature record = %+v", record)
+	}
+	if got["modbus/status"] != "online" || got["modbus/boiler/status"] != "online" {
+		t.Errorf("bridge status %q, device status %q, expected online", got["modbus/status"], got["modbus/boiler/status"])
+	}
+	// Virtual tags are published, but are not a device with a status
+	// The bridge publishes in order, so the marker arrives after any status of slave 0
+	waitMessages(t, messages, "modbus/slave0/fahrenheit")
+	bridge.PublishRegisters([]DeviceRegister{{Tag: "marker", SlaverId: 2, DataType: "uint16", Weight: 1, Value: []byte{0, 1}}})
+	waitMessages(t, messages, "modbus/slave2/marker")
+	broker.mu.Lock()
+	_, virtualStatus := broker.retained["modbus/slave0/status"]
+	broker.mu.Unlock()
+	if virtualStatus {
+		t.Errorf("status published for the virtual tags")
+	}
+
+	// A command performs a typed, scaled write
+	publisher, _ := subscribeMessages(t, broker, "unused")
+	if err := publisher.Publish("modbus/boiler/setpoint/set", []byte(`{"value": 42.5}`), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for client.register(1, 1) != 425 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if v := client.register(1, 1); v != 425 {
+		t.Errorf("setpoint register = %d, expected 425", v)
+	}
+	publisher.Publish("modbus/boiler/missing/set", []byte("1"), 1, false)
+	select {
+	case <-errs:
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected an error for an unknown tag")
+	}
+
+	// Failed reads take the device offline
+	client.readErr = os.ErrDeadlineExceeded
+	manager.ReadGroupedData()
+	if got := waitMessages(t, messages, "modbus/boiler/status"); got["modbus/boiler/status"] != "offline" {
+		t.Errorf("device status = %q, expected offline", got["modbus/boiler/status"])
+	}
+}
+
+func TestMQTTBridgeWill(t *testing.T) {
+	broker := newTestBroker(t)
+	client := newMemoryClient()
+	manager := NewRegisterManager(client, 10)
+	if err := manager.LoadRegisters([]DeviceRegister{
+		{Tag: "temperature", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	bridge, err := NewMQTTBridge(manager, MQTTBridgeOptions{
+		MQTT:        MQTTOptions{Broker: broker.addr(), ClientID: "bridge", Timeout: 2 * time.Second, ReconnectDelay: 10 * time.Millisecond},
+		StatusTopic: "plant/gateway",
+		DeviceNames: map[uint8]string{1: "boiler"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bridge.Start(); err != nil {
+		t.Fatal(err)
+	}
+	manager.SetOnReadCallback(bridge.PublishRegisters)
+	manager.Start()
+	defer manager.Stop()
+	manager.ReadGroupedData()
+
+	_, messages := subscribeMessages(t, broker, "plant/#")
+	if got := waitMessages(t, messages, "plant/gateway"); got["plant/gateway"] != "online" {
+		t.Fatalf("status = %q, expected online", got["plant/gateway"])
+	}
+	_, devices := subscribeMessages(t, broker, "modbus/boiler/status")
+	waitMessages(t, devices, "modbus/boiler/status")
+
+	// Dropping the connection without DISCONNECT makes the broker publish the will; the
+	// bridge reconnects and announces itself and its devices again
+	broker.drop("bridge")
+	if got := waitMessages(t, messages, "plant/gateway"); got["plant/gateway"] != "offline" {
+		t.Errorf("status = %q, expected the offline will", got["plant/gateway"])
+	}
+	if got := waitMessages(t, messages, "plant/gateway"); got["plant/gateway"] != "online" {
+		t.Errorf("status = %q after reconnecting, expected online", got["plant/gateway"])
+	}
+	if got := waitMessages(t, devices, "modbus/boiler/status"); got["modbus/boiler/status"] != "online" {
+		t.Errorf("device status = %q after reconnecting, expected online", got["modbus/boiler/status"])
+	}
+
+	// Closing takes the devices offline with the bridge
+	bridge.Close()
+	if got := waitMessages(t, devices, "modbus/boiler/status"); got["modbus/boiler/status"] != "offline" {
+		t.Errorf("device status = %q after Close, expected offline", got["modbus/boiler/status"])
+	}
+
+	if _, err := NewMQTTBridge(manager, MQTTBridgeOptions{TopicTemplate: "modbus/{device}"}); err == nil {
+		t.Errorf("expected error for a template without a tag")
+	}
+	if _, err := NewMQTTBridge(manager, MQTTBridgeOptions{CommandTemplate: "cmd/x{tag}"}); err == nil {
+		t.Errorf("expected error for a placeholder inside a level")
+	}
+}
+
+func TestMQTTClientReconnect(t *testing.T) {
+	broker := newTestBroker(t)
+	_, messages := subscribeMessages(t, broker, "data/#")
+
+	reconnected := make(chan struct{}, 1)
+	client, err := ConnectMQTT(MQTTOptions{
+		Broker:         broker.addr(),
+		ClientID:       "device",
+		Timeout:        2 * time.Second,
+		Reconnect:      true,
+		ReconnectDelay: 10 * time.Millisecond,
+		OnReconnect:    func() { reconnected <- struct{}{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	commands := make(chan string, 10)
+	if err := client.Subscribe("cmd/#", 1, func(topic string, payload []byte) { commands <- string(payload) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The broker loses the connection with the publish; it is resent after reconnecting
+	broker.mu.Lock()
+	broker.losePublish = "device"
+	broker.mu.Unlock()
+	if err := client.Publish("data/a", []byte("1"), 1, false); err != nil {
+		t.Errorf("Publish across a reconnect: %v", err)
+	}
+	if got := waitMessages(t, messages, "data/a"); got["data/a"] != "1" {
+		t.Errorf("data/a = %q", got["data/a"])
+	}
+	broker.mu.Lock()
+	duplicates := broker.duplicates
+	broker.mu.Unlock()
+	if duplicates != 1 {
+		t.Errorf("%d duplicate publishes, expected 1", duplicates)
+	}
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnReconnect not called")
+	}
+
+	// The subscription was renewed on the new connection
+	publisher, _ := subscribeMessages(t, broker, "unused")
+	if err := publisher.Publish("cmd/x", []byte("go"), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-commands:
+		if got != "go" {
+			t.Errorf("command = %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("no command after reconnecting")
+	}
+	select {
+	case <-client.Done():
+		t.Errorf("client closed after reconnecting")
+	default:
+	}
+
+	// Without Reconnect a lost connection closes the client
+	once, err := ConnectMQTT(MQTTOptions{Broker: broker.addr(), ClientID: "once", Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker.drop("once")
+	select {
+	case <-once.Done():
+	case <-time.After(2 * time.Second):
+		t.Errorf("client not closed after the connection dropped")
+	}
+}
+
+func TestMQTTClientHalfOpen(t *testing.T) {
+	broker := newTestBroker(t)
+	lost := make(chan error, 1)
+	reconnected := make(chan struct{}, 1)
+	client, err := ConnectMQTT(MQTTOptions{
+		Broker:           broker.addr(),
+		ClientID:         "device",
+		KeepAlive:        200 * time.Millisecond,
+		Timeout:          2 * time.Second,
+		Reconnect:        true,
+		ReconnectDelay:   10 * time.Millisecond,
+		OnConnectionLost: func(err error) { lost <- err },
+		OnReconnect:      func() { reconnected <- struct{}{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The broker stops answering but keeps the connection open
+	broker.silence("device")
+	select {
+	case <-lost:
+	case <-time.After(2 * time.Second):
+		t.Fatal("half-open connection not detected")
+	}
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reconnect after a half-open connection")
+	}
+	if err := client.Publish("data/a", []byte("1"), 1, false); err != nil {
+		t.Errorf("Publish after reconnecting: %v", err)
+	}
+
+	// Unacknowledged publishes are capped while the broker does not answer
+	capped, err := ConnectMQTT(MQTTOptions{
+		Broker:      broker.addr(),
+		ClientID:    "capped",
+		Timeout:     100 * time.Millisecond,
+		Reconnect:   true,
+		MaxInflight: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer capped.Close()
+	broker.silence("capped")
+	if err := capped.Publish("data/b", []byte("1"), 1, false); err == nil {
+		t.Errorf("expected an acknowledgement timeout")
+	}
+	if err := capped.Publish("data/b", []byte("2"), 1, false); !errors.Is(err, errMQTTInflightFull) {
+		t.Errorf("Publish beyond MaxInflight = %v", err)
+	}
+}
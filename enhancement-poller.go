@@ -10,19 +10,22 @@ import (
 // DeviceRegister and Client are assumed to be defined elsewhere
 type OnDataFunc func([]DeviceRegister)
 type OnErrorFunc func(error)
+type OnDeliverFunc func([]DeviceRegister) error
 
 // RegisterScheduler handles loading and organizing register groups
 type RegisterScheduler struct {
-	client     Client
-	groups     [][]DeviceRegister
-	clientType string
-	mu         sync.Mutex
+	client       Client
+	groups       [][]DeviceRegister
+	clientType   string
+	scaleFactors map[string]int16
+	mu           sync.Mutex
 }
 
 func NewRegisterScheduler(client Client) *RegisterScheduler {
 	return &RegisterScheduler{
-		client:     client,
-		clientType: client.GetHandlerType(),
+		client:       client,
+		clientType:   client.GetHandlerType(),
+		scaleFactors: make(map[string]int16),
 	}
 }
 
@@ -43,19 +46,27 @@ func (rs *RegisterScheduler) Load(registers []DeviceRegister) error {
 func (rs *RegisterScheduler) ReadGrouped() ([][]DeviceRegister, []error) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
+	var result [][]DeviceRegister
+	var errs []error
 	if rs.clientType == "TCP" {
-		return ReadGroupedDataConcurrently(rs.client, rs.groups)
+		result, errs = ReadGroupedDataConcurrently(rs.client, rs.groups)
+	} else {
+		result, errs = ReadGroupedDataSequential(rs.client, rs.groups)
 	}
-	return ReadGroupedDataSequential(rs.client, rs.groups)
+	resolveScaleFactors(result, rs.scaleFactors)
+	return result, errs
 }
 
 // RegisterStream handles data pushing and callback dispatch
 
 type RegisterStream struct {
-	dataCh  chan []DeviceRegister
-	stopCh  chan struct{}
-	onData  atomic.Value // holds OnDataFunc
-	onError atomic.Value // holds OnErrorFunc
+	dataCh    chan []DeviceRegister
+	stopCh    chan struct{}
+	onData    atomic.Value // holds OnDataFunc
+	onError   atomic.Value // holds OnErrorFunc
+	onDeliver atomic.Value // holds OnDeliverFunc
+	queue     *DiskQueue   // Optional store-and-forward buffer between Push and the callbacks
+	done      chan struct{}
 }
 
 func NewRegisterStream(bufferSize int) *RegisterStream {
@@ -74,7 +85,55 @@ func (rs *RegisterStream) SetOnError(fn OnErrorFunc) {
 	rs.onError.Store(fn)
 }
 
+// SetOnDeliver sets a callback that can reject a batch. With a queue set, a batch is
+// retried until the callback returns nil. It takes precedence over OnData.
+func (rs *RegisterStream) SetOnDeliver(fn OnDeliverFunc) {
+	rs.onDeliver.Store(fn)
+}
+
+// SetQueue routes pushed batches through a durable queue: Push appends to the queue
+// and never blocks, and Start delivers the queued batches at least once, including
+// batches left over from a previous run. It must be called before Start.
+func (rs *RegisterStream) SetQueue(queue *DiskQueue) {
+	rs.queue = queue
+}
+
+// deliver passes a batch to the delivery callbacks
+func (rs *RegisterStream) deliver(data []DeviceRegister) error {
+	if cb := rs.onDeliver.Load(); cb != nil {
+		return cb.(OnDeliverFunc)(data)
+	}
+	if cb := rs.onData.Load(); cb != nil {
+		cb.(OnDataFunc)(data)
+	}
+	return nil
+}
+
+// reportError passes an error to the error callback
+func (rs *RegisterStream) reportError(err error) {
+	if cb := rs.onError.Load(); cb != nil {
+		cb.(OnErrorFunc)(err)
+	}
+}
+
 func (rs *RegisterStream) Start() {
+	if rs.queue != nil {
+		rs.done = make(chan struct{})
+		go func() {
+			defer close(rs.done)
+			deliver := func(data []DeviceRegister) error {
+				err := rs.deliver(data)
+				if err != nil {
+					rs.reportError(fmt.Errorf("delivery failed, retrying: %w", err))
+				}
+				return err
+			}
+			if err := rs.queue.Run(rs.stopCh, deliver); err != nil {
+				rs.reportError(err)
+			}
+		}()
+		return
+	}
 	go func() {
 		for {
 			select {
@@ -84,15 +143,19 @@ func (rs *RegisterStream) Start() {
 				if !ok {
 					return
 				}
-				if cb := rs.onData.Load(); cb != nil {
-					cb.(OnDataFunc)(data)
-				}
+				rs.deliver(data)
 			}
 		}
 	}()
 }
 
 func (rs *RegisterStream) Push(data []DeviceRegister) {
+	if rs.queue != nil {
+		if _, err := rs.queue.Append(data); err != nil {
+			rs.reportError(err)
+		}
+		return
+	}
 	select {
 	case rs.dataCh <- data:
 	case <-rs.stopCh:
@@ -102,6 +165,9 @@ func (rs *RegisterStream) Push(data []DeviceRegister) {
 
 func (rs *RegisterStream) Stop() {
 	close(rs.stopCh)
+	if rs.done != nil {
+		<-rs.done
+	}
 }
 
 // ModbusRegisterManager coordinates scheduling and streaming
@@ -109,6 +175,9 @@ func (rs *RegisterStream) Stop() {
 type ModbusRegisterManager struct {
 	Scheduler *RegisterScheduler
 	Stream    *RegisterStream
+	Cache     *TagCache    // Optional last-known-value cache updated by ReadAndStream
+	Alarms    *AlarmEngine // Optional alarm engine evaluated by ReadAndStream
+	Historian *Historian   // Optional historian recorded by ReadAndStream
 }
 
 func NewModbusRegisterManager(client Client, bufferSize int) *ModbusRegisterManager {
@@ -124,6 +193,15 @@ func (m *ModbusRegisterManager) LoadRegisters(registers []DeviceRegister) error
 
 func (m *ModbusRegisterManager) ReadAndStream() []error {
 	groups, errs := m.Scheduler.ReadGrouped()
+	if m.Cache != nil {
+		m.Cache.UpdateGroups(groups)
+	}
+	if m.Alarms != nil {
+		m.Alarms.EvaluateGroups(groups)
+	}
+	if m.Historian != nil {
+		m.Historian.RecordGroups(groups)
+	}
 	for _, group := range groups {
 		m.Stream.Push(group)
 	}
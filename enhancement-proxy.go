@@ -0,0 +1,308 @@
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transactor sends a request PDU to a unit and returns the response PDU, including
+// exception responses. RTUBus and TCPTransactor implement it.
+type Transactor interface {
+	Transact(unit uint8, pdu []byte) ([]byte, error)
+}
+
+// TCPTransactor sends raw PDUs to a Modbus TCP device over a TCPClientHandler,
+// one request at a time. After a failed request the connection is closed, so a late
+// response is never taken for the answer to the next request.
+type TCPTransactor struct {
+	mu      sync.Mutex
+	handler *TCPClientHandler
+}
+
+// NewTCPTransactor creates a transactor on a handler; the handler's unit id is set
+// for every request
+func NewTCPTransactor(handler *TCPClientHandler) *TCPTransactor {
+	return &TCPTransactor{handler: handler}
+}
+
+// Transact sends a request PDU to a unit with the timeout of the handler
+func (t *TCPTransactor) Transact(unit uint8, pdu []byte) ([]byte, error) {
+	return t.transact(unit, pdu, 0, true)
+}
+
+// transact sends a request PDU to a unit with timeout instead of the handler timeout
+// if positive. Without awaitResponse, e.g. for broadcasts, it returns nil once the
+// request is sent.
+func (t *TCPTransactor) transact(unit uint8, pdu []byte, timeout time.Duration, awaitResponse bool) ([]byte, error) {
+	if len(pdu) == 0 {
+		return nil, fmt.Errorf("tcp transactor: empty request")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if timeout <= 0 {
+		timeout = t.handler.Timeout
+	}
+	t.handler.SetSlaverId(unit)
+	request, err := t.handler.Encode(&ProtocolDataUnit{FunctionCode: pdu[0], Data: pdu[1:]})
+	if err != nil {
+		return nil, err
+	}
+	response, err := t.handler.sendTimeout(request, timeout, awaitResponse)
+	if err == nil && awaitResponse {
+		err = t.handler.Verify(request, response)
+	}
+	if err != nil {
+		// Reconnect for the next request rather than read a late response
+		t.handler.Close()
+		return nil, err
+	}
+	if !awaitResponse {
+		// A device answering anyway must not leave its response for the next request
+		t.handler.Close()
+		return nil, nil
+	}
+	decoded, err := t.handler.Decode(response)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{decoded.FunctionCode}, decoded.Data...), nil
+}
+
+// Close closes the connection to the device
+func (t *TCPTransactor) Close() error {
+	return t.handler.Close()
+}
+
+// ProxyStats counts the requests handled by a ModbusProxy
+type ProxyStats struct {
+	Requests    uint64 // Requests received from masters
+	Forwarded   uint64 // Requests sent downstream
+	Coalesced   uint64 // Reads answered by a read already in flight
+	CacheHits   uint64 // Reads answered from the cache
+	RateLimited uint64 // Reads rejected by the rate limit
+	Errors      uint64 // Downstream requests that failed
+}
+
+// ModbusProxy accepts Modbus TCP connections from many masters and multiplexes their
+// requests onto one downstream Transactor, e.g. an RTUBus or a TCPTransactor.
+// Identical reads (FC 1-4) that arrive while one is in flight share its response,
+// and with a CacheTTL repeated reads are answered from the cache. Writes and other
+// functions are passed straight through and drop the cached reads of their unit.
+// Reads beyond the rate limit of a client host are answered with exception 0x06
+// (server device busy), and failed downstream requests with exception 0x0B (gateway
+// target device failed to respond).
+type ModbusProxy struct {
+	CacheTTL    time.Duration   // How long read responses are reused, no caching if zero
+	RateLimit   float64         // Reads per second allowed per client host, unlimited if zero
+	RateBurst   int             // Reads a client host may send at once, RateLimit rounded up if zero
+	IdleTimeout time.Duration   // Close client connections idle for this long, never if zero
+	OnError     func(err error) // Optional callback for connection and downstream errors
+
+	downstream  Transactor
+	server      mbapServer
+	mu          sync.Mutex
+	inflight    map[string]*proxyCall
+	cache       map[string]proxyCacheEntry
+	pruned      time.Time
+	generations map[uint8]uint64 // Writes per unit, so reads racing a write are not cached
+	buckets     map[string]*proxyBucket
+	stats       ProxyStats
+}
+
+type proxyCall struct {
+	done     chan struct{}
+	response []byte
+	err      error
+}
+
+type proxyCacheEntry struct {
+	response []byte
+	expires  time.Time
+}
+
+type proxyBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewModbusProxy creates a proxy forwarding to downstream
+func NewModbusProxy(downstream Transactor) *ModbusProxy {
+	return &ModbusProxy{
+		downstream:  downstream,
+		server:      newMBAPServer(),
+		inflight:    make(map[string]*proxyCall),
+		cache:       make(map[string]proxyCacheEntry),
+		generations: make(map[uint8]uint64),
+		buckets:     make(map[string]*proxyBucket),
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves connections until Close
+func (p *ModbusProxy) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(ln)
+}
+
+// Serve accepts connections on ln until Close. It always returns a non-nil error;
+// after Close the error is net.ErrClosed.
+func (p *ModbusProxy) Serve(ln net.Listener) error {
+	return p.server.serve(ln, func(conn net.Conn) {
+		client := conn.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(client); err == nil {
+			client = host
+		}
+		handle := func(unit uint8, pdu []byte) []byte {
+			return p.handle(client, unit, pdu)
+		}
+		if err := p.server.serveConn(conn, p.IdleTimeout, "proxy", handle); err != nil {
+			p.reportError(err)
+		}
+	})
+}
+
+// Close stops the listener, closes client connections and waits for their handlers.
+// The downstream is left open.
+func (p *ModbusProxy) Close() error {
+	return p.server.close()
+}
+
+// Stats returns the request counters
+func (p *ModbusProxy) Stats() ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// handle answers a request of a client host, nil when it must not be answered
+func (p *ModbusProxy) handle(client string, unit uint8, pdu []byte) []byte {
+	p.mu.Lock()
+	p.stats.Requests++
+	p.mu.Unlock()
+	var response []byte
+	var err error
+	switch pdu[0] {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs, FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		if !p.allow(client) {
+			return exceptionPDU(pdu[0], ExceptionCodeServerDeviceBusy)
+		}
+		response, err = p.read(unit, pdu)
+	default:
+		response, err = p.write(unit, pdu)
+	}
+	if err != nil {
+		p.reportError(fmt.Errorf("proxy: unit %d: %w", unit, err))
+		return exceptionPDU(pdu[0], ExceptionCodeGatewayTargetDeviceFailedToRespond)
+	}
+	return response
+}
+
+// read answers a read from the cache, from an identical read in flight, or from the
+// downstream
+func (p *ModbusProxy) read(unit uint8, pdu []byte) ([]byte, error) {
+	key := string(append([]byte{unit}, pdu...))
+	p.mu.Lock()
+	if entry, ok := p.cache[key]; ok && time.Now().Before(entry.expires) {
+		p.stats.CacheHits++
+		p.mu.Unlock()
+		return entry.response, nil
+	}
+	if call, ok := p.inflight[key]; ok {
+		p.stats.Coalesced++
+		p.mu.Unlock()
+		<-call.done
+		return call.response, call.err
+	}
+	call := &proxyCall{done: make(chan struct{})}
+	p.inflight[key] = call
+	generation := p.generations[unit] + p.generations[0]
+	p.stats.Forwarded++
+	p.mu.Unlock()
+
+	call.response, call.err = p.downstream.Transact(unit, pdu)
+
+	p.mu.Lock()
+	delete(p.inflight, key)
+	if call.err != nil {
+		p.stats.Errors++
+	} else if p.CacheTTL > 0 && len(call.response) > 0 && call.response[0]&0x80 == 0 && p.generations[unit]+p.generations[0] == generation {
+		now := time.Now()
+		if now.Sub(p.pruned) > p.CacheTTL {
+			for k, entry := range p.cache {
+				if now.After(entry.expires) {
+					delete(p.cache, k)
+				}
+			}
+			p.pruned = now
+		}
+		p.cache[key] = proxyCacheEntry{response: call.response, expires: now.Add(p.CacheTTL)}
+	}
+	p.mu.Unlock()
+	close(call.done)
+	return call.response, call.err
+}
+
+// write passes a request straight through and drops the cached reads of the unit
+func (p *ModbusProxy) write(unit uint8, pdu []byte) ([]byte, error) {
+	p.invalidate(unit)
+	p.mu.Lock()
+	p.stats.Forwarded++
+	p.mu.Unlock()
+	response, err := p.downstream.Transact(unit, pdu)
+	p.invalidate(unit)
+	if err != nil {
+		p.mu.Lock()
+		p.stats.Errors++
+		p.mu.Unlock()
+	}
+	return response, err
+}
+
+func (p *ModbusProxy) invalidate(unit uint8) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.generations[unit]++
+	for key := range p.cache {
+		if key[0] == unit || unit == 0 {
+			delete(p.cache, key)
+		}
+	}
+}
+
+// allow takes a token from the bucket of a client host
+func (p *ModbusProxy) allow(client string) bool {
+	if p.RateLimit <= 0 {
+		return true
+	}
+	burst := float64(p.RateBurst)
+	if burst <= 0 {
+		burst = math.Ceil(p.RateLimit)
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bucket, ok := p.buckets[client]
+	if !ok {
+		bucket = &proxyBucket{tokens: burst, last: now}
+		p.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*p.RateLimit)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		p.stats.RateLimited++
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+func (p *ModbusProxy) reportError(err error) {
+	if p.OnError != nil {
+		p.OnError(err)
+	}
+}
@@ -0,0 +1,179 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTransactor answers FC 3 and FC 6 from registers of any unit. While hold is
+// set, requests wait until it is closed.
+type testTransactor struct {
+	mu        sync.Mutex
+	registers []uint16
+	requests  int
+	hold      chan struct{}
+	err       error
+}
+
+func (d *testTransactor) Transact(unit uint8, pdu []byte) ([]byte, error) {
+	d.mu.Lock()
+	d.requests++
+	hold, err := d.hold, d.err
+	d.mu.Unlock()
+	if hold != nil {
+		<-hold
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	address := binary.BigEndian.Uint16(pdu[1:3])
+	switch pdu[0] {
+	case FuncCodeReadHoldingRegisters:
+		quantity := binary.BigEndian.Uint16(pdu[3:5])
+		response := []byte{pdu[0], byte(quantity * 2)}
+		for _, v := range d.registers[address : address+quantity] {
+			response = binary.BigEndian.AppendUint16(response, v)
+		}
+		return response, nil
+	case FuncCodeWriteSingleRegister:
+		d.registers[address] = binary.BigEndian.Uint16(pdu[3:5])
+		return pdu, nil
+	}
+	return exceptionPDU(pdu[0], ExceptionCodeIllegalFunction), nil
+}
+
+func (d *testTransactor) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.requests
+}
+
+func startTestProxy(t *testing.T, proxy *ModbusProxy) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- proxy.Serve(ln) }()
+	t.Cleanup(func() {
+		proxy.Close()
+		if err := <-served; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+func TestModbusProxy(t *testing.T) {
+	hold := make(chan struct{})
+	device := &testTransactor{registers: []uint16{1, 2, 3}, hold: hold}
+	proxy := NewModbusProxy(device)
+	proxy.CacheTTL = time.Minute
+	addr := startTestProxy(t, proxy)
+
+	// Identical reads from several masters share one downstream read
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, handler := newTestTCPClient(addr, 1)
+			defer handler.Close()
+			if results, err := client.ReadHoldingRegisters(0, 2); err != nil || binary.BigEndian.Uint16(results[2:]) != 2 {
+				t.Errorf("coalesced read = % X, %v", results, err)
+			}
+		}()
+	}
+	for deadline := time.Now().Add(2 * time.Second); proxy.Stats().Coalesced < 4 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	device.mu.Lock()
+	device.hold = nil
+	device.mu.Unlock()
+	close(hold)
+	wg.Wait()
+	if n := device.count(); n != 1 {
+		t.Errorf("downstream requests = %d, expected 1", n)
+	}
+
+	// Repeated reads come from the cache, other reads go downstream
+	client, handler := newTestTCPClient(addr, 1)
+	defer handler.Close()
+	if _, err := client.ReadHoldingRegisters(0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n := device.count(); n != 1 {
+		t.Errorf("downstream requests after a cached read = %d, expected 1", n)
+	}
+	client.SetSlaveId(2)
+	if _, err := client.ReadHoldingRegisters(0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n := device.count(); n != 2 {
+		t.Errorf("downstream requests after a read of another unit = %d, expected 2", n)
+	}
+
+	// Writes pass through and drop the cached reads of the unit
+	client.SetSlaveId(1)
+	if _, err := client.WriteSingleRegister(1, 42); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := client.ReadHoldingRegisters(0, 2); err != nil || binary.BigEndian.Uint16(results[2:]) != 42 {
+		t.Errorf("read after write = % X, %v", results, err)
+	}
+	stats := proxy.Stats()
+	if stats.Requests != 9 || stats.Forwarded != 4 || stats.Coalesced != 4 || stats.CacheHits != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Exceptions are passed through, failures become exception 0x0B
+	var mbErr *ModbusError
+	if _, err := client.ReadCoils(0, 1); !errors.As(err, &mbErr) || mbErr.ExceptionCode != ExceptionCodeIllegalFunction {
+		t.Errorf("expected illegal function exception, got %v", err)
+	}
+	device.mu.Lock()
+	device.err = os.ErrDeadlineExceeded
+	device.mu.Unlock()
+	if _, err := client.ReadHoldingRegisters(1, 1); !errors.As(err, &mbErr) || mbErr.ExceptionCode != ExceptionCodeGatewayTargetDeviceFailedToRespond {
+		t.Errorf("expected gateway target failed to respond, got %v", err)
+	}
+}
+
+func TestModbusProxyRateLimit(t *testing.T) {
+	// The downstream is a TCP device: simulated slaves behind a TCP-to-RTU gateway
+	slaves := &testRTUSlaves{registers: map[uint8][]uint16{1: {7}}}
+	devices := NewTCPRTUGateway()
+	devices.Route(newTestRTUBus(t, slaves), 1)
+	downstream := NewTCPTransactor(NewTCPClientHandler(startTestGateway(t, devices)))
+	defer downstream.Close()
+
+	proxy := NewModbusProxy(downstream)
+	proxy.RateLimit = 1
+	proxy.RateBurst = 2
+	addr := startTestProxy(t, proxy)
+	client, handler := newTestTCPClient(addr, 1)
+	defer handler.Close()
+	for i := 0; i < 2; i++ {
+		if results, err := client.ReadHoldingRegisters(0, 1); err != nil || binary.BigEndian.Uint16(results) != 7 {
+			t.Fatalf("read %d = % X, %v", i, results, err)
+		}
+	}
+	var mbErr *ModbusError
+	if _, err := client.ReadHoldingRegisters(0, 1); !errors.As(err, &mbErr) || mbErr.ExceptionCode != ExceptionCodeServerDeviceBusy {
+		t.Errorf("expected server device busy, got %v", err)
+	}
+	// Writes are not limited
+	if _, err := client.WriteSingleRegister(0, 8); err != nil {
+		t.Errorf("write while limited: %v", err)
+	}
+	if stats := proxy.Stats(); stats.RateLimited != 1 || slaves.requests != 3 {
+		t.Errorf("stats = %+v, slave requests = %d", stats, slaves.requests)
+	}
+}
@@ -0,0 +1,132 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Quality is the OPC-style quality of a register value. The values follow the OPC DA
+// quality bits, so the zero value is Bad: a register that was never read has no value.
+type Quality uint8
+
+const (
+	QualityBad       Quality = 0x00 // Value is not usable
+	QualityUncertain Quality = 0x40 // Value is usable but may be outdated
+	QualityGood      Quality = 0xC0 // Value was read successfully
+)
+
+// String returns the name of the quality
+func (q Quality) String() string {
+	switch q {
+	case QualityGood:
+		return "good"
+	case QualityUncertain:
+		return "uncertain"
+	default:
+		return "bad"
+	}
+}
+
+// MarshalText encodes the quality by name
+func (q Quality) MarshalText() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// UnmarshalText decodes a quality name
+func (q *Quality) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "good":
+		*q = QualityGood
+	case "uncertain":
+		*q = QualityUncertain
+	case "bad", "":
+		*q = QualityBad
+	default:
+		return errors.New("unknown quality: " + string(text))
+	}
+	return nil
+}
+
+// Reason codes explaining a quality other than good
+const (
+	ReasonNone        = ""             // Good value, or a register that was never read
+	ReasonTimeout     = "timeout"      // The device did not answer in time
+	ReasonException   = "exception"    // The device answered with a Modbus exception, see ExceptionCode
+	ReasonDecodeError = "decode-error" // The response or the value could not be decoded
+	ReasonCommLost    = "comm-lost"    // The connection to the device is closed or refused
+	ReasonCommError   = "comm-error"   // Any other communication failure, e.g. a bad frame or CRC
+	ReasonStale       = "stale"        // The last good value is older than the allowed age
+	ReasonCalcError   = "calc-error"   // The expression of a virtual tag failed, e.g. division by zero
+	ReasonScaleFactor = "scale-factor" // The scale factor register of the value is unread or out of range
+)
+
+// classifyReadError maps a read error to a reason code and the Modbus exception code
+func classifyReadError(err error) (string, uint8) {
+	var mbErr *ModbusError
+	if errors.As(err, &mbErr) {
+		return ReasonException, mbErr.ExceptionCode
+	}
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr) && netErr.Timeout() {
+		return ReasonTimeout, 0
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return ReasonCommLost, 0
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return ReasonCommLost, 0
+	}
+	if strings.Contains(strings.ToLower(err.Error()), "timeout") {
+		return ReasonTimeout, 0
+	}
+	return ReasonCommError, 0
+}
+
+// setGood marks the register as successfully read
+func (r *DeviceRegister) setGood(sourceTime, receiveTime time.Time) {
+	r.Status = "VALID:OK"
+	r.Quality = QualityGood
+	r.QualityReason = ReasonNone
+	r.ExceptionCode = 0
+	r.SourceTime = sourceTime
+	r.ReceiveTime = receiveTime
+}
+
+// setBad marks the register as failed. The last value and its SourceTime are kept so
+// consumers can tell how old the last good value is.
+func (r *DeviceRegister) setBad(reason string, exceptionCode uint8, message string, receiveTime time.Time) {
+	r.Status = "INVALID:" + message
+	r.Quality = QualityBad
+	r.QualityReason = reason
+	r.ExceptionCode = exceptionCode
+	r.ReceiveTime = receiveTime
+}
+
+// QualityAt returns the quality and reason of the register value at time now. A good
+// value whose SourceTime is older than maxAge is reported as uncertain and stale.
+// A maxAge of zero disables the age check.
+func (r DeviceRegister) QualityAt(now time.Time, maxAge time.Duration) (Quality, string) {
+	quality, reason := r.quality()
+	if quality == QualityGood && maxAge > 0 && !r.SourceTime.IsZero() && now.Sub(r.SourceTime) > maxAge {
+		return QualityUncertain, ReasonStale
+	}
+	return quality, reason
+}
+
+// quality returns the quality of the register. Registers whose quality was never set
+// but whose Status denotes a successful read, e.g. built by hand, count as good.
+func (r DeviceRegister) quality() (Quality, string) {
+	if r.Quality == QualityBad && r.QualityReason == ReasonNone && isValidStatus(r.Status) {
+		return QualityGood, ReasonNone
+	}
+	return r.Quality, r.QualityReason
+}
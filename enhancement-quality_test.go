@@ -0,0 +1,105 @@
+package modbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClassifyReadError(t *testing.T) {
+	tests := []struct {
+		err       error
+		reason    string
+		exception uint8
+	}{
+		{&ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeIllegalDataAddress}, ReasonException, ExceptionCodeIllegalDataAddress},
+		{fmt.Errorf("read: %w", os.ErrDeadlineExceeded), ReasonTimeout, 0},
+		{fmt.Errorf("serial: read timeout"), ReasonTimeout, 0},
+		{io.EOF, ReasonCommLost, 0},
+		{fmt.Errorf("modbus: response crc '1' does not match expected '2'"), ReasonCommError, 0},
+	}
+	for _, tc := range tests {
+		reason, exception := classifyReadError(tc.err)
+		if reason != tc.reason || exception != tc.exception {
+			t.Errorf("classifyReadError(%v) = %s/%d, expected %s/%d", tc.err, reason, exception, tc.reason, tc.exception)
+		}
+	}
+}
+
+func TestReadGroupQuality(t *testing.T) {
+	client := newMemoryClient()
+	client.setRegisters(1, 0, 100, 200)
+	group := []DeviceRegister{
+		{Tag: "a", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16", Weight: 1},
+		{Tag: "b", SlaverId: 1, Function: 3, ReadAddress: 1, ReadQuantity: 1, DataType: "uint16", Weight: 1},
+	}
+
+	before := time.Now()
+	group, err := readGroup(client, group)
+	if err != nil {
+		t.Fatalf("readGroup failed: %v", err)
+	}
+	for _, reg := range group {
+		if reg.Quality != QualityGood || reg.QualityReason != ReasonNone || reg.Status != "VALID:OK" {
+			t.Errorf("%s: quality = %s/%q, status %q, expected good", reg.Tag, reg.Quality, reg.QualityReason, reg.Status)
+		}
+		if reg.SourceTime.Before(before) || reg.ReceiveTime.Before(reg.SourceTime) {
+			t.Errorf("%s: timestamps source=%v receive=%v not ordered after %v", reg.Tag, reg.SourceTime, reg.ReceiveTime, before)
+		}
+	}
+	goodSource := group[0].SourceTime
+
+	client.readErr = &ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeServerDeviceBusy}
+	group, err = readGroup(client, group)
+	if err == nil {
+		t.Fatal("expected read error")
+	}
+	decoded, _ := group[0].DecodeValue()
+	if decoded.Quality != QualityBad || decoded.QualityReason != ReasonException || group[0].ExceptionCode != ExceptionCodeServerDeviceBusy {
+		t.Errorf("quality = %s/%q/%d, expected bad exception %d", decoded.Quality, decoded.QualityReason, group[0].ExceptionCode, ExceptionCodeServerDeviceBusy)
+	}
+	if !group[0].SourceTime.Equal(goodSource) || decoded.Float64 != 100 {
+		t.Errorf("last good value and its source time must be kept on failure")
+	}
+	if group[0].ReceiveTime.Before(goodSource) {
+		t.Errorf("ReceiveTime must record the failed attempt")
+	}
+	if isValidStatus(group[0].Status) {
+		t.Errorf("Status = %q, expected INVALID", group[0].Status)
+	}
+}
+
+func TestDeviceRegisterQualityAt(t *testing.T) {
+	now := time.Now()
+	register := DeviceRegister{DataType: "uint16", Value: []byte{0, 1}, Quality: QualityGood, SourceTime: now.Add(-10 * time.Second)}
+	if q, reason := register.QualityAt(now, 5*time.Second); q != QualityUncertain || reason != ReasonStale {
+		t.Errorf("QualityAt = %s/%q, expected uncertain/stale", q, reason)
+	}
+	if q, _ := register.QualityAt(now, time.Minute); q != QualityGood {
+		t.Errorf("QualityAt = %s, expected good", q)
+	}
+
+	// Hand-built registers with a valid status count as good
+	legacy := DeviceRegister{DataType: "uint16", Value: []byte{0, 1}, Status: "VALID:OK"}
+	if decoded, _ := legacy.DecodeValue(); decoded.Quality != QualityGood {
+		t.Errorf("legacy register quality = %s, expected good", decoded.Quality)
+	}
+
+	short := DeviceRegister{DataType: "uint32", Value: []byte{0, 1}, Quality: QualityGood}
+	decoded, err := short.DecodeValue()
+	if err == nil || decoded.Quality != QualityBad || decoded.QualityReason != ReasonDecodeError {
+		t.Errorf("short value quality = %s/%q, expected bad/decode-error", decoded.Quality, decoded.QualityReason)
+	}
+
+	data, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roundTrip DecodedValue
+	if err := json.Unmarshal(data, &roundTrip); err != nil || roundTrip.Quality != QualityBad {
+		t.Errorf("JSON round trip of quality failed: %s, %v", data, err)
+	}
+}
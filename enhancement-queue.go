@@ -0,0 +1,566 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	queueSegmentExt    = ".seg"
+	queueAckFile       = "ack"
+	queueCorruptExt    = ".corrupt"
+	queueRecordHeader  = 8 // Payload length (4) + CRC-32 of the payload (4)
+	queueMaxRecord     = 64 << 20
+	defaultSegmentSize = 16 << 20
+	defaultRetryDelay  = time.Second
+)
+
+// DiskQueueOptions configures a DiskQueue
+type DiskQueueOptions struct {
+	Dir         string          // Directory holding the segment files, created if missing
+	SegmentSize int64           // Size at which a new segment is started, 16 MiB if zero
+	MaxBytes    int64           // Total size of the segments kept, oldest segments are dropped above it; 0 is unlimited
+	MaxAge      time.Duration   // Age after which whole segments are dropped, even if not delivered; 0 is unlimited
+	Sync        bool            // Fsync every append and acknowledgement
+	RetryDelay  time.Duration   // Delay before retrying a failed delivery in Run, 1s if zero
+	OnError     func(err error) // Optional callback for quarantined segments and errors Run retries
+}
+
+// DiskQueueStats reports the backlog and activity of a DiskQueue
+type DiskQueueStats struct {
+	Pending      uint64 // Records appended but not acknowledged
+	PendingBytes int64  // Size of the segments holding pending records
+	Segments     int    // Number of segment files
+	Appended     uint64 // Records appended since open
+	Delivered    uint64 // Records acknowledged since open
+	Dropped      uint64 // Undelivered records removed by retention since open
+	Retries      uint64 // Failed delivery attempts since open
+	Quarantined  int    // Segments set aside because of a corrupt record since open
+}
+
+// QueuedBatch is a batch of registers read from a DiskQueue
+type QueuedBatch struct {
+	Seq       uint64           // Sequence number, pass to Ack once delivered
+	Registers []DeviceRegister // Registers of the batch
+}
+
+// queueSegment describes one segment file; it holds the records base..base+count-1
+type queueSegment struct {
+	base    uint64
+	count   uint64
+	size    int64
+	updated time.Time // Time of the last append
+}
+
+// DiskQueue is a durable FIFO of register batches stored in append-only segment files.
+// Batches stay on disk until acknowledged, giving at-least-once delivery across
+// restarts: batches read but not acknowledged before a crash are delivered again.
+type DiskQueue struct {
+	mu       sync.Mutex
+	opts     DiskQueueOptions
+	segments []queueSegment
+	active   *os.File // Last segment, open for appending
+	next     uint64   // Sequence number of the next appended record
+	acked    uint64   // Highest acknowledged sequence number
+	notify   chan struct{}
+	stats    DiskQueueStats
+	closed   bool
+}
+
+// OpenDiskQueue opens or creates a queue in opts.Dir, recovering the segments and the
+// acknowledged position of a previous run. A record torn by a crash is truncated.
+func OpenDiskQueue(opts DiskQueueOptions) (*DiskQueue, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("disk queue directory is required")
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("disk queue: %w", err)
+	}
+	q := &DiskQueue{opts: opts, next: 1, notify: make(chan struct{}, 1)}
+
+	entries, err := os.ReadDir(opts.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("disk queue: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, queueSegmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, queueSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, queueSegment{base: base})
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].base < q.segments[j].base })
+	for i := range q.segments {
+		if err := q.scanSegment(&q.segments[i], i == len(q.segments)-1); err != nil {
+			return nil, err
+		}
+	}
+	if n := len(q.segments); n > 0 {
+		q.next = q.segments[n-1].base + q.segments[n-1].count
+	}
+
+	if data, err := os.ReadFile(filepath.Join(opts.Dir, queueAckFile)); err == nil && len(data) == 8 {
+		q.acked = binary.BigEndian.Uint64(data)
+	}
+	if q.acked >= q.next {
+		q.acked = q.next - 1
+	}
+	if len(q.segments) > 0 && q.acked < q.segments[0].base-1 {
+		// Records before the first segment were dropped by retention
+		q.acked = q.segments[0].base - 1
+	}
+	if err := q.openActive(); err != nil {
+		return nil, err
+	}
+	q.removeDelivered()
+	return q, nil
+}
+
+// scanSegment counts the valid records of a segment. The last segment is truncated
+// after its last valid record.
+func (q *DiskQueue) scanSegment(seg *queueSegment, last bool) error {
+	path := q.segmentPath(seg.base)
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("disk queue: %w", err)
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil {
+		seg.updated = info.ModTime()
+	}
+	r := bufio.NewReader(f)
+	var valid int64
+	for {
+		_, n, err := readQueueRecord(r)
+		if err != nil {
+			break
+		}
+		valid += n
+		seg.count++
+	}
+	seg.size = valid
+	if last {
+		if err := os.Truncate(path, valid); err != nil {
+			return fmt.Errorf("disk queue: %w", err)
+		}
+	}
+	return nil
+}
+
+// openActive opens the last segment for appending, creating one if there is none
+func (q *DiskQueue) openActive() error {
+	if len(q.segments) == 0 {
+		q.segments = append(q.segments, queueSegment{base: q.next, updated: time.Now()})
+	}
+	seg := q.segments[len(q.segments)-1]
+	f, err := os.OpenFile(q.segmentPath(seg.base), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("disk queue: %w", err)
+	}
+	q.active = f
+	return nil
+}
+
+func (q *DiskQueue) segmentPath(base uint64) string {
+	return filepath.Join(q.opts.Dir, fmt.Sprintf("%020d%s", base, queueSegmentExt))
+}
+
+// Append stores a batch of registers and returns its sequence number. Append does not
+// block on consumers; retention limits drop the oldest segments instead.
+func (q *DiskQueue) Append(registers []DeviceRegister) (uint64, error) {
+	payload, err := json.Marshal(registers)
+	if err != nil {
+		return 0, fmt.Errorf("disk queue: %w", err)
+	}
+	if len(payload) > queueMaxRecord {
+		return 0, fmt.Errorf("disk queue: batch of %d bytes exceeds the record limit", len(payload))
+	}
+	record := make([]byte, queueRecordHeader+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[queueRecordHeader:], payload)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, fmt.Errorf("disk queue is closed")
+	}
+	seg := &q.segments[len(q.segments)-1]
+	if seg.size > 0 && seg.size+int64(len(record)) > q.opts.SegmentSize {
+		if err := q.roll(); err != nil {
+			return 0, err
+		}
+		seg = &q.segments[len(q.segments)-1]
+	}
+	if _, err := q.active.Write(record); err != nil {
+		// Drop a partially written record so later appends stay readable
+		q.active.Truncate(seg.size)
+		return 0, fmt.Errorf("disk queue: %w", err)
+	}
+	if q.opts.Sync {
+		if err := q.active.Sync(); err != nil {
+			return 0, fmt.Errorf("disk queue: %w", err)
+		}
+	}
+	seq := q.next
+	q.next++
+	seg.count++
+	seg.size += int64(len(record))
+	seg.updated = time.Now()
+	q.stats.Appended++
+	q.applyRetention()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return seq, nil
+}
+
+// roll closes the active segment and starts a new one. Caller must hold the mutex.
+func (q *DiskQueue) roll() error {
+	if err := q.active.Close(); err != nil {
+		return fmt.Errorf("disk queue: %w", err)
+	}
+	q.segments = append(q.segments, queueSegment{base: q.next, updated: time.Now()})
+	return q.openActive()
+}
+
+// applyRetention drops the oldest segments while the total size is above MaxBytes or
+// their last record is older than MaxAge. The active segment is never dropped.
+// Caller must hold the mutex.
+func (q *DiskQueue) applyRetention() {
+	for len(q.segments) > 1 {
+		oldest := q.segments[0]
+		var total int64
+		for _, seg := range q.segments {
+			total += seg.size
+		}
+		overSize := q.opts.MaxBytes > 0 && total > q.opts.MaxBytes
+		overAge := q.opts.MaxAge > 0 && time.Since(oldest.updated) > q.opts.MaxAge
+		if !overSize && !overAge {
+			return
+		}
+		end := oldest.base + oldest.count - 1
+		if q.acked < end {
+			q.stats.Dropped += end - q.acked
+			q.acked = end
+		}
+		os.Remove(q.segmentPath(oldest.base))
+		q.segments = q.segments[1:]
+	}
+}
+
+// Peek returns up to max pending batches, oldest first, without removing them. A
+// segment with a corrupt record is set aside once the batches before the record are
+// acknowledged: it is renamed to end in .corrupt, its remaining records are dropped
+// and reported to OnError, and Peek goes on with the next segment.
+func (q *DiskQueue) Peek(max int) ([]QueuedBatch, error) {
+	q.mu.Lock()
+	batches, quarantined, err := q.peek(max)
+	q.mu.Unlock()
+	for _, err := range quarantined {
+		q.reportError(err)
+	}
+	return batches, err
+}
+
+// peek implements Peek and returns the errors of quarantined segments to report.
+// Caller must hold the mutex.
+func (q *DiskQueue) peek(max int) (batches []QueuedBatch, quarantined []error, err error) {
+	if q.closed {
+		return nil, nil, fmt.Errorf("disk queue is closed")
+	}
+	for i := 0; i < len(q.segments) && len(batches) < max; i++ {
+		seg := q.segments[i]
+		if seg.count == 0 || seg.base+seg.count-1 <= q.acked {
+			continue
+		}
+		read, err := q.readSegment(seg, q.acked+1, max-len(batches))
+		batches = append(batches, read...)
+		var corrupt *queueCorruptError
+		if !errors.As(err, &corrupt) {
+			if err != nil {
+				return batches, quarantined, err
+			}
+			continue
+		}
+		if corrupt.seq > q.acked+1 {
+			// Deliver the batches before the corrupt record first
+			return batches, quarantined, nil
+		}
+		name := filepath.Base(q.segmentPath(seg.base))
+		lost, qErr := q.quarantine(i)
+		if qErr != nil {
+			return batches, quarantined, qErr
+		}
+		quarantined = append(quarantined, fmt.Errorf("%w; moved %s aside, dropping %d records", err, name, lost))
+		i--
+	}
+	return batches, quarantined, nil
+}
+
+// queueCorruptError reports a record of a segment that cannot be read
+type queueCorruptError struct {
+	seq uint64
+	err error
+}
+
+func (e *queueCorruptError) Error() string {
+	return fmt.Sprintf("disk queue: record %d: %v", e.seq, e.err)
+}
+
+func (e *queueCorruptError) Unwrap() error {
+	return e.err
+}
+
+// readSegment reads up to max records of a segment starting at sequence from.
+// Caller must hold the mutex.
+func (q *DiskQueue) readSegment(seg queueSegment, from uint64, max int) ([]QueuedBatch, error) {
+	f, err := os.Open(q.segmentPath(seg.base))
+	if err != nil {
+		return nil, fmt.Errorf("disk queue: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(io.LimitReader(f, seg.size))
+	var batches []QueuedBatch
+	for seq := seg.base; seq < seg.base+seg.count && len(batches) < max; seq++ {
+		payload, _, err := readQueueRecord(r)
+		if err != nil {
+			return batches, &queueCorruptError{seq: seq, err: err}
+		}
+		if seq < from {
+			continue
+		}
+		var registers []DeviceRegister
+		if err := json.Unmarshal(payload, &registers); err != nil {
+			return batches, &queueCorruptError{seq: seq, err: err}
+		}
+		batches = append(batches, QueuedBatch{Seq: seq, Registers: registers})
+	}
+	return batches, nil
+}
+
+// quarantine sets segment i aside and returns the number of pending records dropped
+// with it. Appends move to a new segment first if it is the active one. Caller must
+// hold the mutex.
+func (q *DiskQueue) quarantine(i int) (uint64, error) {
+	if i == len(q.segments)-1 {
+		if err := q.roll(); err != nil {
+			return 0, err
+		}
+	}
+	seg := q.segments[i]
+	path := q.segmentPath(seg.base)
+	if err := os.Rename(path, path+queueCorruptExt); err != nil {
+		return 0, fmt.Errorf("disk queue: %w", err)
+	}
+	q.segments = append(q.segments[:i], q.segments[i+1:]...)
+	end := seg.base + seg.count - 1
+	lost := end - q.acked
+	q.stats.Dropped += lost
+	q.stats.Quarantined++
+	if err := q.writeAck(end); err != nil {
+		return 0, err
+	}
+	return lost, nil
+}
+
+// Ack acknowledges every batch up to and including seq. Fully delivered segments
+// are removed.
+func (q *DiskQueue) Ack(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return fmt.Errorf("disk queue is closed")
+	}
+	if seq >= q.next {
+		return fmt.Errorf("disk queue: ack of unknown record %d", seq)
+	}
+	if seq <= q.acked {
+		return nil
+	}
+	q.stats.Delivered += seq - q.acked
+	if err := q.writeAck(seq); err != nil {
+		return err
+	}
+	q.removeDelivered()
+	return nil
+}
+
+// writeAck stores the acknowledged position, replacing the ack file atomically; with
+// Sync the file and the directory are synced, so a crash keeps the position. Caller
+// must hold the mutex.
+func (q *DiskQueue) writeAck(seq uint64) error {
+	q.acked = seq
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], seq)
+	tmp := filepath.Join(q.opts.Dir, queueAckFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("disk queue: %w", err)
+	}
+	_, err = f.Write(buf[:])
+	if err == nil && q.opts.Sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("disk queue: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(q.opts.Dir, queueAckFile)); err != nil {
+		return fmt.Errorf("disk queue: %w", err)
+	}
+	if q.opts.Sync {
+		if dir, err := os.Open(q.opts.Dir); err == nil {
+			err = dir.Sync()
+			dir.Close()
+			if err != nil {
+				return fmt.Errorf("disk queue: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeDelivered removes segments whose records are all acknowledged, except the
+// active segment. Caller must hold the mutex.
+func (q *DiskQueue) removeDelivered() {
+	for len(q.segments) > 1 && q.segments[0].base+q.segments[0].count-1 <= q.acked {
+		os.Remove(q.segmentPath(q.segments[0].base))
+		q.segments = q.segments[1:]
+	}
+}
+
+// Stats returns the backlog and activity counters of the queue
+func (q *DiskQueue) Stats() DiskQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Pending = q.next - 1 - q.acked
+	stats.Segments = len(q.segments)
+	for _, seg := range q.segments {
+		if seg.count > 0 && seg.base+seg.count-1 > q.acked {
+			stats.PendingBytes += seg.size
+		}
+	}
+	return stats
+}
+
+// Run delivers pending batches to deliver, oldest first, until stop is closed. A batch
+// is acknowledged once deliver returns nil; on error it is retried after RetryDelay,
+// so every batch is delivered at least once. Errors reading or acknowledging batches
+// are reported to OnError and retried as well. Run returns an error only when the
+// queue is closed.
+func (q *DiskQueue) Run(stop <-chan struct{}, deliver func(registers []DeviceRegister) error) error {
+	for {
+		batches, err := q.Peek(16)
+		if err != nil {
+			q.mu.Lock()
+			closed := q.closed
+			q.mu.Unlock()
+			if closed {
+				return err
+			}
+			q.reportError(err)
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(q.opts.RetryDelay):
+			}
+			continue
+		}
+		for len(batches) > 0 {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			if err := deliver(batches[0].Registers); err != nil {
+				q.mu.Lock()
+				q.stats.Retries++
+				q.mu.Unlock()
+				select {
+				case <-stop:
+					return nil
+				case <-time.After(q.opts.RetryDelay):
+				}
+				continue
+			}
+			if err := q.Ack(batches[0].Seq); err != nil {
+				// The position is kept in memory and stored with the next ack
+				q.reportError(err)
+			}
+			batches = batches[1:]
+		}
+		if q.Stats().Pending > 0 {
+			continue
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-q.notify:
+		}
+	}
+}
+
+func (q *DiskQueue) reportError(err error) {
+	if q.opts.OnError != nil {
+		q.opts.OnError(err)
+	}
+}
+
+// Close closes the active segment. Pending batches are kept for the next open.
+func (q *DiskQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	return q.active.Close()
+}
+
+// readQueueRecord reads one record and returns its payload and total size
+func readQueueRecord(r io.Reader) ([]byte, int64, error) {
+	var header [queueRecordHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > queueMaxRecord {
+		return nil, 0, errors.New("invalid record length")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+	return payload, int64(queueRecordHeader) + int64(length), nil
+}
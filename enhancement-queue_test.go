@@ -0,0 +1,276 @@
+package modbus
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func queueBatch(tag string, value byte) []DeviceRegister {
+	return []DeviceRegister{{Tag: tag, DataType: "uint16", Weight: 1, Value: []byte{0, value}, Status: "VALID:OK", Quality: QualityGood}}
+}
+
+func TestDiskQueueReplayAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenDiskQueue(DiskQueueOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := byte(1); i <= 3; i++ {
+		if seq, err := q.Append(queueBatch("a", i)); err != nil || seq != uint64(i) {
+			t.Fatalf("Append = %d, %v, expected sequence %d", seq, err, i)
+		}
+	}
+	batches, err := q.Peek(10)
+	if err != nil || len(batches) != 3 {
+		t.Fatalf("Peek returned %d batches, %v", len(batches), err)
+	}
+	if err := q.Ack(batches[0].Seq); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tear the last record as a crash during a write would
+	segment := filepath.Join(dir, "00000000000000000001.seg")
+	f, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 40, 1, 2})
+	f.Close()
+
+	q, err = OpenDiskQueue(DiskQueueOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	batches, err = q.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || batches[0].Seq != 2 || batches[1].Seq != 3 {
+		t.Fatalf("after restart Peek returned %+v, expected sequences 2 and 3", batches)
+	}
+	if batches[0].Registers[0].Value[1] != 2 || batches[0].Registers[0].Quality != QualityGood {
+		t.Errorf("replayed batch = %+v", batches[0].Registers[0])
+	}
+	if seq, err := q.Append(queueBatch("a", 4)); err != nil || seq != 4 {
+		t.Fatalf("Append after restart = %d, %v, expected 4", seq, err)
+	}
+	if batches, _ = q.Peek(10); len(batches) != 3 {
+		t.Errorf("Peek returned %d batches, expected 3", len(batches))
+	}
+	if stats := q.Stats(); stats.Pending != 3 || stats.Appended != 1 {
+		t.Errorf("Stats = %+v, expected 3 pending and 1 appended", stats)
+	}
+}
+
+func TestDiskQueueRetention(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenDiskQueue(DiskQueueOptions{Dir: dir, SegmentSize: 300, MaxBytes: 900})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for i := 0; i < 50; i++ {
+		if _, err := q.Append(queueBatch("a", byte(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := q.Stats()
+	if stats.Dropped == 0 || stats.Pending+stats.Dropped != 50 {
+		t.Errorf("Stats = %+v, expected dropped records accounting for the backlog", stats)
+	}
+	if stats.PendingBytes > 900 {
+		t.Errorf("PendingBytes = %d, expected at most MaxBytes", stats.PendingBytes)
+	}
+	batches, err := q.Peek(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(batches)) != stats.Pending || batches[len(batches)-1].Seq != 50 {
+		t.Errorf("Peek returned %d batches ending at %d, expected %d ending at 50", len(batches), batches[len(batches)-1].Seq, stats.Pending)
+	}
+
+	// Acknowledging everything removes all but the active segment
+	if err := q.Ack(50); err != nil {
+		t.Fatal(err)
+	}
+	if stats := q.Stats(); stats.Pending != 0 || stats.Segments != 1 {
+		t.Errorf("Stats after ack = %+v, expected nothing pending in one segment", stats)
+	}
+	if err := q.Ack(51); err == nil {
+		t.Errorf("expected error acknowledging an unknown record")
+	}
+}
+
+func TestRegisterStreamWithQueue(t *testing.T) {
+	q, err := OpenDiskQueue(DiskQueueOptions{Dir: t.TempDir(), RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	var mu sync.Mutex
+	var delivered []byte
+	failures := 2
+	done := make(chan struct{})
+	stream := NewRegisterStream(1)
+	stream.SetQueue(q)
+	stream.SetOnDeliver(func(registers []DeviceRegister) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			return errors.New("upstream down")
+		}
+		delivered = append(delivered, registers[0].Value[1])
+		if len(delivered) == 5 {
+			close(done)
+		}
+		return nil
+	})
+
+	// Pushing never blocks on the consumer, even before it starts
+	for i := byte(1); i <= 5; i++ {
+		stream.Push(queueBatch("a", i))
+	}
+	stream.Start()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for delivery")
+	}
+	stream.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, v := range delivered {
+		if v != byte(i+1) {
+			t.Fatalf("delivered %v, expected batches 1..5 in order", delivered)
+		}
+	}
+	if stats := q.Stats(); stats.Pending != 0 || stats.Retries != 2 || stats.Delivered != 5 {
+		t.Errorf("Stats = %+v, expected all delivered after 2 retries", stats)
+	}
+}
+
+func TestDiskQueueRunStop(t *testing.T) {
+	q, err := OpenDiskQueue(DiskQueueOptions{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for i := byte(1); i <= 5; i++ {
+		if _, err := q.Append(queueBatch("a", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stopping during a delivery leaves the remaining batches for the next Run
+	stop := make(chan struct{})
+	delivered := 0
+	if err := q.Run(stop, func(registers []DeviceRegister) error {
+		delivered++
+		close(stop)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if delivered != 1 {
+		t.Errorf("delivered %d batches after stop, expected 1", delivered)
+	}
+	if stats := q.Stats(); stats.Pending != 4 {
+		t.Errorf("Pending = %d, expected 4", stats.Pending)
+	}
+}
+
+func TestDiskQueueCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	errs := make(chan error, 4)
+	q, err := OpenDiskQueue(DiskQueueOptions{Dir: dir, Sync: true, RetryDelay: 10 * time.Millisecond, OnError: func(err error) { errs <- err }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for i := byte(1); i <= 3; i++ {
+		if _, err := q.Append(queueBatch("a", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Damage the middle of the three equal records
+	segment := filepath.Join(dir, "00000000000000000001.seg")
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xFF
+	if err := os.WriteFile(segment, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	delivered := make(chan byte, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- q.Run(stop, func(registers []DeviceRegister) error {
+			delivered <- registers[0].Value[1]
+			return nil
+		})
+	}()
+	next := func() byte {
+		select {
+		case v := <-delivered:
+			return v
+		case <-time.After(2 * time.Second):
+			t.Fatal("no delivery")
+			return 0
+		}
+	}
+	if v := next(); v != 1 {
+		t.Errorf("first delivery = %d, expected 1", v)
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "record 2") {
+			t.Errorf("reported %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("corrupt record not reported")
+	}
+
+	// Delivery goes on with new batches
+	if _, err := q.Append(queueBatch("a", 4)); err != nil {
+		t.Fatal(err)
+	}
+	if v := next(); v != 4 {
+		t.Errorf("delivery after the corrupt segment = %d, expected 4", v)
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("Run returned %v", err)
+	}
+	if stats := q.Stats(); stats.Quarantined != 1 || stats.Dropped != 2 || stats.Pending != 0 {
+		t.Errorf("Stats = %+v", stats)
+	}
+	if _, err := os.Stat(segment + ".corrupt"); err != nil {
+		t.Errorf("corrupt segment not kept: %v", err)
+	}
+
+	// The quarantined segment is not read again after a restart
+	q.Close()
+	q, err = OpenDiskQueue(DiskQueueOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if batches, err := q.Peek(10); err != nil || len(batches) != 0 {
+		t.Errorf("Peek after restart = %+v, %v", batches, err)
+	}
+}
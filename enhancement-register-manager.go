@@ -5,14 +5,29 @@ import (
 	"sync"
 )
 
+// registerBatch is one item of the RegisterManager data queue: either a group of read
+// registers or the changes detected in a read cycle.
+type registerBatch struct {
+	registers []DeviceRegister
+	changes   []TagChange
+}
+
 type RegisterManager struct {
 	OnReadCallback   func(registers []DeviceRegister)
 	OnErrorCallback  func(err error)
-	dataQueue        chan []DeviceRegister
+	OnChangeCallback func(changes []TagChange)
+	dataQueue        chan registerBatch
 	groupedRegisters [][]DeviceRegister
+	changeDetector   *ChangeDetector   // Report-by-exception filter, nil delivers every group
+	cache            *TagCache         // Last-known-value cache fed by every read, optional
+	virtualTags      *VirtualTagEngine // Calculated tags evaluated after every read, optional
+	alarms           *AlarmEngine      // Alarms evaluated after every read, optional
+	historian        *Historian        // History recorded after every read, optional
+	scaleFactors     map[string]int16  // Last known values of scale factor registers
 	exitSignal       chan struct{}
 	client           Client
 	clientType       string
+	verifyWrites     bool
 	closed           bool
 	mu               sync.Mutex // Protects shared resources
 }
@@ -20,11 +35,13 @@ type RegisterManager struct {
 // NewRegisterManager creates a new instance of RegisterManager
 func NewRegisterManager(client Client, queueSize int) *RegisterManager {
 	return &RegisterManager{
-		dataQueue:        make(chan []DeviceRegister, queueSize),
+		dataQueue:        make(chan registerBatch, queueSize),
 		groupedRegisters: [][]DeviceRegister{},
+		scaleFactors:     make(map[string]int16),
 		exitSignal:       make(chan struct{}),
 		client:           client,
 		clientType:       client.GetHandlerType(),
+		verifyWrites:     true,
 		closed:           false,
 	}
 }
@@ -53,6 +70,81 @@ func (m *RegisterManager) SetOnErrorCallback(callback func(err error)) {
 	m.OnErrorCallback = callback
 }
 
+// SetOnChangeCallback sets the callback receiving changed tags with their previous
+// values. It is only called once a change filter is set with SetChangeFilter.
+func (m *RegisterManager) SetOnChangeCallback(callback func(changes []TagChange)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.OnChangeCallback = callback
+}
+
+// SetChangeFilter enables report-by-exception: with OnChangeOnly set, OnReadCallback only
+// receives registers that changed beyond their deadband, and every register is delivered
+// again once per IntegrityInterval. Delivered values are forgotten when the filter is set.
+func (m *RegisterManager) SetChangeFilter(filter ChangeFilter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.changeDetector = NewChangeDetector(filter)
+}
+
+// SetVirtualTags sets the calculated tags evaluated after every read. Recalculated tags
+// are delivered as an extra group of float64 registers and go through the cache and
+// change filter like read registers. Expressions may only use loaded registers and other
+// virtual tags, so call LoadRegisters first.
+func (m *RegisterManager) SetVirtualTags(tags []VirtualTag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tag := range tags {
+		if _, ok := m.findRegister(tag.Tag); ok {
+			return fmt.Errorf("duplicate tag: %s", tag.Tag)
+		}
+	}
+	var inputs []string
+	for _, group := range m.groupedRegisters {
+		for _, register := range group {
+			inputs = append(inputs, register.Tag)
+		}
+	}
+	engine, err := NewVirtualTagEngine(tags, inputs)
+	if err != nil {
+		return err
+	}
+	m.virtualTags = engine
+	return nil
+}
+
+// SetAlarmEngine sets the alarm engine evaluated on every read, including virtual tags.
+// Alarm events are raised from ReadGroupedData while the manager lock is held, so the
+// event callback must not call back into the manager.
+func (m *RegisterManager) SetAlarmEngine(engine *AlarmEngine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alarms = engine
+}
+
+// SetHistorian sets the historian recording every register read, including virtual tags.
+func (m *RegisterManager) SetHistorian(historian *Historian) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.historian = historian
+}
+
+// SetTagCache sets the cache updated with every register read by ReadGroupedData,
+// before any change filtering.
+func (m *RegisterManager) SetTagCache(cache *TagCache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cache = cache
+}
+
+// SetWriteVerification enables or disables reading back written values in WriteTag.
+// Verification is enabled by default.
+func (m *RegisterManager) SetWriteVerification(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.verifyWrites = enabled
+}
+
 // Start begins processing the data queue
 func (m *RegisterManager) Start() {
 	go func() {
@@ -65,8 +157,11 @@ func (m *RegisterManager) Start() {
 					return
 				}
 				m.mu.Lock()
-				if m.OnReadCallback != nil {
-					m.OnReadCallback(data)
+				if data.registers != nil && m.OnReadCallback != nil {
+					m.OnReadCallback(data.registers)
+				}
+				if data.changes != nil && m.OnChangeCallback != nil {
+					m.OnChangeCallback(data.changes)
 				}
 				m.mu.Unlock()
 			}
@@ -81,12 +176,19 @@ func (m *RegisterManager) LoadRegisters(registers []DeviceRegister) error {
 	// Check register tag duplication
 	tagMap := make(map[string]bool)
 	for _, register := range registers {
-		if tagMap[register.Tag] {
-			m.OnErrorCallback(fmt.Errorf("duplicate tag: %s", register.Tag))
+		if tagMap[register.Tag] || m.virtualTags != nil && m.virtualTags.Has(register.Tag) {
+			if m.OnErrorCallback != nil {
+				m.OnErrorCallback(fmt.Errorf("duplicate tag: %s", register.Tag))
+			}
 			return fmt.Errorf("duplicate tag: %s", register.Tag)
 		}
 		tagMap[register.Tag] = true
 	}
+	if m.virtualTags != nil {
+		if virtual, input, ok := m.virtualTags.unknownInput(tagMap); ok {
+			return fmt.Errorf("virtual tag %s: unknown tag %s", virtual, input)
+		}
+	}
 	m.groupedRegisters = m.GroupDeviceRegister(registers)
 	return nil
 }
@@ -122,10 +224,36 @@ func (m *RegisterManager) ReadGroupedData() []error {
 	} else {
 		result, errors = ReadGroupedDataSequential(m.client, m.groupedRegisters)
 	}
+	resolveScaleFactors(result, m.scaleFactors)
+	if m.virtualTags != nil {
+		if calculated := m.virtualTags.Evaluate(result); len(calculated) > 0 {
+			result = append(result, calculated)
+		}
+	}
+	if m.cache != nil {
+		m.cache.UpdateGroups(result)
+	}
+	if m.alarms != nil {
+		m.alarms.EvaluateGroups(result)
+	}
+	if m.historian != nil {
+		m.historian.RecordGroups(result)
+	}
 
+	var changes []TagChange
+	if m.changeDetector != nil {
+		result, changes = m.changeDetector.Filter(result)
+	}
+	batches := make([]registerBatch, 0, len(result)+1)
 	for _, group := range result {
+		batches = append(batches, registerBatch{registers: group})
+	}
+	if len(changes) > 0 {
+		batches = append(batches, registerBatch{changes: changes})
+	}
+	for _, batch := range batches {
 		select {
-		case m.dataQueue <- group:
+		case m.dataQueue <- batch:
 		case _, ok := <-m.exitSignal:
 			if !ok {
 				return nil
@@ -135,3 +263,106 @@ func (m *RegisterManager) ReadGroupedData() []error {
 	}
 	return errors
 }
+
+// WriteTag encodes value for the register identified by tag and writes it to the device.
+// Scaling, byte order, bit-level merging and coil/register selection follow the register
+// definition. WriteTag holds the manager lock and must not be called from OnReadCallback.
+func (m *RegisterManager) WriteTag(tag string, value any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return fmt.Errorf("register manager is closed")
+	}
+	register, ok := m.findRegister(tag)
+	if !ok {
+		if m.virtualTags != nil && m.virtualTags.Has(tag) {
+			return valueError(tag, "virtual tag %s is read-only", tag)
+		}
+		return fmt.Errorf("unknown tag: %s", tag)
+	}
+	if sfTag, missing := missingScaleFactor(register, m.scaleFactors); missing {
+		return fmt.Errorf("%w: %s of tag %s has not been read", ErrScaleFactorUnavailable, sfTag, tag)
+	}
+	register = stampScaleFactors(register, m.scaleFactors)
+	return writeRegister(m.client, register, value, m.verifyWrites)
+}
+
+// ReadTag reads the register of a tag from the device now, outside the polling cycle.
+// Scale factor registers are applied with their last polled values.
+func (m *RegisterManager) ReadTag(tag string) (DeviceRegister, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return DeviceRegister{}, fmt.Errorf("register manager is closed")
+	}
+	register, ok := m.findRegister(tag)
+	if !ok {
+		if m.virtualTags != nil && m.virtualTags.Has(tag) {
+			return DeviceRegister{}, fmt.Errorf("virtual tag %s can only be read from a tag cache", tag)
+		}
+		return DeviceRegister{}, fmt.Errorf("unknown tag: %s", tag)
+	}
+	result, err := readGroup(m.client, []DeviceRegister{register})
+	if len(result) == 0 {
+		return register, err
+	}
+	return stampScaleFactors(result[0], m.scaleFactors), err
+}
+
+// ReadTags reads all loaded registers from the device now, outside the polling cycle, with
+// one request per register group. Unlike ReadGroupedData it does not update the cache,
+// alarms, historian or callbacks. Failed groups are returned with bad quality.
+func (m *RegisterManager) ReadTags() ([]DeviceRegister, []error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, []error{fmt.Errorf("register manager is closed")}
+	}
+	var result [][]DeviceRegister
+	var errors []error
+	if m.clientType == "TCP" {
+		result, errors = ReadGroupedDataConcurrently(m.client, m.groupedRegisters)
+	} else {
+		result, errors = ReadGroupedDataSequential(m.client, m.groupedRegisters)
+	}
+	resolveScaleFactors(result, m.scaleFactors)
+	var registers []DeviceRegister
+	for _, group := range result {
+		registers = append(registers, group...)
+	}
+	return registers, errors
+}
+
+// withClient runs fn with exclusive use of the client, so raw requests do not
+// interleave with polling
+func (m *RegisterManager) withClient(fn func(client Client) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return fmt.Errorf("register manager is closed")
+	}
+	return fn(m.client)
+}
+
+// Registers returns the loaded register definitions
+func (m *RegisterManager) Registers() []DeviceRegister {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var registers []DeviceRegister
+	for _, group := range m.groupedRegisters {
+		registers = append(registers, group...)
+	}
+	return registers
+}
+
+// findRegister looks up a loaded register by tag. Caller must hold the mutex.
+func (m *RegisterManager) findRegister(tag string) (DeviceRegister, bool) {
+	for _, group := range m.groupedRegisters {
+		for _, register := range group {
+			if register.Tag == tag {
+				return register, true
+			}
+		}
+	}
+	return DeviceRegister{}, false
+}
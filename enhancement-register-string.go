@@ -0,0 +1,158 @@
+package modbus
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// String encodings supported by DeviceRegister.StringEncoding
+const (
+	StringEncodingASCII   = "ascii"
+	StringEncodingUTF8    = "utf-8"
+	StringEncodingUTF16BE = "utf-16be"
+	StringEncodingUTF16LE = "utf-16le"
+	StringEncodingLatin1  = "latin1"
+)
+
+// decodeString converts register bytes to a string according to the register's
+// string options: optional per-word byte swap, character encoding and trimming.
+func (r DeviceRegister) decodeString(data []byte) (string, error) {
+	if r.StringByteSwap {
+		data = swapWordBytes(data)
+	}
+
+	var str string
+	switch strings.ToLower(r.StringEncoding) {
+	case "", StringEncodingUTF8, "utf8":
+		str = string(data)
+	case StringEncodingASCII:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			if b > 0x7F {
+				runes[i] = utf8.RuneError
+			} else {
+				runes[i] = rune(b)
+			}
+		}
+		str = string(runes)
+	case StringEncodingLatin1, "iso-8859-1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		str = string(runes)
+	case StringEncodingUTF16BE, StringEncodingUTF16LE:
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if strings.EqualFold(r.StringEncoding, StringEncodingUTF16LE) {
+				units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+			} else {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			}
+		}
+		str = string(utf16.Decode(units))
+	default:
+		return "", fmt.Errorf("unsupported string encoding: %s", r.StringEncoding)
+	}
+
+	switch strings.ToLower(r.StringTrim) {
+	case "":
+	case "null":
+		str = trimAtNull(str)
+	case "space":
+		str = strings.TrimRight(str, " ")
+	case "both":
+		str = strings.TrimRight(trimAtNull(str), " ")
+	default:
+		return "", fmt.Errorf("unsupported string trim mode: %s", r.StringTrim)
+	}
+	return str, nil
+}
+
+// encodeString converts str to register bytes according to the register's string
+// options, padding the result to size bytes. It is the inverse of decodeString.
+func (r DeviceRegister) encodeString(str string, size int) ([]byte, error) {
+	var pad rune
+	switch strings.ToLower(r.StringPad) {
+	case "", "null":
+		pad = 0x00
+	case "space":
+		pad = ' '
+	default:
+		return nil, fmt.Errorf("unsupported string pad mode: %s", r.StringPad)
+	}
+
+	encode := func(s string) ([]byte, error) {
+		switch strings.ToLower(r.StringEncoding) {
+		case "", StringEncodingUTF8, "utf8":
+			return []byte(s), nil
+		case StringEncodingASCII, StringEncodingLatin1, "iso-8859-1":
+			limit := rune(0xFF)
+			if strings.EqualFold(r.StringEncoding, StringEncodingASCII) {
+				limit = 0x7F
+			}
+			out := make([]byte, 0, len(s))
+			for _, c := range s {
+				if c > limit {
+					return nil, fmt.Errorf("character %q cannot be encoded as %s", c, r.StringEncoding)
+				}
+				out = append(out, byte(c))
+			}
+			return out, nil
+		case StringEncodingUTF16BE, StringEncodingUTF16LE:
+			units := utf16.Encode([]rune(s))
+			out := make([]byte, 2*len(units))
+			for i, u := range units {
+				if strings.EqualFold(r.StringEncoding, StringEncodingUTF16LE) {
+					out[2*i], out[2*i+1] = byte(u), byte(u>>8)
+				} else {
+					out[2*i], out[2*i+1] = byte(u>>8), byte(u)
+				}
+			}
+			return out, nil
+		default:
+			return nil, fmt.Errorf("unsupported string encoding: %s", r.StringEncoding)
+		}
+	}
+
+	data, err := encode(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > size {
+		return nil, fmt.Errorf("string too long for register: have %d bytes, room for %d", len(data), size)
+	}
+	padding, err := encode(string(pad))
+	if err != nil {
+		return nil, err
+	}
+	for len(data) < size {
+		data = append(data, padding...)
+	}
+	data = data[:size]
+
+	if r.StringByteSwap {
+		data = swapWordBytes(data)
+	}
+	return data, nil
+}
+
+// swapWordBytes swaps the two bytes of every 16-bit word, leaving a trailing odd byte in place
+func swapWordBytes(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+	for i := 0; i+1 < len(out); i += 2 {
+		out[i], out[i+1] = out[i+1], out[i]
+	}
+	return out
+}
+
+// trimAtNull cuts a C-style string at its first NUL character
+func trimAtNull(s string) string {
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
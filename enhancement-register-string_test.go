@@ -0,0 +1,116 @@
+package modbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeviceRegisterDecodeString(t *testing.T) {
+	tests := []struct {
+		name     string
+		register DeviceRegister
+		expected string
+	}{
+		{
+			name:     "raw bytes are kept by default",
+			register: DeviceRegister{DataType: "string", Value: []byte("AB\x00\x00")},
+			expected: "AB\x00\x00",
+		},
+		{
+			name:     "null trimmed",
+			register: DeviceRegister{DataType: "string", StringTrim: "null", Value: []byte("AB\x00C")},
+			expected: "AB",
+		},
+		{
+			name:     "space and null trimmed",
+			register: DeviceRegister{DataType: "string", StringTrim: "both", Value: []byte("AB  \x00\x00")},
+			expected: "AB",
+		},
+		{
+			name:     "word swapped",
+			register: DeviceRegister{DataType: "string", StringByteSwap: true, StringTrim: "null", Value: []byte("EMET\x00R")},
+			expected: "METER",
+		},
+		{
+			name:     "utf-16be",
+			register: DeviceRegister{DataType: "string", StringEncoding: "utf-16be", StringTrim: "null", Value: []byte{0x00, 'H', 0x00, 0xE9, 0x00, 0x00}},
+			expected: "Hé",
+		},
+		{
+			name:     "utf-16le",
+			register: DeviceRegister{DataType: "string", StringEncoding: "utf-16le", Value: []byte{0xAC, 0x20}},
+			expected: "€",
+		},
+		{
+			name:     "latin1",
+			register: DeviceRegister{DataType: "string", StringEncoding: "latin1", Value: []byte{'C', 0xB0}},
+			expected: "C°",
+		},
+		{
+			name:     "ascii replaces high bytes",
+			register: DeviceRegister{DataType: "string", StringEncoding: "ascii", Value: []byte{'A', 0xB0}},
+			expected: "A�",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			decoded, err := tc.register.DecodeValue()
+			if err != nil {
+				t.Fatalf("DecodeValue failed: %v", err)
+			}
+			if decoded.AsType != tc.expected {
+				t.Errorf("DecodeValue returned %q, expected %q", decoded.AsType, tc.expected)
+			}
+		})
+	}
+}
+
+func TestDeviceRegisterEncodeString(t *testing.T) {
+	tests := []struct {
+		name     string
+		register DeviceRegister
+		value    string
+		expected []byte
+	}{
+		{
+			name:     "space padded and word swapped",
+			register: DeviceRegister{DataType: "string", ReadQuantity: 3, StringPad: "space", StringByteSwap: true},
+			value:    "ABC",
+			expected: []byte{'B', 'A', ' ', 'C', ' ', ' '},
+		},
+		{
+			name:     "utf-16le null padded",
+			register: DeviceRegister{DataType: "string", ReadQuantity: 2, StringEncoding: "utf-16le"},
+			value:    "é",
+			expected: []byte{0xE9, 0x00, 0x00, 0x00},
+		},
+		{
+			name:     "latin1",
+			register: DeviceRegister{DataType: "string", ReadQuantity: 1, StringEncoding: "latin1"},
+			value:    "°",
+			expected: []byte{0xB0, 0x00},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := tc.register.EncodeValue(tc.value)
+			if err != nil {
+				t.Fatalf("EncodeValue failed: %v", err)
+			}
+			if !bytes.Equal(encoded, tc.expected) {
+				t.Errorf("EncodeValue returned % X, expected % X", encoded, tc.expected)
+			}
+		})
+	}
+
+	tooLong := DeviceRegister{DataType: "string", ReadQuantity: 1}
+	if _, err := tooLong.EncodeValue("ABC"); err == nil {
+		t.Errorf("expected error encoding a string longer than the register")
+	}
+	ascii := DeviceRegister{DataType: "string", ReadQuantity: 2, StringEncoding: "ascii"}
+	if _, err := ascii.EncodeValue("é"); err == nil {
+		t.Errorf("expected error encoding a non-ASCII character as ASCII")
+	}
+}
@@ -0,0 +1,185 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ValueError reports a write that was rejected before anything was sent to the device:
+// a value that cannot be encoded or is out of range, or a read-only tag
+type ValueError struct {
+	Tag string
+	Err error
+}
+
+func (e *ValueError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+// valueError returns a ValueError for tag with a formatted message
+func valueError(tag string, format string, args ...any) error {
+	return &ValueError{Tag: tag, Err: fmt.Errorf(format, args...)}
+}
+
+// writeRegister encodes value for the register and writes it with the function code that
+// matches the register's read function: coils (FC 1) are written with FC 5/15 and holding
+// registers (FC 3) with FC 6/16. When verify is set the written span is read back and compared.
+func writeRegister(client Client, reg DeviceRegister, value any, verify bool) error {
+	switch reg.Function {
+	case FuncCodeReadCoils:
+		return writeCoilRegister(client, reg, value, verify)
+	case FuncCodeReadHoldingRegisters:
+		return writeHoldingRegister(client, reg, value, verify)
+	case FuncCodeReadDiscreteInputs, FuncCodeReadInputRegisters:
+		return valueError(reg.Tag, "tag %s is read-only (function %d)", reg.Tag, reg.Function)
+	default:
+		return fmt.Errorf("unsupported Modbus function code: %d", reg.Function)
+	}
+}
+
+// writeCoilRegister writes a single coil from a bool-like value, or a block of coils
+// from a []bool whose length matches ReadQuantity.
+func writeCoilRegister(client Client, reg DeviceRegister, value any, verify bool) error {
+	quantity := reg.ReadQuantity
+	if quantity == 0 {
+		quantity = 1
+	}
+
+	var values []bool
+	if list, ok := value.([]bool); ok {
+		values = list
+	} else {
+		b, err := toBool(value)
+		if err != nil {
+			return &ValueError{Tag: reg.Tag, Err: err}
+		}
+		values = []bool{b}
+	}
+	if len(values) != int(quantity) {
+		return valueError(reg.Tag, "tag %s expects %d coil values, got %d", reg.Tag, quantity, len(values))
+	}
+
+	packed := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+
+	client.SetSlaveId(reg.SlaverId)
+	var err error
+	if quantity == 1 {
+		coil := uint16(0x0000)
+		if values[0] {
+			coil = 0xFF00
+		}
+		_, err = client.WriteSingleCoil(reg.ReadAddress, coil)
+	} else {
+		_, err = client.WriteMultipleCoils(reg.ReadAddress, quantity, packed)
+	}
+	if err != nil {
+		return fmt.Errorf("modbus write error (slave %d, addr %d): %w", reg.SlaverId, reg.ReadAddress, err)
+	}
+	if !verify {
+		return nil
+	}
+
+	readBack, err := client.ReadCoils(reg.ReadAddress, quantity)
+	if err != nil {
+		return fmt.Errorf("modbus read-back error (slave %d, addr %d): %w", reg.SlaverId, reg.ReadAddress, err)
+	}
+	for i, v := range values {
+		if i/8 >= len(readBack) || (readBack[i/8]&(1<<(i%8)) != 0) != v {
+			return fmt.Errorf("write verification failed for tag %s: coil %d does not read back as %v",
+				reg.Tag, int(reg.ReadAddress)+i, v)
+		}
+	}
+	return nil
+}
+
+// writeHoldingRegister encodes value and writes it to holding registers. Values that only
+// own part of a word (bool, bitfield, 8-bit types) are merged into the current contents.
+func writeHoldingRegister(client Client, reg DeviceRegister, value any, verify bool) error {
+	data, mask, err := reg.encodeValue(value)
+	if err != nil {
+		return valueError(reg.Tag, "encode error for tag %s: %w", reg.Tag, err)
+	}
+	quantity := uint16(len(data) / 2)
+
+	client.SetSlaveId(reg.SlaverId)
+	if !bytes.Equal(mask, bytes.Repeat([]byte{0xFF}, len(mask))) {
+		current, err := client.ReadHoldingRegisters(reg.ReadAddress, quantity)
+		if err != nil {
+			return fmt.Errorf("modbus read error (slave %d, addr %d): %w", reg.SlaverId, reg.ReadAddress, err)
+		}
+		if len(current) < len(data) {
+			return fmt.Errorf("short read for tag %s: have %d bytes, need %d", reg.Tag, len(current), len(data))
+		}
+		for i := range data {
+			data[i] = current[i]&^mask[i] | data[i]&mask[i]
+		}
+	}
+
+	if quantity == 1 {
+		_, err = client.WriteSingleRegister(reg.ReadAddress, binary.BigEndian.Uint16(data))
+	} else {
+		_, err = client.WriteMultipleRegisters(reg.ReadAddress, quantity, data)
+	}
+	if err != nil {
+		return fmt.Errorf("modbus write error (slave %d, addr %d): %w", reg.SlaverId, reg.ReadAddress, err)
+	}
+	if !verify {
+		return nil
+	}
+
+	readBack, err := client.ReadHoldingRegisters(reg.ReadAddress, quantity)
+	if err != nil {
+		return fmt.Errorf("modbus read-back error (slave %d, addr %d): %w", reg.SlaverId, reg.ReadAddress, err)
+	}
+	if len(readBack) < len(data) {
+		return fmt.Errorf("short read-back for tag %s: have %d bytes, need %d", reg.Tag, len(readBack), len(data))
+	}
+	for i := range data {
+		if readBack[i]&mask[i] != data[i]&mask[i] {
+			return fmt.Errorf("write verification failed for tag %s: wrote % X, read back % X",
+				reg.Tag, data, readBack[:len(data)])
+		}
+	}
+	return nil
+}
+
+// parseTagValue decodes a value to write from a message or request body: a JSON value,
+// an object with a "value" member, or plain text. JSON arrays of booleans become []bool
+// for coil blocks.
+func parseTagValue(payload []byte) any {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil || dec.More() {
+		return strings.TrimSpace(string(payload))
+	}
+	if object, ok := value.(map[string]any); ok {
+		if v, ok := object["value"]; ok {
+			value = v
+		}
+	}
+	if list, ok := value.([]any); ok {
+		bools := make([]bool, len(list))
+		for i, v := range list {
+			b, ok := v.(bool)
+			if !ok {
+				return value
+			}
+			bools[i] = b
+		}
+		return bools
+	}
+	return value
+}
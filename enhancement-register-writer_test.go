@@ -0,0 +1,213 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// memoryClient is an in-memory Client used to exercise register reads and writes
+// without a device. Holding and input registers share one table, as do coils and
+// discrete inputs.
+type memoryClient struct {
+	mu        sync.Mutex
+	slaveId   byte
+	registers map[byte]map[uint16]uint16
+	coils     map[byte]map[uint16]bool
+	writes    []string
+	readErr   error
+}
+
+func newMemoryClient() *memoryClient {
+	return &memoryClient{
+		registers: map[byte]map[uint16]uint16{},
+		coils:     map[byte]map[uint16]bool{},
+	}
+}
+
+func (c *memoryClient) setRegisters(slaveId byte, address uint16, values ...uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.registers[slaveId] == nil {
+		c.registers[slaveId] = map[uint16]uint16{}
+	}
+	for i, v := range values {
+		c.registers[slaveId][address+uint16(i)] = v
+	}
+}
+
+func (c *memoryClient) register(slaveId byte, address uint16) uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.registers[slaveId][address]
+}
+
+func (c *memoryClient) readBits(address, quantity uint16) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readErr != nil {
+		return nil, c.readErr
+	}
+	out := make([]byte, (quantity+7)/8)
+	for i := uint16(0); i < quantity; i++ {
+		if c.coils[c.slaveId][address+i] {
+			out[i/8] |= 1 << (i % 8)
+		}
+	}
+	return out, nil
+}
+
+func (c *memoryClient) readWords(address, quantity uint16) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readErr != nil {
+		return nil, c.readErr
+	}
+	out := make([]byte, quantity*2)
+	for i := uint16(0); i < quantity; i++ {
+		binary.BigEndian.PutUint16(out[i*2:], c.registers[c.slaveId][address+i])
+	}
+	return out, nil
+}
+
+func (c *memoryClient) ReadCoils(address, quantity uint16) ([]byte, error) {
+	return c.readBits(address, quantity)
+}
+func (c *memoryClient) ReadDiscreteInputs(address, quantity uint16) ([]byte, error) {
+	return c.readBits(address, quantity)
+}
+func (c *memoryClient) WriteSingleCoil(address, value uint16) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.coils[c.slaveId] == nil {
+		c.coils[c.slaveId] = map[uint16]bool{}
+	}
+	c.coils[c.slaveId][address] = value == 0xFF00
+	c.writes = append(c.writes, fmt.Sprintf("FC5 %d", address))
+	return dataBlock(address, value), nil
+}
+func (c *memoryClient) WriteMultipleCoils(address, quantity uint16, value []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.coils[c.slaveId] == nil {
+		c.coils[c.slaveId] = map[uint16]bool{}
+	}
+	for i := uint16(0); i < quantity; i++ {
+		c.coils[c.slaveId][address+i] = value[i/8]&(1<<(i%8)) != 0
+	}
+	c.writes = append(c.writes, fmt.Sprintf("FC15 %d", address))
+	return dataBlock(address, quantity), nil
+}
+func (c *memoryClient) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	return c.readWords(address, quantity)
+}
+func (c *memoryClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return c.readWords(address, quantity)
+}
+func (c *memoryClient) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	c.mu.Lock()
+	if c.registers[c.slaveId] == nil {
+		c.registers[c.slaveId] = map[uint16]uint16{}
+	}
+	c.registers[c.slaveId][address] = value
+	c.writes = append(c.writes, fmt.Sprintf("FC6 %d", address))
+	c.mu.Unlock()
+	return dataBlock(address, value), nil
+}
+func (c *memoryClient) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	c.mu.Lock()
+	if c.registers[c.slaveId] == nil {
+		c.registers[c.slaveId] = map[uint16]uint16{}
+	}
+	for i := uint16(0); i < quantity; i++ {
+		c.registers[c.slaveId][address+i] = binary.BigEndian.Uint16(value[i*2:])
+	}
+	c.writes = append(c.writes, fmt.Sprintf("FC16 %d", address))
+	c.mu.Unlock()
+	return dataBlock(address, quantity), nil
+}
+func (c *memoryClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (c *memoryClient) MaskWriteRegister(address, andMask, orMask uint16) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (c *memoryClient) ReadFIFOQueue(address uint16) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (c *memoryClient) ReadWithCustomFunction(code byte, address, quantity uint16) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (c *memoryClient) ReadDeviceIdentification(firstExtendedID byte) (map[byte]string, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (c *memoryClient) SendRawBytes(data []byte) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (c *memoryClient) GetInterfaceName() string { return "memory" }
+func (c *memoryClient) SetSlaveId(slaveId byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slaveId = slaveId
+}
+func (c *memoryClient) GetHandlerType() string { return "RTU" }
+func (c *memoryClient) Close() error           { return nil }
+
+func TestRegisterManagerWriteTag(t *testing.T) {
+	client := newMemoryClient()
+	client.setRegisters(1, 10, 0x00F0)
+	manager := NewRegisterManager(client, 10)
+	registers := []DeviceRegister{
+		{Tag: "setpoint", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "int16", Weight: 0.1},
+		{Tag: "power", SlaverId: 1, Function: 3, ReadAddress: 2, ReadQuantity: 2, DataType: "float32", DataOrder: "CDAB", Weight: 1},
+		{Tag: "enable", SlaverId: 1, Function: 3, ReadAddress: 10, ReadQuantity: 1, DataType: "bool", BitPosition: 0},
+		{Tag: "relay", SlaverId: 1, Function: 1, ReadAddress: 5, ReadQuantity: 1, DataType: "bool"},
+		{Tag: "input", SlaverId: 1, Function: 4, ReadAddress: 20, ReadQuantity: 1, DataType: "uint16"},
+	}
+	if err := manager.LoadRegisters(registers); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.WriteTag("setpoint", -12.3); err != nil {
+		t.Fatalf("WriteTag(setpoint) failed: %v", err)
+	}
+	if got := int16(client.register(1, 0)); got != -123 {
+		t.Errorf("setpoint raw value: got %d, expected -123", got)
+	}
+
+	if err := manager.WriteTag("power", float32(50)); err != nil {
+		t.Fatalf("WriteTag(power) failed: %v", err)
+	}
+	// 50.0 = 0x42480000, word swapped by CDAB
+	if hi, lo := client.register(1, 2), client.register(1, 3); hi != 0x0000 || lo != 0x4248 {
+		t.Errorf("power registers: got %04X %04X, expected 0000 4248", hi, lo)
+	}
+
+	if err := manager.WriteTag("enable", true); err != nil {
+		t.Fatalf("WriteTag(enable) failed: %v", err)
+	}
+	if got := client.register(1, 10); got != 0x00F1 {
+		t.Errorf("enable word: got %04X, expected 00F1 (other bits preserved)", got)
+	}
+
+	if err := manager.WriteTag("relay", "on"); err != nil {
+		t.Fatalf("WriteTag(relay) failed: %v", err)
+	}
+	if !client.coils[1][5] {
+		t.Errorf("relay coil was not switched on")
+	}
+	if last := client.writes[len(client.writes)-1]; last != "FC5 5" {
+		t.Errorf("relay write used %q, expected FC5", last)
+	}
+
+	if err := manager.WriteTag("input", 1); err == nil {
+		t.Errorf("expected error writing a read-only input register")
+	}
+	if err := manager.WriteTag("missing", 1); err == nil {
+		t.Errorf("expected error writing an unknown tag")
+	}
+	if err := manager.WriteTag("setpoint", 100000); err == nil {
+		t.Errorf("expected range error writing an oversized value")
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
+	"time"
 	"unsafe"
 )
 
@@ -21,14 +24,47 @@ type DeviceRegister struct {
 	DataOrder    string  `json:"dataOrder"`    // Byte order for multi-byte values (e.g., ABCD, DCBA)
 	BitPosition  uint16  `json:"bitPosition"`  // Bit position for bit-level operations (e.g., 0, 1, 2)
 	BitMask      uint16  `json:"bitMask"`      // Bitmask for bit-level operations (e.g., 0x01, 0x02)
-	Weight       float64 `json:"weight"`       // Scaling factor for the register value
+	Weight       float64 `json:"weight"`       // Scaling factor for the register value, 1 if zero
 	Frequency    uint64  `json:"frequency"`    // Polling frequency in milliseconds
 	Value        []byte  `json:"value"`        // Raw value of the register as a byte array (variable length)
 	Status       string  `json:"status"`       // Status of the register (e.g., "OK", "Error")
+	// String options, only used when DataType is "string"
+	StringEncoding string `json:"stringEncoding,omitempty"` // Character encoding: ascii, utf-8 (default), utf-16be, utf-16le, latin1
+	StringByteSwap bool   `json:"stringByteSwap,omitempty"` // Swap the two bytes of every register (word-swapped PLC strings)
+	StringTrim     string `json:"stringTrim,omitempty"`     // Trimming on decode: null, space or both
+	StringPad      string `json:"stringPad,omitempty"`      // Padding on encode: null (default) or space
+	// Transformation pipeline applied to Float64 after Weight, inverted on write
+	Transforms []ValueTransform `json:"transforms,omitempty"`
+	// Enum labels keyed by raw value and named bit fields of integer registers
+	Enum      map[int64]string `json:"enum,omitempty"`
+	BitFields []BitField       `json:"bitFields,omitempty"`
+	// Report-by-exception deadbands, see ChangeDetector
+	Deadband        float64 `json:"deadband,omitempty"`        // Absolute change of Float64 required to report
+	DeadbandPercent float64 `json:"deadbandPercent,omitempty"` // Change relative to the last reported value, in percent
+	// Structured quality and timestamps, filled in by every read
+	Quality       Quality   `json:"quality"`                 // Quality of Value
+	QualityReason string    `json:"qualityReason,omitempty"` // Reason code when Quality is not good, see Reason* constants
+	ExceptionCode uint8     `json:"exceptionCode,omitempty"` // Modbus exception code when QualityReason is "exception"
+	SourceTime    time.Time `json:"sourceTime"`              // Time the request that produced Value was sent
+	ReceiveTime   time.Time `json:"receiveTime"`             // Time of the last read attempt, successful or not
 }
 
-// DecodeValue converts the raw bytes in the register to a typed value based on the DataType
+// DecodeValue converts the raw bytes in the register to a typed value based on the DataType.
+// The quality and timestamps of the register are copied to the result; a value that
+// cannot be decoded has bad quality with reason "decode-error", unless the register
+// already has bad quality, e.g. because it was never read successfully.
 func (r DeviceRegister) DecodeValue() (DecodedValue, error) {
+	res, err := r.decodeValue()
+	res.Quality, res.QualityReason = r.quality()
+	res.SourceTime, res.ReceiveTime = r.SourceTime, r.ReceiveTime
+	if err != nil && res.Quality != QualityBad {
+		res.Quality, res.QualityReason = QualityBad, ReasonDecodeError
+	}
+	return res, err
+}
+
+// decodeValue decodes the raw bytes without quality information
+func (r DeviceRegister) decodeValue() (DecodedValue, error) {
 	// Check if we have enough bytes for the data type
 	requiredBytes, err := getRequiredBytes(r.DataType)
 	if err != nil {
@@ -52,7 +88,7 @@ func (r DeviceRegister) DecodeValue() (DecodedValue, error) {
 		uint16Val := binary.BigEndian.Uint16(bytes[:2])
 		uint16Val = uint16Val & r.BitMask
 		res.AsType = uint16Val
-		res.Float64 = float64(uint16Val) * r.Weight
+		res.Float64 = float64(uint16Val) * r.weight()
 	case "bool":
 		if len(bytes) < 2 {
 			return res, fmt.Errorf("not enough bytes for bool: need at least 2")
@@ -69,34 +105,34 @@ func (r DeviceRegister) DecodeValue() (DecodedValue, error) {
 		res.Float64 = float64(bytes[0])
 	case "uint8":
 		res.AsType = uint8(bytes[0])
-		res.Float64 = float64(res.AsType.(uint8)) * r.Weight
+		res.Float64 = float64(res.AsType.(uint8)) * r.weight()
 	case "int8":
 		res.AsType = int8(bytes[0])
-		res.Float64 = float64(res.AsType.(int8)) * r.Weight
+		res.Float64 = float64(res.AsType.(int8)) * r.weight()
 	case "uint16":
 		if len(bytes) < 2 {
 			return res, fmt.Errorf("not enough bytes for uint16: need at least 2")
 		}
 		res.AsType = binary.BigEndian.Uint16(bytes[:2])
-		res.Float64 = float64(res.AsType.(uint16)) * r.Weight
+		res.Float64 = float64(res.AsType.(uint16)) * r.weight()
 	case "int16":
 		if len(bytes) < 2 {
 			return res, fmt.Errorf("not enough bytes for int16: need at least 2")
 		}
 		res.AsType = int16(binary.BigEndian.Uint16(bytes[:2]))
-		res.Float64 = float64(res.AsType.(int16)) * r.Weight
+		res.Float64 = float64(res.AsType.(int16)) * r.weight()
 	case "uint32":
 		if len(bytes) < 4 {
 			return res, fmt.Errorf("not enough bytes for uint32: need at least 4")
 		}
 		res.AsType = binary.BigEndian.Uint32(bytes[:4])
-		res.Float64 = float64(res.AsType.(uint32)) * r.Weight
+		res.Float64 = float64(res.AsType.(uint32)) * r.weight()
 	case "int32":
 		if len(bytes) < 4 {
 			return res, fmt.Errorf("not enough bytes for int32: need at least 4")
 		}
 		res.AsType = int32(binary.BigEndian.Uint32(bytes[:4]))
-		res.Float64 = float64(res.AsType.(int32)) * r.Weight
+		res.Float64 = float64(res.AsType.(int32)) * r.weight()
 	case "float32":
 		if len(bytes) < 4 {
 			return res, fmt.Errorf("not enough bytes for float32: need at least 4")
@@ -104,7 +140,7 @@ func (r DeviceRegister) DecodeValue() (DecodedValue, error) {
 		bits := binary.BigEndian.Uint32(bytes[:4])
 		v := float32FromBits(bits)
 		res.AsType = v
-		res.Float64 = float64(v) * r.Weight
+		res.Float64 = float64(v) * r.weight()
 	case "float64":
 		if len(bytes) < 8 {
 			return res, fmt.Errorf("not enough bytes for float64: need at least 8")
@@ -112,26 +148,437 @@ func (r DeviceRegister) DecodeValue() (DecodedValue, error) {
 		bits := binary.BigEndian.Uint64(bytes[:8])
 		v := float64FromBits(bits)
 		res.AsType = v
-		res.Float64 = v * r.Weight
+		res.Float64 = v * r.weight()
+	case "uint48":
+		if len(bytes) < 6 {
+			return res, fmt.Errorf("not enough bytes for uint48: need at least 6")
+		}
+		v := uint64(binary.BigEndian.Uint16(bytes[:2]))<<32 | uint64(binary.BigEndian.Uint32(bytes[2:6]))
+		res.AsType = v
+		res.Float64 = float64(v) * r.weight()
+	case "uint64":
+		if len(bytes) < 8 {
+			return res, fmt.Errorf("not enough bytes for uint64: need at least 8")
+		}
+		res.AsType = binary.BigEndian.Uint64(bytes[:8])
+		res.Float64 = float64(res.AsType.(uint64)) * r.weight()
+	case "int64":
+		if len(bytes) < 8 {
+			return res, fmt.Errorf("not enough bytes for int64: need at least 8")
+		}
+		res.AsType = int64(binary.BigEndian.Uint64(bytes[:8]))
+		res.Float64 = float64(res.AsType.(int64)) * r.weight()
+	case "float16":
+		if len(bytes) < 2 {
+			return res, fmt.Errorf("not enough bytes for float16: need at least 2")
+		}
+		v := float16ToFloat32(binary.BigEndian.Uint16(bytes[:2]))
+		res.AsType = v
+		res.Float64 = float64(v) * r.weight()
+	case "bcd":
+		if len(bytes) < 2 {
+			return res, fmt.Errorf("not enough bytes for bcd: need at least 2")
+		}
+		v, err := decodeBCD(bytes[:2])
+		if err != nil {
+			return res, err
+		}
+		res.AsType = uint16(v)
+		res.Float64 = float64(v) * r.weight()
+	case "bcd32":
+		if len(bytes) < 4 {
+			return res, fmt.Errorf("not enough bytes for bcd32: need at least 4")
+		}
+		v, err := decodeBCD(bytes[:4])
+		if err != nil {
+			return res, err
+		}
+		res.AsType = uint32(v)
+		res.Float64 = float64(v) * r.weight()
 	case "string":
 		// Parse the entire byte slice as a string
-		res.AsType = string(bytes)
+		str, err := r.decodeString(bytes)
+		if err != nil {
+			return res, err
+		}
+		res.AsType = str
 		res.Float64 = 0 // Not applicable for strings
 	default:
 		return res, fmt.Errorf("unsupported data type: %s", r.DataType)
 	}
 
+	if len(r.Transforms) > 0 && r.DataType != "string" {
+		v, err := r.applyTransforms(res.Float64)
+		if err != nil {
+			return res, err
+		}
+		res.Float64 = v
+	}
+	r.decodeLabels(&res)
 	return res, nil
 }
 
+// weight returns the scaling factor of the register; a Weight of 0, i.e. not set,
+// means 1
+func (r DeviceRegister) weight() float64 {
+	if r.Weight == 0 {
+		return 1
+	}
+	return r.Weight
+}
+
+// EncodeValue converts an engineering value into the raw register bytes, applying the
+// inverse of Transforms, Weight and DataOrder. It is the counterpart of DecodeValue and returns
+// ReadQuantity*2 bytes in wire order, ready to be written to the device. Errors are
+// of type *ValueError.
+func (r DeviceRegister) EncodeValue(value any) ([]byte, error) {
+	data, _, err := r.encodeValue(value)
+	if err != nil {
+		return nil, &ValueError{Tag: r.Tag, Err: err}
+	}
+	return data, nil
+}
+
+// encodeValue returns the encoded bytes together with a mask of the bits owned by this
+// register. Bits outside the mask belong to other tags sharing the same word and must be
+// preserved by a read-modify-write.
+func (r DeviceRegister) encodeValue(value any) (data []byte, mask []byte, err error) {
+	requiredBytes, err := getRequiredBytes(r.DataType)
+	if err != nil {
+		return nil, nil, err
+	}
+	size := int(r.ReadQuantity) * 2
+	if size < requiredBytes {
+		size = requiredBytes + requiredBytes%2
+	}
+	weight := r.weight()
+	if fields, ok := toFieldMap(value); ok && len(r.BitFields) > 0 {
+		return r.encodeFieldMap(fields, size, requiredBytes)
+	}
+	applyTransforms := len(r.Transforms) > 0 && r.DataType != "string"
+	if label, ok := value.(string); ok && len(r.Enum) > 0 && r.DataType != "string" {
+		if raw, found := r.enumValue(label); found {
+			// Labels map to raw values, bypassing weight and transforms
+			value, weight, applyTransforms = raw, 1, false
+		}
+	}
+	if applyTransforms {
+		f, err := toFloat64(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		if value, err = r.invertTransforms(f); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	logical := make([]byte, size)
+	logicalMask := make([]byte, size)
+	for i := 0; i < requiredBytes; i++ {
+		logicalMask[i] = 0xFF
+	}
+
+	switch r.DataType {
+	case "bitfield":
+		raw, err := encodeNumber(value, weight, 0, math.MaxUint16)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint16(logical, uint16(raw)&r.BitMask)
+		binary.BigEndian.PutUint16(logicalMask, r.BitMask)
+	case "bool":
+		if r.BitPosition > 15 {
+			return nil, nil, fmt.Errorf("invalid bit position for bool: %d", r.BitPosition)
+		}
+		b, err := toBool(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		bit := uint16(1) << r.BitPosition
+		if b {
+			binary.BigEndian.PutUint16(logical, bit)
+		}
+		binary.BigEndian.PutUint16(logicalMask, bit)
+	case "byte":
+		raw, err := encodeNumber(value, 1, 0, math.MaxUint8)
+		if err != nil {
+			return nil, nil, err
+		}
+		logical[0] = byte(raw)
+	case "uint8":
+		raw, err := encodeNumber(value, weight, 0, math.MaxUint8)
+		if err != nil {
+			return nil, nil, err
+		}
+		logical[0] = uint8(raw)
+	case "int8":
+		raw, err := encodeNumber(value, weight, math.MinInt8, math.MaxInt8)
+		if err != nil {
+			return nil, nil, err
+		}
+		logical[0] = byte(int8(raw))
+	case "uint16":
+		raw, err := encodeNumber(value, weight, 0, math.MaxUint16)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint16(logical, uint16(raw))
+	case "int16":
+		raw, err := encodeNumber(value, weight, math.MinInt16, math.MaxInt16)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint16(logical, uint16(int16(raw)))
+	case "uint32":
+		raw, err := encodeNumber(value, weight, 0, math.MaxUint32)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint32(logical, uint32(raw))
+	case "int32":
+		raw, err := encodeNumber(value, weight, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint32(logical, uint32(int32(raw)))
+	case "uint48":
+		raw, err := encodeUint64(value, weight, 1<<48-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint16(logical, uint16(raw>>32))
+		binary.BigEndian.PutUint32(logical[2:], uint32(raw))
+	case "uint64":
+		raw, err := encodeUint64(value, weight, math.MaxUint64)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint64(logical, raw)
+	case "int64":
+		raw, err := encodeInt64(value, weight)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint64(logical, uint64(raw))
+	case "float16":
+		f, err := toFloat64(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint16(logical, float32ToFloat16(float32(f/weight)))
+	case "bcd":
+		raw, err := encodeNumber(value, weight, 0, 9999)
+		if err != nil {
+			return nil, nil, err
+		}
+		encodeBCD(logical[:2], uint64(raw))
+	case "bcd32":
+		raw, err := encodeNumber(value, weight, 0, 99999999)
+		if err != nil {
+			return nil, nil, err
+		}
+		encodeBCD(logical[:4], uint64(raw))
+	case "float32":
+		f, err := toFloat64(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint32(logical, math.Float32bits(float32(f/weight)))
+	case "float64":
+		f, err := toFloat64(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		binary.BigEndian.PutUint64(logical, math.Float64bits(f/weight))
+	case "string":
+		encoded, err := r.encodeString(fmt.Sprint(value), size)
+		if err != nil {
+			return nil, nil, err
+		}
+		copy(logical, encoded)
+		for i := range logicalMask {
+			logicalMask[i] = 0xFF
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported data type: %s", r.DataType)
+	}
+
+	return restoreByteOrder(logical, r.DataOrder), restoreByteOrder(logicalMask, r.DataOrder), nil
+}
+
+// encodeFieldMap encodes a map of bit field values, masking only the bits of the named
+// fields so the rest of the word is preserved on write.
+func (r DeviceRegister) encodeFieldMap(fields map[string]any, size, requiredBytes int) ([]byte, []byte, error) {
+	switch r.DataType {
+	case "bitfield", "byte", "uint8", "int8", "uint16", "int16", "uint32", "int32", "uint48", "uint64", "int64":
+	default:
+		return nil, nil, fmt.Errorf("bit fields are not supported for data type: %s", r.DataType)
+	}
+	bits, mask, err := r.encodeBitFields(fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	if requiredBytes < 8 && mask>>(8*requiredBytes) != 0 {
+		return nil, nil, fmt.Errorf("bit fields exceed the %d-bit register", 8*requiredBytes)
+	}
+	logical := make([]byte, size)
+	logicalMask := make([]byte, size)
+	putUintN(logical[:requiredBytes], bits)
+	putUintN(logicalMask[:requiredBytes], mask)
+	return restoreByteOrder(logical, r.DataOrder), restoreByteOrder(logicalMask, r.DataOrder), nil
+}
+
+// encodeNumber converts value to a raw integer by removing the weight and rounding,
+// and checks that the result fits in [minValue, maxValue].
+func encodeNumber(value any, weight, minValue, maxValue float64) (float64, error) {
+	f, err := toFloat64(value)
+	if err != nil {
+		return 0, err
+	}
+	raw := math.Round(f / weight)
+	if math.IsNaN(raw) || raw < minValue || raw > maxValue {
+		return 0, fmt.Errorf("value %v out of range [%v, %v] after scaling", value, minValue, maxValue)
+	}
+	return raw, nil
+}
+
+// encodeUint64 is the 64-bit variant of encodeNumber. Integer inputs with a unit weight
+// are converted exactly, so counters above 2^53 do not lose precision.
+func encodeUint64(value any, weight float64, maxValue uint64) (uint64, error) {
+	if weight == 1 {
+		switch v := value.(type) {
+		case uint64:
+			if v > maxValue {
+				return 0, fmt.Errorf("value %v out of range [0, %v]", value, maxValue)
+			}
+			return v, nil
+		case int64:
+			if v < 0 || uint64(v) > maxValue {
+				return 0, fmt.Errorf("value %v out of range [0, %v]", value, maxValue)
+			}
+			return uint64(v), nil
+		case string:
+			if u, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64); err == nil {
+				return encodeUint64(u, weight, maxValue)
+			}
+		}
+	}
+	f, err := toFloat64(value)
+	if err != nil {
+		return 0, err
+	}
+	raw := math.Round(f / weight)
+	if math.IsNaN(raw) || raw < 0 || raw >= math.Ldexp(1, 64) || uint64(raw) > maxValue {
+		return 0, fmt.Errorf("value %v out of range [0, %v] after scaling", value, maxValue)
+	}
+	return uint64(raw), nil
+}
+
+// encodeInt64 is the signed counterpart of encodeUint64.
+func encodeInt64(value any, weight float64) (int64, error) {
+	if weight == 1 {
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case uint64:
+			if v > math.MaxInt64 {
+				return 0, fmt.Errorf("value %v out of range for int64", value)
+			}
+			return int64(v), nil
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return i, nil
+			}
+		}
+	}
+	f, err := toFloat64(value)
+	if err != nil {
+		return 0, err
+	}
+	raw := math.Round(f / weight)
+	if math.IsNaN(raw) || raw < math.MinInt64 || raw >= math.MaxInt64 {
+		return 0, fmt.Errorf("value %v out of range for int64 after scaling", value)
+	}
+	return int64(raw), nil
+}
+
+// toFloat64 converts numeric, boolean and numeric string values to float64
+func toFloat64(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %q to number", v)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to number", value)
+	}
+}
+
+// toBool converts booleans, numbers and strings such as "true", "on" or "1" to bool
+func toBool(value any) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "on", "1":
+			return true, nil
+		case "false", "off", "0":
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot convert %q to bool", v)
+	default:
+		f, err := toFloat64(value)
+		if err != nil {
+			return false, fmt.Errorf("cannot convert %T to bool", value)
+		}
+		return f != 0, nil
+	}
+}
+
 // getRequiredBytes returns the number of bytes required for a given data type
 func getRequiredBytes(dataType string) (int, error) {
 	switch dataType {
-	case "bitfield", "bool", "uint16", "int16":
+	case "bitfield", "bool", "uint16", "int16", "float16", "bcd":
 		return 2, nil
-	case "uint32", "int32", "float32":
+	case "uint32", "int32", "float32", "bcd32":
 		return 4, nil
-	case "uint64", "float64":
+	case "uint48":
+		return 6, nil
+	case "uint64", "int64", "float64":
 		return 8, nil
 	case "byte", "uint8", "int8":
 		return 1, nil
@@ -177,66 +624,66 @@ func CheckBit(num uint16, index uint16) bool {
 	return (num & mask) != 0
 }
 
-// reorderBytes reorders the bytes according to the specified byte order
+// reorderBytes reorders the bytes according to the specified byte order.
+// The order is written with one letter per byte, where 'A' is the first byte on the
+// wire: "ABCD" keeps big-endian order, "CDAB" swaps words and "FEHGBADC" reverses the
+// words of a 64-bit value while swapping the bytes inside each word. Any permutation
+// of the leading letters is accepted (e.g. "AB", "BADCFE", "HGFEDCBA").
 func reorderBytes(data []byte, order string) []byte {
-	length := len(data)
+	if !isValidDataOrder(order) || len(data) < len(order) {
+		// Default to returning the original data
+		return data
+	}
+	result := make([]byte, len(order))
+	for i := 0; i < len(order); i++ {
+		result[i] = data[order[i]-'A']
+	}
+	return result
+}
 
-	switch order {
-	case "A":
-		if length >= 1 {
-			return data[:1]
-		}
-	case "AB":
-		if length >= 2 {
-			return data[:2]
-		}
-	case "BA":
-		if length >= 2 {
-			return []byte{data[1], data[0]}
-		}
-	case "ABCD":
-		if length >= 4 {
-			return data[:4]
-		}
-	case "DCBA":
-		if length >= 4 {
-			return []byte{data[3], data[2], data[1], data[0]}
-		}
-	case "BADC":
-		if length >= 4 {
-			return []byte{data[1], data[0], data[3], data[2]}
-		}
-	case "CDAB":
-		if length >= 4 {
-			return []byte{data[2], data[3], data[0], data[1]}
-		}
-	case "ABCDEFGH":
-		if length >= 8 {
-			return data[:8]
-		}
-	case "HGFEDCBA":
-		if length >= 8 {
-			return []byte{data[7], data[6], data[5], data[4], data[3], data[2], data[1], data[0]}
-		}
-	case "BADCFEHG":
-		if length >= 8 {
-			return []byte{data[1], data[0], data[3], data[2], data[5], data[4], data[7], data[6]}
-		}
-	case "GHEFCDAB":
-		if length >= 8 {
-			return []byte{data[6], data[7], data[4], data[5], data[2], data[3], data[0], data[1]}
+// isValidDataOrder reports whether order is a permutation of the first len(order)
+// letters of the alphabet.
+func isValidDataOrder(order string) bool {
+	if len(order) == 0 || len(order) > 26 {
+		return false
+	}
+	seen := make([]bool, len(order))
+	for i := 0; i < len(order); i++ {
+		index := int(order[i]) - 'A'
+		if index < 0 || index >= len(order) || seen[index] {
+			return false
 		}
+		seen[index] = true
 	}
+	return true
+}
 
-	// Default to returning the original data
-	return data
+// restoreByteOrder is the inverse of reorderBytes: it puts logically ordered bytes back
+// at the wire positions described by order. Positions not covered by order are left zero.
+func restoreByteOrder(data []byte, order string) []byte {
+	index := make([]byte, len(data))
+	for i := range index {
+		index[i] = byte(i)
+	}
+	out := make([]byte, len(data))
+	for i, pos := range reorderBytes(index, order) {
+		out[pos] = data[i]
+	}
+	return out
 }
 
 // DecodedValue holds all possible interpretations of a raw Modbus value
 type DecodedValue struct {
-	Raw     []byte  `json:"raw"`     // Raw value as bytes
-	Float64 float64 `json:"float64"` // Value as float64
-	AsType  any     `json:"asType"`  // Value as any type
+	Raw           []byte            `json:"raw"`                     // Raw value as bytes
+	Float64       float64           `json:"float64"`                 // Value as float64
+	AsType        any               `json:"asType"`                  // Value as any type
+	Label         string            `json:"label,omitempty"`         // Enum label of the raw value, if any
+	Flags         map[string]uint64 `json:"flags,omitempty"`         // Values of the named bit fields
+	FlagLabels    map[string]string `json:"flagLabels,omitempty"`    // Labels of the named bit field values, if any
+	Quality       Quality           `json:"quality"`                 // Quality of the value
+	QualityReason string            `json:"qualityReason,omitempty"` // Reason code when Quality is not good
+	SourceTime    time.Time         `json:"sourceTime"`              // Time the request that produced the value was sent
+	ReceiveTime   time.Time         `json:"receiveTime"`             // Time of the last read attempt
 }
 
 // GetFloat64Value returns the Float64 value, optionally rounded to the specified number of decimal places
@@ -252,6 +699,87 @@ func (dv DecodedValue) String() string {
 	return fmt.Sprintf("Raw: %v, Float64: %f, AsType: %v", dv.Raw, dv.Float64, dv.AsType)
 }
 
+// decodeBCD decodes packed binary-coded decimal, two digits per byte, most significant first
+func decodeBCD(data []byte) (uint64, error) {
+	var v uint64
+	for _, b := range data {
+		hi, lo := b>>4, b&0x0F
+		if hi > 9 || lo > 9 {
+			return 0, fmt.Errorf("invalid BCD byte: 0x%02X", b)
+		}
+		v = v*100 + uint64(hi)*10 + uint64(lo)
+	}
+	return v, nil
+}
+
+// encodeBCD writes v as packed binary-coded decimal filling dst
+func encodeBCD(dst []byte, v uint64) {
+	for i := len(dst) - 1; i >= 0; i-- {
+		lo := v % 10
+		v /= 10
+		hi := v % 10
+		v /= 10
+		dst[i] = byte(hi<<4 | lo)
+	}
+}
+
+// float16ToFloat32 converts an IEEE 754 half-precision value to a float32
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1F
+	frac := uint32(h) & 0x3FF
+	switch {
+	case exp == 0x1F: // Inf or NaN
+		return math.Float32frombits(sign | 0xFF<<23 | frac<<13)
+	case exp == 0 && frac == 0: // Zero
+		return math.Float32frombits(sign)
+	case exp == 0: // Subnormal
+		v := float32(math.Ldexp(float64(frac), -24))
+		if sign != 0 {
+			v = -v
+		}
+		return v
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | frac<<13)
+}
+
+// float32ToFloat16 converts a float32 to IEEE 754 half precision, rounding to nearest even
+func float32ToFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int32(bits>>23) & 0xFF
+	frac := bits & 0x7FFFFF
+
+	switch {
+	case exp == 0xFF: // Inf or NaN
+		if frac != 0 {
+			return sign | 0x7E00
+		}
+		return sign | 0x7C00
+	case exp-127+15 >= 0x1F: // Overflow
+		return sign | 0x7C00
+	case exp-127+15 <= 0: // Subnormal or zero
+		shift := uint32(14 - (exp - 127 + 15))
+		if shift > 24 {
+			return sign
+		}
+		mantissa := frac | 0x800000
+		half := mantissa >> shift
+		rem := mantissa & (1<<shift - 1)
+		mid := uint32(1) << (shift - 1)
+		if rem > mid || (rem == mid && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+	half := uint32(exp-127+15)<<10 | frac>>13
+	rem := frac & 0x1FFF
+	if rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		half++ // may carry into the exponent, which is the correct rounding
+	}
+	return sign | uint16(half)
+}
+
 // float32FromBits converts a uint32 to a float32
 func float32FromBits(bits uint32) float32 {
 	return *(*float32)(unsafe.Pointer(&bits))
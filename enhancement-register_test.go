@@ -0,0 +1,240 @@
+package modbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeviceRegisterEncodeValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		register DeviceRegister
+		value    any
+		expected []byte
+	}{
+		{
+			name:     "uint16 big endian",
+			register: DeviceRegister{DataType: "uint16", DataOrder: "AB", ReadQuantity: 1, Weight: 1},
+			value:    0x1234,
+			expected: []byte{0x12, 0x34},
+		},
+		{
+			name:     "uint16 little endian",
+			register: DeviceRegister{DataType: "uint16", DataOrder: "BA", ReadQuantity: 1, Weight: 1},
+			value:    0x1234,
+			expected: []byte{0x34, 0x12},
+		},
+		{
+			name:     "int16 with weight",
+			register: DeviceRegister{DataType: "int16", ReadQuantity: 1, Weight: 0.1},
+			value:    "-1.5",
+			expected: []byte{0xFF, 0xF1},
+		},
+		{
+			name:     "uint32 word swapped",
+			register: DeviceRegister{DataType: "uint32", DataOrder: "CDAB", ReadQuantity: 2, Weight: 1},
+			value:    uint32(0x11223344),
+			expected: []byte{0x33, 0x44, 0x11, 0x22},
+		},
+		{
+			name:     "float32 little endian",
+			register: DeviceRegister{DataType: "float32", DataOrder: "DCBA", ReadQuantity: 2, Weight: 1},
+			value:    50.0,
+			expected: []byte{0x00, 0x00, 0x48, 0x42},
+		},
+		{
+			name:     "bool bit 3",
+			register: DeviceRegister{DataType: "bool", BitPosition: 3, ReadQuantity: 1},
+			value:    true,
+			expected: []byte{0x00, 0x08},
+		},
+		{
+			name:     "string padded",
+			register: DeviceRegister{DataType: "string", ReadQuantity: 2},
+			value:    "AB",
+			expected: []byte{'A', 'B', 0x00, 0x00},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := tc.register.EncodeValue(tc.value)
+			if err != nil {
+				t.Fatalf("EncodeValue failed: %v", err)
+			}
+			if !bytes.Equal(encoded, tc.expected) {
+				t.Errorf("EncodeValue returned % X, expected % X", encoded, tc.expected)
+			}
+		})
+	}
+}
+
+func TestDeviceRegisterEncodeDecodeRoundTrip(t *testing.T) {
+	orders := map[string][]string{
+		"uint16":  {"AB", "BA"},
+		"int16":   {"AB", "BA"},
+		"uint32":  {"ABCD", "DCBA", "BADC", "CDAB"},
+		"int32":   {"ABCD", "DCBA", "BADC", "CDAB"},
+		"float32": {"ABCD", "DCBA", "BADC", "CDAB"},
+		"float64": {"ABCDEFGH", "HGFEDCBA", "BADCFEHG", "GHEFCDAB"},
+		"uint48":  {"ABCDEF", "FEDCBA", "BADCFE", "EFCDAB"},
+		"uint64":  {"ABCDEFGH", "HGFEDCBA", "FEHGBADC", "GHEFCDAB"},
+		"int64":   {"ABCDEFGH", "HGFEDCBA", "FEHGBADC", "GHEFCDAB"},
+		"float16": {"AB", "BA"},
+		"bcd":     {"AB", "BA"},
+		"bcd32":   {"ABCD", "CDAB"},
+	}
+	for dataType, list := range orders {
+		for _, order := range list {
+			size, _ := getRequiredBytes(dataType)
+			register := DeviceRegister{DataType: dataType, DataOrder: order, ReadQuantity: uint16(size / 2), Weight: 0.5}
+			expected := -21.5
+			if dataType[0] == 'u' || dataType[0] == 'b' {
+				expected = 21.5
+			}
+			encoded, err := register.EncodeValue(expected)
+			if err != nil {
+				t.Fatalf("%s/%s: EncodeValue failed: %v", dataType, order, err)
+			}
+			register.Value = encoded
+			decoded, err := register.DecodeValue()
+			if err != nil {
+				t.Fatalf("%s/%s: DecodeValue failed: %v", dataType, order, err)
+			}
+			if !FuzzyEqual(decoded.Float64, expected) {
+				t.Errorf("%s/%s: round trip returned %v, expected %v", dataType, order, decoded.Float64, expected)
+			}
+		}
+	}
+}
+
+func TestDeviceRegisterWeightNotSet(t *testing.T) {
+	// A register without Weight reads and writes unscaled values
+	for _, dataType := range []string{"uint16", "int32", "float32", "bitfield"} {
+		size, _ := getRequiredBytes(dataType)
+		register := DeviceRegister{DataType: dataType, ReadQuantity: uint16(size / 2), BitMask: 0xFFFF}
+		encoded, err := register.EncodeValue(42)
+		if err != nil {
+			t.Fatalf("%s: EncodeValue failed: %v", dataType, err)
+		}
+		register.Value = encoded
+		if decoded, err := register.DecodeValue(); err != nil || decoded.Float64 != 42 {
+			t.Errorf("%s: round trip returned %v, %v, expected 42", dataType, decoded.Float64, err)
+		}
+	}
+}
+
+func TestDeviceRegisterDecodeExtendedTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		register DeviceRegister
+		asType   any
+		float64  float64
+	}{
+		{
+			name:     "uint64 big endian",
+			register: DeviceRegister{DataType: "uint64", DataOrder: "ABCDEFGH", Weight: 1, Value: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE}},
+			asType:   uint64(0xFFFFFFFFFFFFFFFE),
+			float64:  float64(uint64(0xFFFFFFFFFFFFFFFE)),
+		},
+		{
+			name:     "int64 FEHGBADC",
+			register: DeviceRegister{DataType: "int64", DataOrder: "FEHGBADC", Weight: 1, Value: []byte{0xFF, 0xFF, 0xF8, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+			asType:   int64(-8),
+			float64:  -8,
+		},
+		{
+			name:     "uint48 word swapped energy counter",
+			register: DeviceRegister{DataType: "uint48", DataOrder: "EFCDAB", Weight: 0.01, Value: []byte{0x00, 0x05, 0x00, 0x00, 0x00, 0x01}},
+			asType:   uint64(1<<32 + 5),
+			float64:  float64(1<<32+5) * 0.01,
+		},
+		{
+			name:     "float16 one",
+			register: DeviceRegister{DataType: "float16", Weight: 1, Value: []byte{0x3C, 0x00}},
+			asType:   float32(1),
+			float64:  1,
+		},
+		{
+			name:     "float16 max",
+			register: DeviceRegister{DataType: "float16", Weight: 1, Value: []byte{0x7B, 0xFF}},
+			asType:   float32(65504),
+			float64:  65504,
+		},
+		{
+			name:     "float16 negative subnormal",
+			register: DeviceRegister{DataType: "float16", Weight: 1, Value: []byte{0x80, 0x01}},
+			asType:   float32(-5.9604645e-08),
+			float64:  float64(float32(-5.9604645e-08)),
+		},
+		{
+			name:     "bcd",
+			register: DeviceRegister{DataType: "bcd", Weight: 1, Value: []byte{0x12, 0x34}},
+			asType:   uint16(1234),
+			float64:  1234,
+		},
+		{
+			name:     "bcd32",
+			register: DeviceRegister{DataType: "bcd32", DataOrder: "CDAB", Weight: 0.1, Value: []byte{0x56, 0x78, 0x12, 0x34}},
+			asType:   uint32(12345678),
+			float64:  1234567.8,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			decoded, err := tc.register.DecodeValue()
+			if err != nil {
+				t.Fatalf("DecodeValue failed: %v", err)
+			}
+			if decoded.AsType != tc.asType {
+				t.Errorf("AsType = %v (%T), expected %v (%T)", decoded.AsType, decoded.AsType, tc.asType, tc.asType)
+			}
+			if !FuzzyEqual(decoded.Float64, tc.float64) {
+				t.Errorf("Float64 = %v, expected %v", decoded.Float64, tc.float64)
+			}
+		})
+	}
+
+	invalid := DeviceRegister{DataType: "bcd", Value: []byte{0x1A, 0x00}}
+	if _, err := invalid.DecodeValue(); err == nil {
+		t.Errorf("expected error decoding invalid BCD digits")
+	}
+}
+
+func TestFloat16Conversion(t *testing.T) {
+	for _, h := range []uint16{0x0000, 0x8000, 0x0001, 0x03FF, 0x0400, 0x3C00, 0x3555, 0xC000, 0x7BFF, 0x7C00, 0xFC00} {
+		if got := float32ToFloat16(float16ToFloat32(h)); got != h {
+			t.Errorf("float16 round trip of 0x%04X returned 0x%04X", h, got)
+		}
+	}
+	if got := float32ToFloat16(1e6); got != 0x7C00 {
+		t.Errorf("float32ToFloat16(1e6) = 0x%04X, expected +Inf 0x7C00", got)
+	}
+}
+
+func TestReorderBytes(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	tests := map[string][]byte{
+		"A":        {1},
+		"BA":       {2, 1},
+		"CDAB":     {3, 4, 1, 2},
+		"EFCDAB":   {5, 6, 3, 4, 1, 2},
+		"FEHGBADC": {6, 5, 8, 7, 2, 1, 4, 3},
+		"GHEFCDAB": {7, 8, 5, 6, 3, 4, 1, 2},
+		"AAB":      data, // not a permutation
+		"XYZ":      data, // unknown letters
+		"":         data,
+	}
+	for order, expected := range tests {
+		if got := reorderBytes(data, order); !bytes.Equal(got, expected) {
+			t.Errorf("reorderBytes(%q) = %v, expected %v", order, got, expected)
+		}
+		if isValidDataOrder(order) {
+			restored := restoreByteOrder(reorderBytes(data, order), order)
+			if !bytes.Equal(restored, data[:len(order)]) {
+				t.Errorf("restoreByteOrder(%q) = %v, expected %v", order, restored, data[:len(order)])
+			}
+		}
+	}
+}
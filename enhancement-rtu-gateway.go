@@ -0,0 +1,219 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// rtuRequestLength returns the length of the RTU request frame starting with frame,
+// 0 when more bytes are needed to tell, or -1 for function codes without a known
+// length, which end with the silence after the frame.
+func rtuRequestLength(frame []byte) int {
+	if len(frame) < 2 {
+		return 0
+	}
+	switch frame[1] {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs, FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister, 0x08:
+		return 8
+	case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		if len(frame) < 7 {
+			return 0
+		}
+		return 9 + int(frame[6])
+	case FuncCodeReadWriteMultipleRegisters:
+		if len(frame) < 11 {
+			return 0
+		}
+		return 13 + int(frame[10])
+	case FuncCodeMaskWriteRegister:
+		return 10
+	case FuncCodeReadExceptionStatus, 0x0B, 0x0C, 0x11:
+		return 4
+	case FuncCodeReadFIFOQueue:
+		return 6
+	case FuncCodeMEI:
+		return 7
+	}
+	return -1
+}
+
+// RTUTCPRoute forwards the requests for one RTU slave address to a Modbus TCP device
+type RTUTCPRoute struct {
+	Address string        // host:port of the TCP device
+	Unit    uint8         // Unit id on the TCP side, the RTU slave address if zero; broadcasts keep unit 0
+	Timeout time.Duration // Response timeout of the device, 1s if zero
+}
+
+// RTUTCPGateway answers as one or more RTU slaves on a serial port and forwards the
+// requests of the serial master to Modbus TCP devices by slave address. Requests for
+// slave addresses without a route are left unanswered, as other slaves may share the
+// line. Exceptions of the devices are passed through; a device that cannot be
+// connected is answered with exception 0x0A (gateway path unavailable), and one that
+// does not respond in time with exception 0x0B (gateway target device failed to
+// respond). Broadcasts are forwarded to every routed device without a response.
+type RTUTCPGateway struct {
+	FrameDelay time.Duration   // Silence ending frames of unknown length, 20ms if zero
+	OnError    func(err error) // Optional callback for framing and device errors
+
+	mu       sync.Mutex
+	port     io.ReadWriteCloser
+	reader   *serialReader
+	packager *RTUPackager
+	routes   map[uint8]RTUTCPRoute
+	devices  map[string]*TCPTransactor // Connections by address, shared by routes
+	closed   bool
+}
+
+// NewRTUTCPGateway creates a gateway answering on an open serial port
+func NewRTUTCPGateway(port io.ReadWriteCloser) *RTUTCPGateway {
+	return &RTUTCPGateway{
+		port:     port,
+		packager: NewRTUPackager(),
+		routes:   make(map[uint8]RTUTCPRoute),
+		devices:  make(map[string]*TCPTransactor),
+	}
+}
+
+// Route forwards requests for an RTU slave address to a TCP device
+func (g *RTUTCPGateway) Route(slave uint8, route RTUTCPRoute) error {
+	if slave == 0 {
+		return fmt.Errorf("cannot route the broadcast address")
+	}
+	if route.Address == "" {
+		return fmt.Errorf("route for slave %d has no address", slave)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.routes[slave] = route
+	return nil
+}
+
+// Serve answers requests on the serial port until Close or a port error. After Close
+// it returns net.ErrClosed.
+func (g *RTUTCPGateway) Serve() error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return net.ErrClosed
+	}
+	if g.reader == nil {
+		g.reader = newSerialReader(g.port)
+	}
+	reader := g.reader
+	g.mu.Unlock()
+	for {
+		frame, err := reader.readFrame(rtuRequestLength, 0, frameDelay(g.FrameDelay))
+		if err != nil {
+			g.mu.Lock()
+			closed := g.closed
+			g.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			return fmt.Errorf("rtu gateway: %w", err)
+		}
+		slave, pdu, err := g.packager.Unpack(frame)
+		if err != nil || len(pdu) == 0 {
+			// Resynchronize on the next frame
+			g.reportError(fmt.Errorf("rtu gateway: invalid frame % X", frame))
+			reader.flush(frameDelay(g.FrameDelay))
+			continue
+		}
+		response := g.handle(slave, pdu)
+		if response == nil {
+			continue
+		}
+		out, _ := g.packager.Pack(slave, response)
+		if _, err := g.port.Write(out); err != nil {
+			return fmt.Errorf("rtu gateway: write: %w", err)
+		}
+	}
+}
+
+// handle forwards a request and returns the response PDU, or nil when the gateway
+// must not answer
+func (g *RTUTCPGateway) handle(slave uint8, pdu []byte) []byte {
+	g.mu.Lock()
+	var routes []RTUTCPRoute
+	if slave == 0 {
+		for _, route := range g.routes {
+			routes = append(routes, route)
+		}
+	} else if route, ok := g.routes[slave]; ok {
+		routes = append(routes, route)
+	}
+	g.mu.Unlock()
+
+	if slave == 0 {
+		for _, route := range routes {
+			if _, err := g.forward(route, 0, pdu); err != nil {
+				g.reportError(fmt.Errorf("rtu gateway: broadcast to %s: %w", route.Address, err))
+			}
+		}
+		return nil
+	}
+	if len(routes) == 0 {
+		return nil
+	}
+	response, err := g.forward(routes[0], slave, pdu)
+	if err != nil {
+		g.reportError(fmt.Errorf("rtu gateway: slave %d via %s: %w", slave, routes[0].Address, err))
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return exceptionPDU(pdu[0], ExceptionCodeGatewayPathUnavailable)
+		}
+		return exceptionPDU(pdu[0], ExceptionCodeGatewayTargetDeviceFailedToRespond)
+	}
+	return response
+}
+
+// forward sends a request PDU to the device of a route and returns its response PDU
+func (g *RTUTCPGateway) forward(route RTUTCPRoute, slave uint8, pdu []byte) ([]byte, error) {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	device, ok := g.devices[route.Address]
+	if !ok {
+		device = NewTCPTransactor(NewTCPClientHandler(route.Address))
+		g.devices[route.Address] = device
+	}
+	g.mu.Unlock()
+
+	// Broadcasts keep unit 0, as a unit id would make the device answer
+	unit := route.Unit
+	if unit == 0 || slave == 0 {
+		unit = slave
+	}
+	timeout := route.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	// Broadcasts are not answered, so do not wait for the device
+	return device.transact(unit, pdu, timeout, unit != 0)
+}
+
+// Close closes the serial port and the connections to the devices
+func (g *RTUTCPGateway) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	devices := g.devices
+	g.devices = make(map[string]*TCPTransactor)
+	g.mu.Unlock()
+	for _, device := range devices {
+		device.Close()
+	}
+	return g.port.Close()
+}
+
+func (g *RTUTCPGateway) reportError(err error) {
+	if g.OnError != nil {
+		g.OnError(err)
+	}
+}
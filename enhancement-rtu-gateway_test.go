@@ -0,0 +1,315 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRTUMaster sends requests on the serial side of an RTUTCPGateway
+type testRTUMaster struct {
+	port     net.Conn
+	reader   *serialReader
+	packager *RTUPackager
+}
+
+func (m *testRTUMaster) transact(t *testing.T, slave uint8, pdu []byte, timeout time.Duration) ([]byte, error) {
+	t.Helper()
+	frame, _ := m.packager.Pack(slave, pdu)
+	if _, err := m.port.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	response, err := m.reader.readFrame(rtuResponseLength, timeout, frameDelay(0))
+	if err != nil {
+		return nil, err
+	}
+	responseSlave, responsePDU, err := m.packager.Unpack(response)
+	if err != nil {
+		t.Fatalf("invalid response % X: %v", response, err)
+	}
+	if responseSlave != slave {
+		t.Errorf("response from slave %d, expected %d", responseSlave, slave)
+	}
+	return responsePDU, nil
+}
+
+func startTestRTUTCPGateway(t *testing.T, gateway *RTUTCPGateway, serial net.Conn) *testRTUMaster {
+	served := make(chan error, 1)
+	go func() { served <- gateway.Serve() }()
+	t.Cleanup(func() {
+		gateway.Close()
+		if err := <-served; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+		serial.Close()
+	})
+	return &testRTUMaster{port: serial, reader: newSerialReader(serial), packager: NewRTUPackager()}
+}
+
+func TestRTUTCPGateway(t *testing.T) {
+	// The TCP devices are simulated slaves behind a TCP-to-RTU gateway
+	slaves := &testRTUSlaves{registers: map[uint8][]uint16{1: {10, 20, 30}, 4: {40, 41}}}
+	devices := NewTCPRTUGateway()
+	devices.Route(newTestRTUBus(t, slaves), 1, 4)
+	deviceAddr := startTestGateway(t, devices)
+
+	// A device that accepts connections but never answers
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	// An address refusing connections
+	refused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusedAddr := refused.Addr().String()
+	refused.Close()
+
+	gatewayEnd, masterEnd := net.Pipe()
+	gateway := NewRTUTCPGateway(gatewayEnd)
+	for slave, route := range map[uint8]RTUTCPRoute{
+		1: {Address: deviceAddr},
+		2: {Address: deviceAddr, Unit: 4},
+		5: {Address: silent.Addr().String(), Timeout: 100 * time.Millisecond},
+		6: {Address: refusedAddr},
+	} {
+		if err := gateway.Route(slave, route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gateway.Route(0, RTUTCPRoute{Address: deviceAddr}); err == nil {
+		t.Errorf("expected an error routing the broadcast address")
+	}
+	master := startTestRTUTCPGateway(t, gateway, masterEnd)
+
+	response, err := master.transact(t, 1, []byte{FuncCodeReadHoldingRegisters, 0, 1, 0, 2}, time.Second)
+	if err != nil || len(response) != 6 || binary.BigEndian.Uint16(response[2:]) != 20 || binary.BigEndian.Uint16(response[4:]) != 30 {
+		t.Errorf("slave 1 read = % X, %v", response, err)
+	}
+	if _, err := master.transact(t, 1, []byte{FuncCodeWriteMultipleRegisters, 0, 0, 0, 1, 2, 0x12, 0x34}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if v := slaves.registers[1][0]; v != 0x1234 {
+		t.Errorf("register 0 after write = %#x", v)
+	}
+
+	// Slave 2 is unit 4 on the TCP side
+	response, err = master.transact(t, 2, []byte{FuncCodeReadHoldingRegisters, 0, 1, 0, 1}, time.Second)
+	if err != nil || len(response) != 4 || binary.BigEndian.Uint16(response[2:]) != 41 {
+		t.Errorf("slave 2 read = % X, %v", response, err)
+	}
+
+	// Device exceptions are passed through
+	response, err = master.transact(t, 1, []byte{FuncCodeReadHoldingRegisters, 0, 2, 0, 5}, time.Second)
+	if err != nil || len(response) != 2 || response[0] != 0x83 || response[1] != ExceptionCodeIllegalDataAddress {
+		t.Errorf("expected illegal data address exception, got % X, %v", response, err)
+	}
+
+	// Unrouted slaves are left to answer themselves
+	if response, err := master.transact(t, 9, []byte{FuncCodeReadHoldingRegisters, 0, 0, 0, 1}, 200*time.Millisecond); err == nil {
+		t.Errorf("unrouted slave answered % X", response)
+	}
+
+	var errs []error
+	gateway.OnError = func(err error) { errs = append(errs, err) }
+	response, err = master.transact(t, 5, []byte{FuncCodeReadHoldingRegisters, 0, 0, 0, 1}, time.Second)
+	if err != nil || len(response) != 2 || response[1] != ExceptionCodeGatewayTargetDeviceFailedToRespond {
+		t.Errorf("expected gateway target failed to respond, got % X, %v", response, err)
+	}
+	response, err = master.transact(t, 6, []byte{FuncCodeReadHoldingRegisters, 0, 0, 0, 1}, time.Second)
+	if err != nil || len(response) != 2 || response[1] != ExceptionCodeGatewayPathUnavailable {
+		t.Errorf("expected gateway path unavailable, got % X, %v", response, err)
+	}
+	if len(errs) != 2 {
+		t.Errorf("reported errors = %v", errs)
+	}
+
+	// A corrupted frame is reported and the gateway resynchronizes
+	masterEnd.Write([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00})
+	time.Sleep(100 * time.Millisecond)
+	response, err = master.transact(t, 1, []byte{FuncCodeReadHoldingRegisters, 0, 1, 0, 1}, time.Second)
+	if err != nil || len(response) != 4 || binary.BigEndian.Uint16(response[2:]) != 20 {
+		t.Errorf("read after a corrupted frame = % X, %v", response, err)
+	}
+	if len(errs) != 3 {
+		t.Errorf("reported errors = %v", errs)
+	}
+}
+
+func TestRTUTCPGatewayBroadcast(t *testing.T) {
+	// A device that records the requests it receives and never answers
+	device, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+	received := make(chan []byte, 4)
+	go func() {
+		for {
+			conn, err := device.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, tcpMaxLength)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					received <- append([]byte(nil), buf[:n]...)
+				}
+			}()
+		}
+	}()
+
+	gatewayEnd, masterEnd := net.Pipe()
+	gateway := NewRTUTCPGateway(gatewayEnd)
+	if err := gateway.Route(2, RTUTCPRoute{Address: device.Addr().String(), Unit: 7}); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var errs []error
+	gateway.OnError = func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+	master := startTestRTUTCPGateway(t, gateway, masterEnd)
+
+	// The broadcast keeps unit 0 rather than the unit of the route, so the device
+	// does not answer it
+	frame, _ := master.packager.Pack(0, []byte{FuncCodeWriteSingleRegister, 0, 1, 0, 5})
+	masterEnd.Write(frame)
+	select {
+	case request := <-received:
+		if len(request) != 12 || request[6] != 0 {
+			t.Errorf("broadcast request % X, expected unit 0", request)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("broadcast not forwarded")
+	}
+
+	// After an invalid frame the gateway skips the rest of the noise up to the next
+	// silence, so the noise is reported once
+	masterEnd.Write([]byte{0x02, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00})
+	for i := 0; i < 12; i++ {
+		masterEnd.Write([]byte{0x55, 0xAA})
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	if len(errs) != 1 {
+		t.Errorf("reported errors = %v, expected one invalid frame", errs)
+	}
+	mu.Unlock()
+}
+
+func TestTCPTransactorTimeout(t *testing.T) {
+	// A device that receives requests but never answers
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	received := make(chan []byte, 4)
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, tcpMaxLength)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					received <- append([]byte(nil), buf[:n]...)
+				}
+			}()
+		}
+	}()
+
+	handler := NewTCPClientHandler(silent.Addr().String())
+	handler.Timeout = 300 * time.Millisecond
+	device := NewTCPTransactor(handler)
+	defer device.Close()
+	read := []byte{FuncCodeReadHoldingRegisters, 0, 0, 0, 1}
+
+	// A route timeout applies to its request only
+	start := time.Now()
+	if _, err := device.transact(1, read, 50*time.Millisecond, true); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("request with a 50ms timeout took %v", elapsed)
+	}
+	if handler.Timeout != 300*time.Millisecond {
+		t.Errorf("handler timeout changed to %v", handler.Timeout)
+	}
+	start = time.Now()
+	if _, err := device.Transact(1, read); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("request with the handler timeout took %v", elapsed)
+	}
+	for len(received) > 0 {
+		<-received
+	}
+
+	// Broadcasts are sent without waiting for a response
+	start = time.Now()
+	if response, err := device.transact(0, []byte{FuncCodeWriteSingleRegister, 0, 0, 0, 1}, time.Second, false); err != nil || response != nil {
+		t.Errorf("broadcast = % X, %v", response, err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("broadcast took %v", elapsed)
+	}
+	select {
+	case request := <-received:
+		if len(request) != 12 || request[6] != 0 || request[7] != FuncCodeWriteSingleRegister {
+			t.Errorf("broadcast request % X", request)
+		}
+	case <-time.After(time.Second):
+		t.Error("broadcast not received")
+	}
+}
+
+func TestRTURequestLength(t *testing.T) {
+	for _, tc := range []struct {
+		frame  []byte
+		length int
+	}{
+		{[]byte{1}, 0},
+		{[]byte{1, 3}, 8},
+		{[]byte{1, 0x10, 0, 0, 0, 2}, 0},
+		{[]byte{1, 0x10, 0, 0, 0, 2, 4}, 13},
+		{[]byte{1, 0x17, 0, 0, 0, 1, 0, 0, 0, 1, 2}, 15},
+		{[]byte{1, 0x16}, 10},
+		{[]byte{1, 0x2B}, 7},
+		{[]byte{1, 0x41}, -1},
+	} {
+		if got := rtuRequestLength(tc.frame); got != tc.length {
+			t.Errorf("rtuRequestLength(% X) = %d, expected %d", tc.frame, got, tc.length)
+		}
+	}
+}
@@ -37,7 +37,8 @@ func (p *RTUPackager) Unpack(frame []byte) (slaveID uint8, pdu []byte, err error
 		return
 	}
 
-	receivedCRC := uint16(frame[len(frame)-1])<<8 | uint16(frame[len(frame)-2])
+	// CRC16 returns the checksum byte-swapped, low byte first as sent on the wire
+	receivedCRC := uint16(frame[len(frame)-2])<<8 | uint16(frame[len(frame)-1])
 	calculatedCRC := CRC16(frame[:len(frame)-2])
 
 	if receivedCRC != calculatedCRC {
@@ -0,0 +1,23 @@
+package modbus
+
+import (
+	"testing"
+)
+
+// TestRTUPackagerRoundTrip checks that Unpack accepts what Pack produces: the CRC
+// is sent low byte first, 84 0A for the request below
+func TestRTUPackagerRoundTrip(t *testing.T) {
+	packager := NewRTUPackager()
+	frame, _ := packager.Pack(1, []byte{FuncCodeReadHoldingRegisters, 0, 0, 0, 1})
+	if expected := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A}; string(frame) != string(expected) {
+		t.Fatalf("frame = % X, expected % X", frame, expected)
+	}
+	slave, pdu, err := packager.Unpack(frame)
+	if err != nil || slave != 1 || !equal(pdu, []byte{FuncCodeReadHoldingRegisters, 0, 0, 0, 1}) {
+		t.Errorf("Unpack = %d, % X, %v", slave, pdu, err)
+	}
+	frame[6] ^= 0xFF
+	if _, _, err := packager.Unpack(frame); err == nil {
+		t.Errorf("expected CRC error")
+	}
+}
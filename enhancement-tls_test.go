@@ -0,0 +1,230 @@
+package modbus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue creates a server certificate for 127.0.0.1 when role is empty, and a client
+// certificate with the role otherwise
+func (ca *testCA) issue(t *testing.T, serial int64, role string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: role},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if role == "" {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		ext, err := RoleExtension(role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{ext}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (ca *testCA) clientConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{cert}}
+}
+
+func TestTLSServer(t *testing.T) {
+	ca := newTestCA(t)
+	device := &testTransactor{registers: []uint16{1, 2, 3, 4}}
+	server := NewTLSServer(device, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, 2, "")},
+		ClientCAs:    ca.pool,
+	})
+	var mu sync.Mutex
+	var roles []string
+	rules := AllowRules(
+		AccessRule{Role: "operator", First: 0, Last: 3},
+		AccessRule{Role: "viewer", Functions: []uint8{FuncCodeReadHoldingRegisters}, First: 0, Last: 1},
+	)
+	server.Authorize = func(req SecurityRequest) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		roles = append(roles, req.Role)
+		return rules(req)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ln) }()
+	defer func() {
+		server.Close()
+		if err := <-served; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+	}()
+	addr := ln.Addr().String()
+
+	operator := NewTLSClientHandler(addr, ca.clientConfig(ca.issue(t, 3, "operator")))
+	operator.Timeout = 2 * time.Second
+	operator.SetSlaverId(1)
+	defer operator.Close()
+	client := NewClient(operator)
+	if _, err := client.WriteSingleRegister(3, 40); err != nil {
+		t.Fatalf("operator write: %v", err)
+	}
+	if results, err := client.ReadHoldingRegisters(2, 2); err != nil || binary.BigEndian.Uint16(results[2:]) != 40 {
+		t.Errorf("operator read = % X, %v", results, err)
+	}
+	var mbErr *ModbusError
+	if _, err := client.ReadHoldingRegisters(3, 2); !errors.As(err, &mbErr) || mbErr.ExceptionCode != ExceptionCodeIllegalFunction {
+		t.Errorf("operator read beyond the rule: expected illegal function, got %v", err)
+	}
+
+	conn, err := tls.Dial("tcp", addr, ca.clientConfig(ca.issue(t, 4, "viewer")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	viewer, err := NewModbusTLSHandler(conn, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values, err := viewer.ReadHoldingRegisters(1, 0, 2); err != nil || len(values) != 2 || values[1] != 2 {
+		t.Errorf("viewer read = %v, %v", values, err)
+	}
+	if err := viewer.WriteSingleRegister(1, 0, 5); err == nil {
+		t.Errorf("viewer write allowed")
+	}
+	device.mu.Lock()
+	if device.registers[0] != 1 {
+		t.Errorf("register 0 = %d after a denied write", device.registers[0])
+	}
+	device.mu.Unlock()
+	mu.Lock()
+	if len(roles) != 5 || roles[0] != "operator" || roles[4] != "viewer" {
+		t.Errorf("authorized roles = %v", roles)
+	}
+	mu.Unlock()
+
+	// Clients without a certificate fail the handshake
+	anonymous := NewTLSClientHandler(addr, &tls.Config{RootCAs: ca.pool})
+	anonymous.Timeout = 2 * time.Second
+	defer anonymous.Close()
+	if _, err := NewClient(anonymous).ReadHoldingRegisters(0, 1); err == nil {
+		t.Errorf("read without a client certificate succeeded")
+	}
+}
+
+func TestCertificateRole(t *testing.T) {
+	ca := newTestCA(t)
+	cert, _ := x509.ParseCertificate(ca.issue(t, 2, "engineer").Certificate[0])
+	if role, err := CertificateRole(cert); err != nil || role != "engineer" {
+		t.Errorf("role = %q, %v", role, err)
+	}
+	if role, err := CertificateRole(ca.cert); err != nil || role != "" {
+		t.Errorf("role without extension = %q, %v", role, err)
+	}
+
+	allow := AllowRules(AccessRule{Functions: []uint8{FuncCodeReadCoils}, First: 10, Last: 19})
+	for _, tc := range []struct {
+		req     SecurityRequest
+		allowed bool
+	}{
+		{SecurityRequest{FunctionCode: FuncCodeReadCoils, Address: 10, Quantity: 10}, true},
+		{SecurityRequest{FunctionCode: FuncCodeReadCoils, Address: 10, Quantity: 11}, false},
+		{SecurityRequest{FunctionCode: FuncCodeReadCoils, Address: 9, Quantity: 1}, false},
+		{SecurityRequest{FunctionCode: FuncCodeWriteSingleCoil, Address: 10, Quantity: 1}, false},
+	} {
+		if got := allow(tc.req); got != tc.allowed {
+			t.Errorf("AllowRules(%+v) = %v", tc.req, got)
+		}
+	}
+	if ranges, ok := securityRanges([]byte{FuncCodeReadWriteMultipleRegisters, 0, 1, 0, 2, 0, 5, 0, 1, 2, 0, 0}); !ok || len(ranges) != 2 || ranges[1] != [2]uint16{5, 1} {
+		t.Errorf("FC 23 ranges = %v, %v", ranges, ok)
+	}
+}
+
+func TestTLSServerTruncatedRequests(t *testing.T) {
+	device := &testTransactor{registers: make([]uint16, 200)}
+	server := NewTLSServer(device, &tls.Config{})
+	authorized := 0
+	rules := AllowRules(
+		AccessRule{Role: "viewer", Functions: []uint8{FuncCodeReadHoldingRegisters}, First: 0, Last: 9},
+		AccessRule{Role: "limited", First: 0, Last: 9},
+	)
+	server.Authorize = func(req SecurityRequest) bool {
+		authorized++
+		return rules(req)
+	}
+	for _, tc := range []struct {
+		role string
+		pdu  []byte
+	}{
+		{"viewer", []byte{FuncCodeWriteSingleRegister, 0, 0, 0}},
+		{"viewer", []byte{FuncCodeWriteMultipleRegisters, 0, 0, 0, 1}},
+		// The address 100 is outside the rule, but there is no quantity to check it with
+		{"limited", []byte{FuncCodeWriteSingleRegister, 0, 100}},
+		{"limited", []byte{FuncCodeWriteMultipleRegisters, 0, 100, 0, 1, 2, 0}},
+		{"limited", []byte{FuncCodeReadHoldingRegisters, 0, 100}},
+	} {
+		response := server.handle(SecurityRequest{Role: tc.role, Unit: 1}, tc.pdu)
+		if len(response) != 2 || response[0] != tc.pdu[0]|0x80 || response[1] != ExceptionCodeIllegalDataValue {
+			t.Errorf("%s % X: response % X, expected illegal data value", tc.role, tc.pdu, response)
+		}
+	}
+	if authorized != 0 || device.count() != 0 {
+		t.Errorf("truncated requests authorized %d times, forwarded %d times", authorized, device.count())
+	}
+}
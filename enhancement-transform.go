@@ -0,0 +1,263 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Transform types supported by ValueTransform.Type
+const (
+	TransformOffset      = "offset"      // value + Offset
+	TransformLinear      = "linear"      // two-point scaling from [RawMin, RawMax] to [EngMin, EngMax]
+	TransformClamp       = "clamp"       // limit the value to [Min, Max]
+	TransformLookup      = "lookup"      // exact mapping through Points
+	TransformPiecewise   = "piecewise"   // piecewise-linear interpolation through Points
+	TransformScaleFactor = "scaleFactor" // value * 10^ScaleFactor (SunSpec style _SF)
+)
+
+// ValueTransform is one step of the transformation pipeline applied to a decoded value.
+// Steps run in order on DecodeValue, after Weight, and in reverse order on EncodeValue.
+type ValueTransform struct {
+	Type           string       `json:"type"`                     // One of the Transform* constants
+	Offset         float64      `json:"offset,omitempty"`         // Offset added by "offset"
+	RawMin         float64      `json:"rawMin,omitempty"`         // Raw range lower bound for "linear"
+	RawMax         float64      `json:"rawMax,omitempty"`         // Raw range upper bound for "linear"
+	EngMin         float64      `json:"engMin,omitempty"`         // Engineering range lower bound for "linear"
+	EngMax         float64      `json:"engMax,omitempty"`         // Engineering range upper bound for "linear"
+	Min            *float64     `json:"min,omitempty"`            // Lower bound for "clamp", unbounded if nil
+	Max            *float64     `json:"max,omitempty"`            // Upper bound for "clamp", unbounded if nil
+	Points         [][2]float64 `json:"points,omitempty"`         // [raw, engineering] pairs for "lookup" and "piecewise"
+	ScaleFactor    int16        `json:"scaleFactor,omitempty"`    // Power of ten for "scaleFactor"
+	ScaleFactorTag string       `json:"scaleFactorTag,omitempty"` // Tag of the register holding ScaleFactor, resolved when polled
+}
+
+// Apply runs the transform on a value read from the device.
+func (t ValueTransform) Apply(v float64) (float64, error) {
+	switch t.Type {
+	case TransformOffset:
+		return v + t.Offset, nil
+	case TransformLinear:
+		if t.RawMax == t.RawMin {
+			return 0, fmt.Errorf("linear transform has an empty raw range")
+		}
+		return t.EngMin + (v-t.RawMin)*(t.EngMax-t.EngMin)/(t.RawMax-t.RawMin), nil
+	case TransformClamp:
+		return t.clamp(v), nil
+	case TransformLookup:
+		for _, p := range t.Points {
+			if FuzzyEqual(p[0], v) {
+				return p[1], nil
+			}
+		}
+		return 0, fmt.Errorf("lookup transform has no entry for %v", v)
+	case TransformPiecewise:
+		return interpolate(t.Points, v, 0, 1)
+	case TransformScaleFactor:
+		return v * math.Pow10(int(t.ScaleFactor)), nil
+	default:
+		return 0, fmt.Errorf("unsupported transform type: %s", t.Type)
+	}
+}
+
+// Inverse runs the transform backwards on a value about to be written to the device.
+// Clamping limits the written value instead of being inverted.
+func (t ValueTransform) Inverse(v float64) (float64, error) {
+	switch t.Type {
+	case TransformOffset:
+		return v - t.Offset, nil
+	case TransformLinear:
+		if t.EngMax == t.EngMin {
+			return 0, fmt.Errorf("linear transform has an empty engineering range")
+		}
+		return t.RawMin + (v-t.EngMin)*(t.RawMax-t.RawMin)/(t.EngMax-t.EngMin), nil
+	case TransformClamp:
+		return t.clamp(v), nil
+	case TransformLookup:
+		for _, p := range t.Points {
+			if FuzzyEqual(p[1], v) {
+				return p[0], nil
+			}
+		}
+		return 0, fmt.Errorf("lookup transform has no entry for %v", v)
+	case TransformPiecewise:
+		return interpolate(t.Points, v, 1, 0)
+	case TransformScaleFactor:
+		return v / math.Pow10(int(t.ScaleFactor)), nil
+	default:
+		return 0, fmt.Errorf("unsupported transform type: %s", t.Type)
+	}
+}
+
+func (t ValueTransform) clamp(v float64) float64 {
+	if t.Min != nil && v < *t.Min {
+		return *t.Min
+	}
+	if t.Max != nil && v > *t.Max {
+		return *t.Max
+	}
+	return v
+}
+
+// interpolate maps v through the curve formed by points, reading the x coordinate from
+// column from and the y coordinate from column to. Values outside the curve are clamped
+// to its end points. The x column must be strictly monotonic.
+func interpolate(points [][2]float64, v float64, from, to int) (float64, error) {
+	if len(points) < 2 {
+		return 0, fmt.Errorf("piecewise transform needs at least 2 points, have %d", len(points))
+	}
+	sorted := make([][2]float64, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][from] < sorted[j][from] })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i][from] == sorted[i-1][from] {
+			return 0, fmt.Errorf("piecewise transform is not invertible: duplicate point %v", sorted[i][from])
+		}
+	}
+	if from == 1 {
+		// The inverse is only defined when the engineering values follow the raw order
+		for i := 1; i < len(sorted); i++ {
+			if (sorted[i][0] > sorted[i-1][0]) != (sorted[1][0] > sorted[0][0]) {
+				return 0, fmt.Errorf("piecewise transform is not invertible: curve is not monotonic")
+			}
+		}
+	}
+
+	if v <= sorted[0][from] {
+		return sorted[0][to], nil
+	}
+	last := len(sorted) - 1
+	if v >= sorted[last][from] {
+		return sorted[last][to], nil
+	}
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i][from] >= v })
+	x0, y0 := sorted[i-1][from], sorted[i-1][to]
+	x1, y1 := sorted[i][from], sorted[i][to]
+	return y0 + (v-x0)*(y1-y0)/(x1-x0), nil
+}
+
+// applyTransforms runs the register's transformation pipeline on a decoded value.
+func (r DeviceRegister) applyTransforms(v float64) (float64, error) {
+	for i, t := range r.Transforms {
+		var err error
+		if v, err = t.Apply(v); err != nil {
+			return 0, fmt.Errorf("transform %d (%s): %w", i, t.Type, err)
+		}
+	}
+	return v, nil
+}
+
+// invertTransforms runs the register's transformation pipeline backwards.
+func (r DeviceRegister) invertTransforms(v float64) (float64, error) {
+	for i := len(r.Transforms) - 1; i >= 0; i-- {
+		var err error
+		if v, err = r.Transforms[i].Inverse(v); err != nil {
+			return 0, fmt.Errorf("transform %d (%s): %w", i, r.Transforms[i].Type, err)
+		}
+	}
+	return v, nil
+}
+
+// scaleFactorTags returns the set of tags referenced by scaleFactor transforms.
+func scaleFactorTags(groups [][]DeviceRegister) map[string]bool {
+	tags := make(map[string]bool)
+	for _, group := range groups {
+		for _, reg := range group {
+			for _, t := range reg.Transforms {
+				if t.Type == TransformScaleFactor && t.ScaleFactorTag != "" {
+					tags[t.ScaleFactorTag] = true
+				}
+			}
+		}
+	}
+	return tags
+}
+
+// maxScaleFactor bounds scale factor values, as in SunSpec. Values outside the range,
+// such as the SunSpec "not implemented" value -32768, are rejected.
+const maxScaleFactor = 10
+
+// resolveScaleFactors records the raw values of registers used as scale factors into
+// cache and stamps the cached values into the transforms of the registers that
+// reference them. Transform slices are copied before being stamped so slices shared
+// with the caller's register definitions are left untouched. A scale factor out of
+// range is dropped from cache, and registers whose scale factor is not in cache are
+// marked bad.
+func resolveScaleFactors(groups [][]DeviceRegister, cache map[string]int16) {
+	tags := scaleFactorTags(groups)
+	if len(tags) == 0 {
+		return
+	}
+	for _, group := range groups {
+		for _, reg := range group {
+			if !tags[reg.Tag] || !isValidStatus(reg.Status) {
+				continue
+			}
+			decoded, err := reg.DecodeValue()
+			if err != nil {
+				continue
+			}
+			sf, err := toFloat64(decoded.AsType)
+			if err != nil {
+				continue
+			}
+			if sf < -maxScaleFactor || sf > maxScaleFactor || sf != math.Trunc(sf) {
+				delete(cache, reg.Tag)
+				continue
+			}
+			cache[reg.Tag] = int16(sf)
+		}
+	}
+	for _, group := range groups {
+		for i := range group {
+			group[i] = stampScaleFactors(group[i], cache)
+		}
+	}
+}
+
+// stampScaleFactors returns reg with ScaleFactor filled in from cache for every
+// scaleFactor transform that names a ScaleFactorTag. A good register whose scale
+// factor is not in cache is marked bad, since its value cannot be scaled.
+func stampScaleFactors(reg DeviceRegister, cache map[string]int16) DeviceRegister {
+	copied := false
+	for i, t := range reg.Transforms {
+		if t.Type != TransformScaleFactor || t.ScaleFactorTag == "" {
+			continue
+		}
+		sf, ok := cache[t.ScaleFactorTag]
+		if !ok {
+			if quality, _ := reg.quality(); quality != QualityBad {
+				reg.setBad(ReasonScaleFactor, 0, "scale factor "+t.ScaleFactorTag+" not available", reg.ReceiveTime)
+			}
+			continue
+		}
+		if !copied {
+			reg.Transforms = append([]ValueTransform(nil), reg.Transforms...)
+			copied = true
+		}
+		reg.Transforms[i].ScaleFactor = sf
+	}
+	return reg
+}
+
+// ErrScaleFactorUnavailable is returned by writes to a tag whose scale factor register has
+// not been read with a valid exponent yet
+var ErrScaleFactorUnavailable = errors.New("scale factor not available")
+
+// missingScaleFactor returns the first ScaleFactorTag of reg that is not in cache
+func missingScaleFactor(reg DeviceRegister, cache map[string]int16) (string, bool) {
+	for _, t := range reg.Transforms {
+		if t.Type == TransformScaleFactor && t.ScaleFactorTag != "" {
+			if _, ok := cache[t.ScaleFactorTag]; !ok {
+				return t.ScaleFactorTag, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isValidStatus reports whether a register status denotes a successful read
+func isValidStatus(status string) bool {
+	return len(status) >= 5 && status[:5] == "VALID"
+}
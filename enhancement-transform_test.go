@@ -0,0 +1,208 @@
+package modbus
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValueTransformApplyInverse(t *testing.T) {
+	lower, upper := 0.0, 100.0
+	tests := []struct {
+		name      string
+		transform ValueTransform
+		raw       float64
+		eng       float64
+	}{
+		{"offset", ValueTransform{Type: TransformOffset, Offset: -40}, 65, 25},
+		{"linear 4-20mA", ValueTransform{Type: TransformLinear, RawMin: 4000, RawMax: 20000, EngMin: 0, EngMax: 10}, 12000, 5},
+		{"clamp", ValueTransform{Type: TransformClamp, Min: &lower, Max: &upper}, 50, 50},
+		{"lookup", ValueTransform{Type: TransformLookup, Points: [][2]float64{{0, 10}, {1, 20}}}, 1, 20},
+		{"piecewise", ValueTransform{Type: TransformPiecewise, Points: [][2]float64{{0, 0}, {100, 10}, {200, 50}}}, 150, 30},
+		{"scale factor", ValueTransform{Type: TransformScaleFactor, ScaleFactor: -2}, 12345, 123.45},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			eng, err := tc.transform.Apply(tc.raw)
+			if err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			if !FuzzyEqual(eng, tc.eng) {
+				t.Errorf("Apply(%v) = %v, expected %v", tc.raw, eng, tc.eng)
+			}
+			raw, err := tc.transform.Inverse(tc.eng)
+			if err != nil {
+				t.Fatalf("Inverse failed: %v", err)
+			}
+			if !FuzzyEqual(raw, tc.raw) {
+				t.Errorf("Inverse(%v) = %v, expected %v", tc.eng, raw, tc.raw)
+			}
+		})
+	}
+
+	clamp := ValueTransform{Type: TransformClamp, Min: &lower, Max: &upper}
+	if v, _ := clamp.Apply(120); v != 100 {
+		t.Errorf("clamp.Apply(120) = %v, expected 100", v)
+	}
+	curve := ValueTransform{Type: TransformPiecewise, Points: [][2]float64{{0, 0}, {100, 10}}}
+	if v, _ := curve.Apply(500); v != 10 {
+		t.Errorf("piecewise.Apply(500) = %v, expected end point 10", v)
+	}
+	notMonotonic := ValueTransform{Type: TransformPiecewise, Points: [][2]float64{{0, 0}, {100, 10}, {200, 5}}}
+	if _, err := notMonotonic.Inverse(7); err == nil {
+		t.Errorf("expected error inverting a non-monotonic curve")
+	}
+	lookup := ValueTransform{Type: TransformLookup, Points: [][2]float64{{0, 10}}}
+	if _, err := lookup.Apply(3); err == nil {
+		t.Errorf("expected error for a missing lookup entry")
+	}
+}
+
+func TestDeviceRegisterTransformPipeline(t *testing.T) {
+	var register DeviceRegister
+	config := `{
+		"tag": "temperature", "dataType": "uint16", "readQuantity": 1, "weight": 0.1,
+		"transforms": [
+			{"type": "offset", "offset": -50},
+			{"type": "clamp", "min": -40, "max": 125}
+		]
+	}`
+	if err := json.Unmarshal([]byte(config), &register); err != nil {
+		t.Fatal(err)
+	}
+
+	register.Value = []byte{0x03, 0x20} // 800 * 0.1 - 50 = 30
+	decoded, err := register.DecodeValue()
+	if err != nil {
+		t.Fatalf("DecodeValue failed: %v", err)
+	}
+	if !FuzzyEqual(decoded.Float64, 30) {
+		t.Errorf("DecodeValue returned %v, expected 30", decoded.Float64)
+	}
+	if decoded.AsType != uint16(800) {
+		t.Errorf("AsType = %v, expected the untransformed raw value 800", decoded.AsType)
+	}
+
+	encoded, err := register.EncodeValue(30)
+	if err != nil {
+		t.Fatalf("EncodeValue failed: %v", err)
+	}
+	if encoded[0] != 0x03 || encoded[1] != 0x20 {
+		t.Errorf("EncodeValue returned % X, expected 03 20", encoded)
+	}
+}
+
+func TestRegisterManagerScaleFactor(t *testing.T) {
+	client := newMemoryClient()
+	client.setRegisters(1, 40083, 12345, 0xFFFE) // W = 12345, W_SF = -2
+	manager := NewRegisterManager(client, 10)
+	registers := []DeviceRegister{
+		{
+			Tag: "W", SlaverId: 1, Function: 3, ReadAddress: 40083, ReadQuantity: 1, DataType: "int16", Weight: 1,
+			Transforms: []ValueTransform{{Type: TransformScaleFactor, ScaleFactorTag: "W_SF"}},
+		},
+		{Tag: "W_SF", SlaverId: 1, Function: 3, ReadAddress: 40084, ReadQuantity: 1, DataType: "int16"},
+	}
+	if err := manager.LoadRegisters(registers); err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(chan float64, 1)
+	manager.SetOnReadCallback(func(registers []DeviceRegister) {
+		for _, register := range registers {
+			if register.Tag == "W" {
+				decoded, err := register.DecodeValue()
+				if err != nil {
+					t.Errorf("DecodeValue failed: %v", err)
+				}
+				values <- decoded.Float64
+			}
+		}
+	})
+	manager.Start()
+	defer manager.Stop()
+	if errs := manager.ReadGroupedData(); len(errs) > 0 {
+		t.Fatalf("ReadGroupedData failed: %v", errs)
+	}
+
+	select {
+	case v := <-values:
+		if !FuzzyEqual(v, 123.45) {
+			t.Errorf("W = %v, expected 123.45", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for read callback")
+	}
+	if registers[0].Transforms[0].ScaleFactor != 0 {
+		t.Errorf("caller's register definition was modified")
+	}
+
+	if err := manager.WriteTag("W", 50.5); err != nil {
+		t.Fatalf("WriteTag failed: %v", err)
+	}
+	if got := client.register(1, 40083); got != 5050 {
+		t.Errorf("written raw value = %d, expected 5050", got)
+	}
+}
+
+func TestResolveScaleFactorsQuality(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	group := func(sf []byte) [][]DeviceRegister {
+		registers := []DeviceRegister{
+			{
+				Tag: "W", DataType: "int16", Weight: 1, Value: []byte{0x30, 0x39},
+				Transforms: []ValueTransform{{Type: TransformScaleFactor, ScaleFactorTag: "W_SF"}},
+			},
+			{Tag: "W_SF", DataType: "int16", Value: sf},
+		}
+		for i := range registers {
+			registers[i].setGood(at, at)
+		}
+		if sf == nil {
+			registers = registers[:1]
+		}
+		return [][]DeviceRegister{registers}
+	}
+
+	// Without a scale factor the value cannot be scaled
+	cache := make(map[string]int16)
+	groups := group(nil)
+	resolveScaleFactors(groups, cache)
+	if w := groups[0][0]; w.Quality != QualityBad || w.QualityReason != ReasonScaleFactor {
+		t.Errorf("W without a scale factor: quality %v, reason %q", w.Quality, w.QualityReason)
+	}
+
+	groups = group([]byte{0xFF, 0xFE}) // -2
+	resolveScaleFactors(groups, cache)
+	if w := groups[0][0]; w.Quality != QualityGood || w.Transforms[0].ScaleFactor != -2 {
+		t.Errorf("W with scale factor -2: quality %v, scale factor %d", w.Quality, w.Transforms[0].ScaleFactor)
+	}
+
+	// The SunSpec "not implemented" value is out of range and replaces the last good value
+	groups = group([]byte{0x80, 0x00})
+	resolveScaleFactors(groups, cache)
+	if w := groups[0][0]; w.Quality != QualityBad || w.QualityReason != ReasonScaleFactor {
+		t.Errorf("W with scale factor -32768: quality %v, reason %q", w.Quality, w.QualityReason)
+	}
+	if _, ok := cache["W_SF"]; ok {
+		t.Errorf("out of range scale factor was cached")
+	}
+}
+
+func TestRegisterManagerWriteBeforeScaleFactor(t *testing.T) {
+	manager := NewRegisterManager(newMemoryClient(), 10)
+	if err := manager.LoadRegisters([]DeviceRegister{
+		{
+			Tag: "W", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "int16", Weight: 1,
+			Transforms: []ValueTransform{{Type: TransformScaleFactor, ScaleFactorTag: "W_SF"}},
+		},
+		{Tag: "W_SF", SlaverId: 1, Function: 3, ReadAddress: 1, ReadQuantity: 1, DataType: "int16"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.WriteTag("W", 50.5); !errors.Is(err, ErrScaleFactorUnavailable) {
+		t.Errorf("WriteTag before the scale factor was read = %v, expected ErrScaleFactorUnavailable", err)
+	}
+}
@@ -0,0 +1,269 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// The typed accessors read and write holding registers of a Client as numbers,
+// strings and bools; the ReadInput variants read input registers. order uses the DataOrder letters of DeviceRegister and must
+// have one letter per byte of the value, e.g. "CDAB" for word-swapped 32-bit values
+// or "GHEFCDAB" for 64-bit values with reversed words; "" means big-endian. For a
+// ModbusApi such as ModbusHandler, wrap it with NewClientFromApi.
+
+// checkOrder returns the order for size-byte values, defaulting to big-endian
+func checkOrder(order string, size int) (string, error) {
+	if order == "" {
+		return "ABCDEFGH"[:size], nil
+	}
+	if len(order) != size || !isValidDataOrder(order) {
+		return "", fmt.Errorf("modbus: invalid byte order %q for %d-byte values", order, size)
+	}
+	return order, nil
+}
+
+// readRegisters reads quantity holding or input registers, selected by function
+func readRegisters(client Client, function uint8, address, quantity uint16) ([]byte, error) {
+	if function == FuncCodeReadInputRegisters {
+		return client.ReadInputRegisters(address, quantity)
+	}
+	return client.ReadHoldingRegisters(address, quantity)
+}
+
+// readValues reads count values of size bytes each with function and returns their
+// bytes in big-endian order
+func readValues(client Client, function uint8, address, count uint16, size int, order string) ([][]byte, error) {
+	order, err := checkOrder(order, size)
+	if err != nil {
+		return nil, err
+	}
+	quantity := int(count) * size / 2
+	if quantity < 1 || quantity > 125 {
+		return nil, fmt.Errorf("modbus: %d values of %d bytes need %d registers, must be between 1 and 125", count, size, quantity)
+	}
+	data, err := readRegisters(client, function, address, uint16(quantity))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < int(count)*size {
+		return nil, fmt.Errorf("modbus: short response: %d bytes for %d values of %d bytes", len(data), count, size)
+	}
+	values := make([][]byte, count)
+	for i := range values {
+		values[i] = reorderBytes(data[i*size:(i+1)*size], order)
+	}
+	return values, nil
+}
+
+// writeValues writes big-endian values of size bytes each in the given order
+func writeValues(client Client, address uint16, values [][]byte, size int, order string) error {
+	order, err := checkOrder(order, size)
+	if err != nil {
+		return err
+	}
+	quantity := len(values) * size / 2
+	if quantity < 1 || quantity > 123 {
+		return fmt.Errorf("modbus: %d values of %d bytes need %d registers, must be between 1 and 123", len(values), size, quantity)
+	}
+	data := make([]byte, 0, len(values)*size)
+	for _, value := range values {
+		data = append(data, restoreByteOrder(value, order)...)
+	}
+	_, err = client.WriteMultipleRegisters(address, uint16(quantity), data)
+	return err
+}
+
+// readTyped reads count values with function and decodes each with decode
+func readTyped[T any](client Client, function uint8, address, count uint16, size int, order string, decode func([]byte) T) ([]T, error) {
+	raw, err := readValues(client, function, address, count, size, order)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]T, len(raw))
+	for i, b := range raw {
+		values[i] = decode(b)
+	}
+	return values, nil
+}
+
+// writeTyped encodes each value with encode and writes them
+func writeTyped[T any](client Client, address uint16, values []T, size int, order string, encode func([]byte, T)) error {
+	raw := make([][]byte, len(values))
+	for i, v := range values {
+		raw[i] = make([]byte, size)
+		encode(raw[i], v)
+	}
+	return writeValues(client, address, raw, size, order)
+}
+
+// ReadInt16s reads count signed 16-bit values; order is "AB" or "BA"
+func ReadInt16s(client Client, address, count uint16, order string) ([]int16, error) {
+	return readTyped(client, FuncCodeReadHoldingRegisters, address, count, 2, order, func(b []byte) int16 { return int16(binary.BigEndian.Uint16(b)) })
+}
+
+// ReadInputInt16s reads count signed 16-bit values from input registers; order is "AB" or "BA"
+func ReadInputInt16s(client Client, address, count uint16, order string) ([]int16, error) {
+	return readTyped(client, FuncCodeReadInputRegisters, address, count, 2, order, func(b []byte) int16 { return int16(binary.BigEndian.Uint16(b)) })
+}
+
+// ReadUint32s reads count unsigned 32-bit values from 2*count registers
+func ReadUint32s(client Client, address, count uint16, order string) ([]uint32, error) {
+	return readTyped(client, FuncCodeReadHoldingRegisters, address, count, 4, order, binary.BigEndian.Uint32)
+}
+
+// ReadInputUint32s reads count unsigned 32-bit values from 2*count input registers
+func ReadInputUint32s(client Client, address, count uint16, order string) ([]uint32, error) {
+	return readTyped(client, FuncCodeReadInputRegisters, address, count, 4, order, binary.BigEndian.Uint32)
+}
+
+// ReadInt32s reads count signed 32-bit values from 2*count registers
+func ReadInt32s(client Client, address, count uint16, order string) ([]int32, error) {
+	return readTyped(client, FuncCodeReadHoldingRegisters, address, count, 4, order, func(b []byte) int32 { return int32(binary.BigEndian.Uint32(b)) })
+}
+
+// ReadInputInt32s reads count signed 32-bit values from 2*count input registers
+func ReadInputInt32s(client Client, address, count uint16, order string) ([]int32, error) {
+	return readTyped(client, FuncCodeReadInputRegisters, address, count, 4, order, func(b []byte) int32 { return int32(binary.BigEndian.Uint32(b)) })
+}
+
+// ReadFloat32s reads count IEEE 754 single precision values from 2*count registers
+func ReadFloat32s(client Client, address, count uint16, order string) ([]float32, error) {
+	return readTyped(client, FuncCodeReadHoldingRegisters, address, count, 4, order, func(b []byte) float32 { return math.Float32frombits(binary.BigEndian.Uint32(b)) })
+}
+
+// ReadInputFloat32s reads count IEEE 754 single precision values from 2*count input registers
+func ReadInputFloat32s(client Client, address, count uint16, order string) ([]float32, error) {
+	return readTyped(client, FuncCodeReadInputRegisters, address, count, 4, order, func(b []byte) float32 { return math.Float32frombits(binary.BigEndian.Uint32(b)) })
+}
+
+// ReadUint64s reads count unsigned 64-bit values from 4*count registers
+func ReadUint64s(client Client, address, count uint16, order string) ([]uint64, error) {
+	return readTyped(client, FuncCodeReadHoldingRegisters, address, count, 8, order, binary.BigEndian.Uint64)
+}
+
+// ReadInputUint64s reads count unsigned 64-bit values from 4*count input registers
+func ReadInputUint64s(client Client, address, count uint16, order string) ([]uint64, error) {
+	return readTyped(client, FuncCodeReadInputRegisters, address, count, 8, order, binary.BigEndian.Uint64)
+}
+
+// ReadInt64s reads count signed 64-bit values from 4*count registers
+func ReadInt64s(client Client, address, count uint16, order string) ([]int64, error) {
+	return readTyped(client, FuncCodeReadHoldingRegisters, address, count, 8, order, func(b []byte) int64 { return int64(binary.BigEndian.Uint64(b)) })
+}
+
+// ReadInputInt64s reads count signed 64-bit values from 4*count input registers
+func ReadInputInt64s(client Client, address, count uint16, order string) ([]int64, error) {
+	return readTyped(client, FuncCodeReadInputRegisters, address, count, 8, order, func(b []byte) int64 { return int64(binary.BigEndian.Uint64(b)) })
+}
+
+// ReadFloat64s reads count IEEE 754 double precision values from 4*count registers
+func ReadFloat64s(client Client, address, count uint16, order string) ([]float64, error) {
+	return readTyped(client, FuncCodeReadHoldingRegisters, address, count, 8, order, func(b []byte) float64 { return math.Float64frombits(binary.BigEndian.Uint64(b)) })
+}
+
+// ReadInputFloat64s reads count IEEE 754 double precision values from 4*count input registers
+func ReadInputFloat64s(client Client, address, count uint16, order string) ([]float64, error) {
+	return readTyped(client, FuncCodeReadInputRegisters, address, count, 8, order, func(b []byte) float64 { return math.Float64frombits(binary.BigEndian.Uint64(b)) })
+}
+
+// WriteInt16s writes signed 16-bit values; order is "AB" or "BA"
+func WriteInt16s(client Client, address uint16, values []int16, order string) error {
+	return writeTyped(client, address, values, 2, order, func(b []byte, v int16) { binary.BigEndian.PutUint16(b, uint16(v)) })
+}
+
+// WriteUint32s writes unsigned 32-bit values to 2*len(values) registers
+func WriteUint32s(client Client, address uint16, values []uint32, order string) error {
+	return writeTyped(client, address, values, 4, order, binary.BigEndian.PutUint32)
+}
+
+// WriteInt32s writes signed 32-bit values to 2*len(values) registers
+func WriteInt32s(client Client, address uint16, values []int32, order string) error {
+	return writeTyped(client, address, values, 4, order, func(b []byte, v int32) { binary.BigEndian.PutUint32(b, uint32(v)) })
+}
+
+// WriteFloat32s writes IEEE 754 single precision values to 2*len(values) registers
+func WriteFloat32s(client Client, address uint16, values []float32, order string) error {
+	return writeTyped(client, address, values, 4, order, func(b []byte, v float32) { binary.BigEndian.PutUint32(b, math.Float32bits(v)) })
+}
+
+// WriteUint64s writes unsigned 64-bit values to 4*len(values) registers
+func WriteUint64s(client Client, address uint16, values []uint64, order string) error {
+	return writeTyped(client, address, values, 8, order, binary.BigEndian.PutUint64)
+}
+
+// WriteInt64s writes signed 64-bit values to 4*len(values) registers
+func WriteInt64s(client Client, address uint16, values []int64, order string) error {
+	return writeTyped(client, address, values, 8, order, func(b []byte, v int64) { binary.BigEndian.PutUint64(b, uint64(v)) })
+}
+
+// WriteFloat64s writes IEEE 754 double precision values to 4*len(values) registers
+func WriteFloat64s(client Client, address uint16, values []float64, order string) error {
+	return writeTyped(client, address, values, 8, order, func(b []byte, v float64) { binary.BigEndian.PutUint64(b, math.Float64bits(v)) })
+}
+
+// stringRegister returns the string options for order "AB" (or "") and "BA", which
+// swaps the two characters of every register
+func stringRegister(order string) (DeviceRegister, error) {
+	order, err := checkOrder(order, 2)
+	if err != nil {
+		return DeviceRegister{}, err
+	}
+	return DeviceRegister{StringByteSwap: order == "BA", StringTrim: "null"}, nil
+}
+
+// ReadString reads a UTF-8 string from quantity registers, cut at the first NUL.
+// order is "AB" or "BA" for strings with swapped characters in every register.
+func ReadString(client Client, address, quantity uint16, order string) (string, error) {
+	return readString(client, FuncCodeReadHoldingRegisters, address, quantity, order)
+}
+
+// ReadInputString reads a string from quantity input registers; see ReadString
+func ReadInputString(client Client, address, quantity uint16, order string) (string, error) {
+	return readString(client, FuncCodeReadInputRegisters, address, quantity, order)
+}
+
+func readString(client Client, function uint8, address, quantity uint16, order string) (string, error) {
+	r, err := stringRegister(order)
+	if err != nil {
+		return "", err
+	}
+	data, err := readRegisters(client, function, address, quantity)
+	if err != nil {
+		return "", err
+	}
+	return r.decodeString(data)
+}
+
+// WriteString writes s to quantity registers, padded with NULs; see ReadString
+func WriteString(client Client, address, quantity uint16, s string, order string) error {
+	r, err := stringRegister(order)
+	if err != nil {
+		return err
+	}
+	data, err := r.encodeString(s, int(quantity)*2)
+	if err != nil {
+		return fmt.Errorf("modbus: %w", err)
+	}
+	_, err = client.WriteMultipleRegisters(address, quantity, data)
+	return err
+}
+
+// ReadCoilsBool reads quantity coils as bools
+func ReadCoilsBool(client Client, address, quantity uint16) ([]bool, error) {
+	data, err := client.ReadCoils(address, quantity)
+	if err != nil {
+		return nil, err
+	}
+	bits, err := unpackBits(data, quantity)
+	if err != nil {
+		return nil, fmt.Errorf("modbus: %w", err)
+	}
+	return bits, nil
+}
+
+// WriteCoilsBool writes values to consecutive coils with function code 15
+func WriteCoilsBool(client Client, address uint16, values []bool) error {
+	_, err := client.WriteMultipleCoils(address, uint16(len(values)), packBits(values))
+	return err
+}
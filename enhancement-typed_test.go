@@ -0,0 +1,121 @@
+package modbus
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTypedAccessors(t *testing.T) {
+	client := newMemoryClient()
+	client.SetSlaveId(1)
+
+	// 50.0 = 0x42480000, word swapped by CDAB
+	if err := WriteFloat32s(client, 0, []float32{50, -1.5}, "CDAB"); err != nil {
+		t.Fatal(err)
+	}
+	if hi, lo := client.register(1, 0), client.register(1, 1); hi != 0x0000 || lo != 0x4248 {
+		t.Errorf("float32 registers: got %04X %04X, expected 0000 4248", hi, lo)
+	}
+	if values, err := ReadFloat32s(client, 0, 2, "CDAB"); err != nil || !reflect.DeepEqual(values, []float32{50, -1.5}) {
+		t.Errorf("ReadFloat32s = %v, %v", values, err)
+	}
+
+	client.setRegisters(1, 10, 0x0001, 0x0002, 0x0003, 0x0004)
+	if values, err := ReadInt64s(client, 10, 1, ""); err != nil || values[0] != 0x0001000200030004 {
+		t.Errorf("ReadInt64s = %X, %v", values, err)
+	}
+	if values, err := ReadUint64s(client, 10, 1, "GHEFCDAB"); err != nil || values[0] != 0x0004000300020001 {
+		t.Errorf("ReadUint64s(GHEFCDAB) = %X, %v", values, err)
+	}
+	if values, err := ReadUint32s(client, 10, 2, "BADC"); err != nil || !reflect.DeepEqual(values, []uint32{0x01000200, 0x03000400}) {
+		t.Errorf("ReadUint32s(BADC) = %X, %v", values, err)
+	}
+	if err := WriteInt32s(client, 20, []int32{-2}, "CDAB"); err != nil || client.register(1, 20) != 0xFFFE || client.register(1, 21) != 0xFFFF {
+		t.Errorf("WriteInt32s: %v", err)
+	}
+	if err := WriteInt64s(client, 30, []int64{-3}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := ReadInt64s(client, 30, 1, ""); err != nil || values[0] != -3 {
+		t.Errorf("ReadInt64s = %v, %v", values, err)
+	}
+	if _, err := ReadFloat32s(client, 0, 1, "ABCDEFGH"); err == nil {
+		t.Errorf("expected an error for an 8-byte order on 4-byte values")
+	}
+	if err := WriteFloat64s(client, 0, []float64{1}, "ABCA"); err == nil {
+		t.Errorf("expected an error for an invalid order")
+	}
+	// 16384 * 4 registers would wrap around to 0 in a uint16 quantity
+	for _, count := range []uint16{0, 32, 16384} {
+		if _, err := ReadUint64s(client, 0, count, ""); err == nil {
+			t.Errorf("expected an error reading %d 64-bit values", count)
+		}
+	}
+	if _, err := ReadFloat32s(client, 0, 62, ""); err != nil {
+		t.Errorf("ReadFloat32s of 124 registers: %v", err)
+	}
+	if err := WriteFloat32s(client, 0, make([]float32, 62), ""); err == nil {
+		t.Errorf("expected an error writing 124 registers")
+	}
+
+	// "Hello" with the characters of every register swapped
+	if err := WriteString(client, 40, 4, "Hello", "BA"); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.register(1, 40); got != 'e'<<8|'H' {
+		t.Errorf("first string register %04X", got)
+	}
+	if s, err := ReadString(client, 40, 4, "BA"); err != nil || s != "Hello" {
+		t.Errorf("ReadString = %q, %v", s, err)
+	}
+	if err := WriteString(client, 40, 2, "Hello", ""); err == nil {
+		t.Errorf("expected an error for a string longer than the registers")
+	}
+
+	coils := []bool{true, false, true, true, false, false, false, false, true}
+	if err := WriteCoilsBool(client, 100, coils); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := ReadCoilsBool(client, 100, 9); err != nil || !reflect.DeepEqual(values, coils) {
+		t.Errorf("ReadCoilsBool = %v, %v", values, err)
+	}
+
+	// The same accessors work on a ModbusApi through the adapter
+	api := NewClientFromApi(NewApiFromClient(client), 1)
+	if values, err := ReadFloat32s(api, 0, 2, "CDAB"); err != nil || !reflect.DeepEqual(values, []float32{50, -1.5}) {
+		t.Errorf("ReadFloat32s through a ModbusApi = %v, %v", values, err)
+	}
+}
+
+func TestTypedAccessorsModbusHandler(t *testing.T) {
+	slaves := &testRTUSlaves{
+		registers: map[uint8][]uint16{1: make([]uint16, 8)},
+		inputs:    map[uint8][]uint16{1: {0x4248, 0x0000, 'e'<<8 | 'H', 'l'<<8 | 'l', 'o'}},
+	}
+	gateway := NewTCPRTUGateway()
+	gateway.Route(newTestRTUBus(t, slaves), 1)
+	conn, err := net.Dial("tcp", startTestGateway(t, gateway))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClientFromApi(NewModbusTCPHandler(conn, 2*time.Second), 1)
+
+	if err := WriteInt32s(client, 0, []int32{-2, 70000}, "CDAB"); err != nil {
+		t.Fatal(err)
+	}
+	if slaves.registers[1][0] != 0xFFFE || slaves.registers[1][1] != 0xFFFF {
+		t.Errorf("int32 registers: %04X", slaves.registers[1][:4])
+	}
+	if values, err := ReadInt32s(client, 0, 2, "CDAB"); err != nil || !reflect.DeepEqual(values, []int32{-2, 70000}) {
+		t.Errorf("ReadInt32s = %v, %v", values, err)
+	}
+	if values, err := ReadInputFloat32s(client, 0, 1, ""); err != nil || values[0] != 50 {
+		t.Errorf("ReadInputFloat32s = %v, %v", values, err)
+	}
+	if s, err := ReadInputString(client, 2, 3, "BA"); err != nil || s != "Hello" {
+		t.Errorf("ReadInputString = %q, %v", s, err)
+	}
+}
@@ -0,0 +1,72 @@
+package modbus
+
+// RTUOverUDPClientHandler implements Packager and Transporter interface for RTU
+// frames over UDP, as offered by serial device servers in UDP mode.
+type RTUOverUDPClientHandler struct {
+	rtuPackager
+	udpTransporter
+}
+
+// NewRTUOverUDPClientHandler allocates and initializes a RTUOverUDPClientHandler.
+func NewRTUOverUDPClientHandler(address string) *RTUOverUDPClientHandler {
+	handler := &RTUOverUDPClientHandler{}
+	handler.Address = address
+	handler.Timeout = udpTimeout
+	handler.Retries = udpRetries
+	handler.accept = acceptRTU
+	return handler
+}
+
+// RTUOverUDPClient creates RTU over UDP client with default handler and given connect string.
+func RTUOverUDPClient(address string) Client {
+	return NewClient(NewRTUOverUDPClientHandler(address))
+}
+
+// ASCIIOverUDPClientHandler implements Packager and Transporter interface for ASCII
+// frames over UDP.
+type ASCIIOverUDPClientHandler struct {
+	asciiPackager
+	udpTransporter
+}
+
+// NewASCIIOverUDPClientHandler allocates and initializes a ASCIIOverUDPClientHandler.
+func NewASCIIOverUDPClientHandler(address string) *ASCIIOverUDPClientHandler {
+	handler := &ASCIIOverUDPClientHandler{}
+	handler.Address = address
+	handler.Timeout = udpTimeout
+	handler.Retries = udpRetries
+	handler.accept = acceptASCII
+	return handler
+}
+
+// ASCIIOverUDPClient creates ASCII over UDP client with default handler and given connect string.
+func ASCIIOverUDPClient(address string) Client {
+	return NewClient(NewASCIIOverUDPClientHandler(address))
+}
+
+// acceptRTU accepts an RTU frame with a valid CRC from the slave and for the function
+// of the request. RTU has no transaction id, so a late answer to an earlier identical
+// request cannot be told apart; the transporter drops those queued before sending.
+func acceptRTU(aduRequest, aduResponse []byte) bool {
+	var packager rtuPackager
+	if len(aduRequest) < 2 || packager.Verify(aduRequest, aduResponse) != nil {
+		return false
+	}
+	pdu, err := packager.Decode(aduResponse)
+	return err == nil && pdu.FunctionCode&0x7F == aduRequest[1]
+}
+
+// acceptASCII accepts an ASCII frame with a valid LRC from the slave and for the
+// function of the request
+func acceptASCII(aduRequest, aduResponse []byte) bool {
+	var packager asciiPackager
+	if len(aduRequest) < 5 || packager.Verify(aduRequest, aduResponse) != nil {
+		return false
+	}
+	function, err := readHex(aduRequest[3:])
+	if err != nil {
+		return false
+	}
+	pdu, err := packager.Decode(aduResponse)
+	return err == nil && pdu.FunctionCode&0x7F == function
+}
@@ -0,0 +1,103 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTestUDPDevice answers each datagram with the datagrams returned by respond,
+// given the index of the request
+func startTestUDPDevice(t *testing.T, respond func(i int, request []byte) [][]byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, udpMaxSize)
+		for i := 0; ; i++ {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			for _, datagram := range respond(i, append([]byte(nil), buf[:n]...)) {
+				conn.WriteTo(datagram, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestRTUOverUDPClientHandler(t *testing.T) {
+	encoder := &rtuPackager{}
+	frame := func(slave uint8, function uint8, data ...byte) []byte {
+		encoder.SetSlaverId(slave)
+		adu, _ := encoder.Encode(&ProtocolDataUnit{FunctionCode: function, Data: data})
+		return adu
+	}
+	addr := startTestUDPDevice(t, func(i int, request []byte) [][]byte {
+		value := byte(0x10 + request[3])
+		response := frame(request[0], request[1], 2, 0, value)
+		switch i {
+		case 0:
+			// Lost
+			return nil
+		case 1:
+			// Another slave, a corrupted frame and the response twice
+			corrupted := append([]byte(nil), response...)
+			corrupted[4] ^= 0xFF
+			return [][]byte{frame(request[0]+1, request[1], 2, 0, 0xEE), corrupted, response, response}
+		}
+		return [][]byte{response}
+	})
+
+	handler := NewRTUOverUDPClientHandler(addr)
+	handler.Timeout = 100 * time.Millisecond
+	handler.SetSlaverId(5)
+	defer handler.Close()
+	client := NewClient(handler)
+	if results, err := client.ReadHoldingRegisters(1, 1); err != nil || binary.BigEndian.Uint16(results) != 0x11 {
+		t.Errorf("read after a lost request = % X, %v", results, err)
+	}
+	// The duplicate response has arrived by now and must not answer the next read
+	time.Sleep(50 * time.Millisecond)
+	if results, err := client.ReadHoldingRegisters(2, 1); err != nil || binary.BigEndian.Uint16(results) != 0x12 {
+		t.Errorf("read after a duplicate response = % X, %v", results, err)
+	}
+}
+
+func TestASCIIOverUDPClientHandler(t *testing.T) {
+	encoder := &asciiPackager{}
+	addr := startTestUDPDevice(t, func(i int, request []byte) [][]byte {
+		decoder := &asciiPackager{}
+		pdu, err := decoder.Decode(request)
+		if err != nil {
+			t.Errorf("invalid request %q: %v", request, err)
+			return nil
+		}
+		encoder.SetSlaverId(1)
+		exception, _ := encoder.Encode(&ProtocolDataUnit{FunctionCode: pdu.FunctionCode | 0x80, Data: []byte{ExceptionCodeIllegalDataAddress}})
+		response, _ := encoder.Encode(&ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: []byte{2, 0x12, 0x34}})
+		if pdu.Data[1] == 9 {
+			return [][]byte{[]byte(":garbage\r\n"), exception}
+		}
+		return [][]byte{[]byte(":garbage\r\n"), response}
+	})
+
+	handler := NewASCIIOverUDPClientHandler(addr)
+	handler.Timeout = 100 * time.Millisecond
+	handler.SetSlaverId(1)
+	defer handler.Close()
+	client := NewClient(handler)
+	if results, err := client.ReadInputRegisters(0, 1); err != nil || binary.BigEndian.Uint16(results) != 0x1234 {
+		t.Errorf("read = % X, %v", results, err)
+	}
+	if _, err := client.ReadInputRegisters(9, 1); err == nil {
+		t.Errorf("expected an exception")
+	}
+	if client.GetHandlerType() != "ASCII" {
+		t.Errorf("handler type = %q", client.GetHandlerType())
+	}
+}
@@ -0,0 +1,297 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Default time to wait for the response to a UDP request
+	udpTimeout = time.Second
+	// Default number of times a UDP request is resent when no response arrives
+	udpRetries = 2
+	// Largest datagram expected, an ASCII frame
+	udpMaxSize = asciiMaxSize
+)
+
+// UDPClientHandler implements Packager and Transporter interface for Modbus TCP
+// framing (MBAP) over UDP.
+type UDPClientHandler struct {
+	tcpPackager
+	udpTransporter
+}
+
+// NewUDPClientHandler allocates a new UDPClientHandler.
+func NewUDPClientHandler(address string) *UDPClientHandler {
+	h := &UDPClientHandler{}
+	h.Address = address
+	h.Timeout = udpTimeout
+	h.Retries = udpRetries
+	h.accept = acceptMBAP
+	return h
+}
+
+// UDPClient creates UDP client with default handler and given connect string.
+func UDPClient(address string) Client {
+	return NewClient(NewUDPClientHandler(address))
+}
+
+func (mb *UDPClientHandler) Type() string {
+	return "UDP"
+}
+
+// udpTransporter implements Transporter interface. Each datagram holds a whole frame.
+// Datagrams may be lost, so a request is resent when no response arrives within
+// Timeout. Datagrams still queued from earlier requests are dropped before sending,
+// and datagrams that are not a response to the request, e.g. late answers to an
+// earlier attempt, are discarded.
+type udpTransporter struct {
+	// Connect string
+	Address string
+	// Response timeout of each attempt
+	Timeout time.Duration
+	// Times a request is resent after a timeout
+	Retries int
+	// Transmission logger
+	Logger io.WriteCloser
+
+	mu     sync.Mutex
+	conn   net.Conn
+	accept func(aduRequest, aduResponse []byte) bool // Whether a datagram answers the request
+}
+
+// Get Interface Name
+func (mb *udpTransporter) GetInterfaceName() string {
+	return mb.Address
+}
+
+// Send sends a request and returns the response with the same transaction id
+func (mb *udpTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if err = mb.connect(); err != nil {
+		return
+	}
+	mb.drain()
+	for attempt := 0; attempt <= mb.Retries; attempt++ {
+		mb.logf("modbus: sending % x", aduRequest)
+		if _, err = mb.conn.Write(aduRequest); err != nil {
+			return
+		}
+		aduResponse, err = mb.receive(aduRequest)
+		if err == nil {
+			mb.logf("modbus: received % x\n", aduResponse)
+			return
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return
+		}
+	}
+	return
+}
+
+// receive reads datagrams until the response to aduRequest or the timeout
+func (mb *udpTransporter) receive(aduRequest []byte) ([]byte, error) {
+	var deadline time.Time
+	if mb.Timeout > 0 {
+		deadline = time.Now().Add(mb.Timeout)
+	}
+	if err := mb.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	var data [udpMaxSize]byte
+	for {
+		n, err := mb.conn.Read(data[:])
+		if err != nil {
+			return nil, err
+		}
+		if !mb.accept(aduRequest, data[:n]) {
+			mb.logf("modbus: discarding datagram % x\n", data[:n])
+			continue
+		}
+		return append([]byte(nil), data[:n]...), nil
+	}
+}
+
+// drain drops the datagrams already received. A deadline already passed fails reads
+// before looking at the socket, so it waits a millisecond instead.
+func (mb *udpTransporter) drain() {
+	if err := mb.conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return
+	}
+	var data [udpMaxSize]byte
+	for {
+		n, err := mb.conn.Read(data[:])
+		if err != nil {
+			return
+		}
+		mb.logf("modbus: discarding stale datagram % x\n", data[:n])
+	}
+}
+
+// acceptMBAP accepts a Modbus TCP frame with the transaction id of the request
+func acceptMBAP(aduRequest, aduResponse []byte) bool {
+	n := len(aduResponse)
+	return n > tcpHeaderSize && int(binary.BigEndian.Uint16(aduResponse[4:]))+tcpHeaderSize-1 == n &&
+		binary.BigEndian.Uint16(aduResponse) == binary.BigEndian.Uint16(aduRequest)
+}
+
+// For special usage: sends a datagram and returns the next one received
+func (mb *udpTransporter) SendRawBytes(aduRequest []byte) (aduResponse []byte, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if err = mb.connect(); err != nil {
+		return
+	}
+	mb.logf("modbus: sending % x\n", aduRequest)
+	if _, err = mb.conn.Write(aduRequest); err != nil {
+		return
+	}
+	var deadline time.Time
+	if mb.Timeout > 0 {
+		deadline = time.Now().Add(mb.Timeout)
+	}
+	if err = mb.conn.SetReadDeadline(deadline); err != nil {
+		return
+	}
+	var data [udpMaxSize]byte
+	var n int
+	if n, err = mb.conn.Read(data[:]); err != nil {
+		return
+	}
+	aduResponse = data[:n]
+	mb.logf("modbus: received % x\n", aduResponse)
+	return
+}
+
+// Connect creates the UDP socket for the address in Address.
+func (mb *udpTransporter) Connect() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return mb.connect()
+}
+
+func (mb *udpTransporter) connect() error {
+	if mb.conn == nil {
+		conn, err := net.Dial("udp", mb.Address)
+		if err != nil {
+			return err
+		}
+		mb.conn = conn
+	}
+	return nil
+}
+
+// Close closes the UDP socket.
+func (mb *udpTransporter) Close() (err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.conn != nil {
+		err = mb.conn.Close()
+		mb.conn = nil
+	}
+	return
+}
+
+func (mb *udpTransporter) logf(format string, v ...interface{}) {
+	if mb.Logger != nil {
+		mb.Logger.Write(fmt.Appendf(nil, format, v...))
+	}
+}
+
+// UDPServer answers Modbus TCP frames received as UDP datagrams with a Transactor.
+// Each datagram holds one request, answered to its sender with the same transaction
+// id; handler failures are answered with exception 0x04 (server device failure).
+type UDPServer struct {
+	OnError func(err error) // Optional callback for invalid datagrams and handler errors
+
+	handler  Transactor
+	packager *TCPPackager
+	mu       sync.Mutex
+	conn     net.PacketConn
+	closed   bool
+}
+
+// NewUDPServer creates a server answering with handler
+func NewUDPServer(handler Transactor) *UDPServer {
+	return &UDPServer{handler: handler, packager: NewTCPPackager()}
+}
+
+// ListenAndServe listens on the UDP address and serves requests until Close
+func (s *UDPServer) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve answers requests on conn until Close. It always returns a non-nil error;
+// after Close the error is net.ErrClosed.
+func (s *UDPServer) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return net.ErrClosed
+	}
+	s.conn = conn
+	s.mu.Unlock()
+	var data [tcpMaxLength]byte
+	for {
+		n, addr, err := conn.ReadFrom(data[:])
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			return err
+		}
+		transactionID, unit, pdu, err := s.packager.Unpack(data[:n])
+		if err != nil || len(pdu) == 0 {
+			s.reportError(fmt.Errorf("udp server: %s: invalid datagram % X", addr, data[:n]))
+			continue
+		}
+		response, err := s.handler.Transact(unit, append([]byte(nil), pdu...))
+		if err != nil {
+			s.reportError(fmt.Errorf("udp server: unit %d: %w", unit, err))
+			response = exceptionPDU(pdu[0], ExceptionCodeServerDeviceFailure)
+		}
+		if response == nil {
+			continue
+		}
+		out, _ := s.packager.Pack(transactionID, unit, response)
+		if _, err := conn.WriteTo(out, addr); err != nil {
+			s.reportError(fmt.Errorf("udp server: %s: %w", addr, err))
+		}
+	}
+}
+
+// Close stops serving and closes the socket
+func (s *UDPServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+func (s *UDPServer) reportError(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
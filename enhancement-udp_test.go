@@ -0,0 +1,117 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func startTestUDPServer(t *testing.T, server *UDPServer) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(conn) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-served; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+	})
+	return conn.LocalAddr().String()
+}
+
+func TestUDPClientHandler(t *testing.T) {
+	device := &testTransactor{registers: []uint16{5, 6, 7}}
+	addr := startTestUDPServer(t, NewUDPServer(device))
+
+	handler := NewUDPClientHandler(addr)
+	handler.SetSlaverId(1)
+	defer handler.Close()
+	client := NewClient(handler)
+	if results, err := client.ReadHoldingRegisters(1, 2); err != nil || binary.BigEndian.Uint16(results) != 6 {
+		t.Errorf("read = % X, %v", results, err)
+	}
+	if _, err := client.WriteSingleRegister(0, 50); err != nil {
+		t.Fatal(err)
+	}
+	device.mu.Lock()
+	if device.registers[0] != 50 {
+		t.Errorf("register 0 = %d after write", device.registers[0])
+	}
+	device.mu.Unlock()
+	var mbErr *ModbusError
+	if _, err := client.ReadCoils(0, 1); !errors.As(err, &mbErr) || mbErr.ExceptionCode != ExceptionCodeIllegalFunction {
+		t.Errorf("expected illegal function exception, got %v", err)
+	}
+	device.mu.Lock()
+	device.err = os.ErrDeadlineExceeded
+	device.mu.Unlock()
+	if _, err := client.ReadHoldingRegisters(0, 1); !errors.As(err, &mbErr) || mbErr.ExceptionCode != ExceptionCodeServerDeviceFailure {
+		t.Errorf("expected server device failure, got %v", err)
+	}
+	if client.GetHandlerType() != "UDP" {
+		t.Errorf("handler type = %q", client.GetHandlerType())
+	}
+}
+
+func TestUDPClientHandlerRetries(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The device loses the first request, then answers a stale transaction before
+	// the response
+	go func() {
+		packager := NewTCPPackager()
+		buf := make([]byte, tcpMaxLength)
+		for i := 0; ; i++ {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if i == 0 {
+				continue
+			}
+			transactionID, unit, _, _ := packager.Unpack(buf[:n])
+			stale, _ := packager.Pack(transactionID-1, unit, []byte{FuncCodeReadHoldingRegisters, 2, 0, 1})
+			conn.WriteTo(stale, addr)
+			conn.WriteTo([]byte{1, 2, 3}, addr)
+			response, _ := packager.Pack(transactionID, unit, []byte{FuncCodeReadHoldingRegisters, 2, 0, 9})
+			conn.WriteTo(response, addr)
+		}
+	}()
+
+	handler := NewUDPClientHandler(conn.LocalAddr().String())
+	handler.Timeout = 100 * time.Millisecond
+	defer handler.Close()
+	client := NewClient(handler)
+	if results, err := client.ReadHoldingRegisters(0, 1); err != nil || binary.BigEndian.Uint16(results) != 9 {
+		t.Errorf("read after a lost request = % X, %v", results, err)
+	}
+
+	// Without retries a lost request is a timeout
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	handler = NewUDPClientHandler(silent.LocalAddr().String())
+	handler.Timeout = 50 * time.Millisecond
+	handler.Retries = 0
+	defer handler.Close()
+	start := time.Now()
+	_, err = NewClient(handler).ReadHoldingRegisters(0, 1)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timeout took %v", elapsed)
+	}
+}
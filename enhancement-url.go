@@ -0,0 +1,218 @@
+package modbus
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// URLConfig is a connection parsed from a URL such as
+//
+//	tcp://192.168.1.10:502?unit=3
+//	unix:///run/modbus.sock
+//	udp://192.168.1.11:502?retries=5
+//	rtu:///dev/ttyUSB0?baud=9600&parity=E&timeout=1s
+//	ascii://COM3?baud=9600&databits=7
+//	rtu+tcp://192.168.1.12:4001?unit=2
+//	rtu+udp://, ascii+tcp://, ascii+udp://
+//
+// ParseURL fills in the defaults of the handler for the scheme, and String leaves
+// them out, so a parsed config turns back into an equivalent URL.
+type URLConfig struct {
+	Scheme      string        // tcp, unix, udp, rtu, ascii, rtu+tcp, ascii+tcp, rtu+udp or ascii+udp
+	Address     string        // host:port, socket path or serial device
+	Unit        uint8         // Slave id, 1 by default
+	Timeout     time.Duration // Response timeout
+	IdleTimeout time.Duration // Close idle connections after this long, not for UDP schemes
+	Retries     int           // Resends after a timeout, UDP schemes only
+	BaudRate    int           // Serial schemes only, like DataBits, StopBits and Parity
+	DataBits    int
+	StopBits    int
+	Parity      string // N, E or O
+}
+
+// Open creates a client from a connection URL, see URLConfig
+func Open(rawURL string) (Client, error) {
+	config, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return config.Open()
+}
+
+// defaultURLConfig returns the handler defaults for a scheme
+func defaultURLConfig(scheme string) (URLConfig, error) {
+	config := URLConfig{Scheme: scheme, Unit: 1}
+	switch scheme {
+	case "tcp", "unix", "rtu+tcp", "ascii+tcp":
+		config.Timeout = tcpTimeout
+		config.IdleTimeout = tcpIdleTimeout
+	case "udp", "rtu+udp", "ascii+udp":
+		config.Timeout = udpTimeout
+		config.Retries = udpRetries
+	case "rtu", "ascii":
+		config.Timeout = serialTimeout
+		config.IdleTimeout = serialIdleTimeout
+		config.BaudRate = 19200
+		config.DataBits = 8
+		config.StopBits = 1
+		config.Parity = "E"
+	default:
+		return config, fmt.Errorf("modbus: unknown URL scheme %q", scheme)
+	}
+	return config, nil
+}
+
+// ParseURL parses a connection URL, see URLConfig
+func ParseURL(rawURL string) (URLConfig, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return URLConfig{}, fmt.Errorf("modbus: %w", err)
+	}
+	config, err := defaultURLConfig(u.Scheme)
+	if err != nil {
+		return config, err
+	}
+	switch config.Scheme {
+	case "unix", "rtu", "ascii":
+		config.Address = u.Host + u.Path
+	default:
+		config.Address = u.Host
+	}
+	if config.Address == "" {
+		return config, fmt.Errorf("modbus: URL %q has no address", rawURL)
+	}
+	serial := config.Scheme == "rtu" || config.Scheme == "ascii"
+	udp := strings.HasSuffix(config.Scheme, "udp")
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		switch {
+		case key == "unit":
+			var unit uint64
+			unit, err = strconv.ParseUint(value, 10, 8)
+			config.Unit = uint8(unit)
+		case key == "timeout":
+			config.Timeout, err = time.ParseDuration(value)
+		case key == "idle" && !udp:
+			config.IdleTimeout, err = time.ParseDuration(value)
+		case key == "retries" && udp:
+			config.Retries, err = strconv.Atoi(value)
+		case key == "baud" && serial:
+			config.BaudRate, err = strconv.Atoi(value)
+		case key == "databits" && serial:
+			config.DataBits, err = strconv.Atoi(value)
+		case key == "stopbits" && serial:
+			config.StopBits, err = strconv.Atoi(value)
+		case key == "parity" && serial:
+			config.Parity = strings.ToUpper(value)
+			if config.Parity != "N" && config.Parity != "E" && config.Parity != "O" {
+				err = fmt.Errorf("must be N, E or O")
+			}
+		default:
+			return config, fmt.Errorf("modbus: unknown parameter %q for %s URLs", key, config.Scheme)
+		}
+		if err != nil {
+			return config, fmt.Errorf("modbus: parameter %s=%q: %w", key, value, err)
+		}
+	}
+	return config, nil
+}
+
+// String returns the URL of the config, without parameters that have their default
+// values
+func (c URLConfig) String() string {
+	u := url.URL{Scheme: c.Scheme}
+	if strings.HasPrefix(c.Address, "/") {
+		u.Path = c.Address
+	} else {
+		u.Host = c.Address
+	}
+	defaults, _ := defaultURLConfig(c.Scheme)
+	query := url.Values{}
+	if c.Unit != defaults.Unit {
+		query.Set("unit", strconv.Itoa(int(c.Unit)))
+	}
+	if c.Timeout != defaults.Timeout {
+		query.Set("timeout", c.Timeout.String())
+	}
+	if c.IdleTimeout != defaults.IdleTimeout {
+		query.Set("idle", c.IdleTimeout.String())
+	}
+	if c.Retries != defaults.Retries {
+		query.Set("retries", strconv.Itoa(c.Retries))
+	}
+	if c.BaudRate != defaults.BaudRate {
+		query.Set("baud", strconv.Itoa(c.BaudRate))
+	}
+	if c.DataBits != defaults.DataBits {
+		query.Set("databits", strconv.Itoa(c.DataBits))
+	}
+	if c.StopBits != defaults.StopBits {
+		query.Set("stopbits", strconv.Itoa(c.StopBits))
+	}
+	if c.Parity != defaults.Parity {
+		query.Set("parity", c.Parity)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Handler creates the configured client handler; nothing is connected yet
+func (c URLConfig) Handler() (ClientHandler, error) {
+	var handler ClientHandler
+	switch c.Scheme {
+	case "tcp", "unix":
+		address := c.Address
+		if c.Scheme == "unix" {
+			address = "unix://" + address
+		}
+		h := NewTCPClientHandler(address)
+		h.Timeout, h.IdleTimeout = c.Timeout, c.IdleTimeout
+		handler = h
+	case "rtu+tcp":
+		h := NewRTUOverTCPClientHandler(c.Address)
+		h.Timeout, h.IdleTimeout = c.Timeout, c.IdleTimeout
+		handler = h
+	case "ascii+tcp":
+		h := NewASCIIOverTCPClientHandler(c.Address)
+		h.Timeout, h.IdleTimeout = c.Timeout, c.IdleTimeout
+		handler = h
+	case "udp":
+		h := NewUDPClientHandler(c.Address)
+		h.Timeout, h.Retries = c.Timeout, c.Retries
+		handler = h
+	case "rtu+udp":
+		h := NewRTUOverUDPClientHandler(c.Address)
+		h.Timeout, h.Retries = c.Timeout, c.Retries
+		handler = h
+	case "ascii+udp":
+		h := NewASCIIOverUDPClientHandler(c.Address)
+		h.Timeout, h.Retries = c.Timeout, c.Retries
+		handler = h
+	case "rtu":
+		h := NewRTUClientHandler(c.Address)
+		h.Timeout, h.IdleTimeout = c.Timeout, c.IdleTimeout
+		h.BaudRate, h.DataBits, h.StopBits, h.Parity = c.BaudRate, c.DataBits, c.StopBits, c.Parity
+		handler = h
+	case "ascii":
+		h := NewASCIIClientHandler(c.Address)
+		h.Timeout, h.IdleTimeout = c.Timeout, c.IdleTimeout
+		h.BaudRate, h.DataBits, h.StopBits, h.Parity = c.BaudRate, c.DataBits, c.StopBits, c.Parity
+		handler = h
+	default:
+		return nil, fmt.Errorf("modbus: unknown URL scheme %q", c.Scheme)
+	}
+	handler.SetSlaverId(c.Unit)
+	return handler, nil
+}
+
+// Open creates a client with the configured handler
+func (c URLConfig) Open() (Client, error) {
+	handler, err := c.Handler()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(handler), nil
+}
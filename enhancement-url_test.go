@@ -0,0 +1,91 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseURL(t *testing.T) {
+	for _, tc := range []struct {
+		url      string
+		expected URLConfig
+		handler  string
+	}{
+		{"tcp://192.168.1.10:502?unit=3", URLConfig{Scheme: "tcp", Address: "192.168.1.10:502", Unit: 3, Timeout: tcpTimeout, IdleTimeout: tcpIdleTimeout}, "*modbus.TCPClientHandler"},
+		{"unix:///run/modbus.sock", URLConfig{Scheme: "unix", Address: "/run/modbus.sock", Unit: 1, Timeout: tcpTimeout, IdleTimeout: tcpIdleTimeout}, "*modbus.TCPClientHandler"},
+		{"udp://10.0.0.1:502?retries=5&timeout=250ms", URLConfig{Scheme: "udp", Address: "10.0.0.1:502", Unit: 1, Timeout: 250 * time.Millisecond, Retries: 5}, "*modbus.UDPClientHandler"},
+		{"rtu:///dev/ttyUSB0?baud=9600&parity=n&stopbits=2&timeout=1s", URLConfig{Scheme: "rtu", Address: "/dev/ttyUSB0", Unit: 1, Timeout: time.Second, IdleTimeout: serialIdleTimeout, BaudRate: 9600, DataBits: 8, StopBits: 2, Parity: "N"}, "*modbus.RTUClientHandler"},
+		{"ascii://COM3?databits=7&idle=0s", URLConfig{Scheme: "ascii", Address: "COM3", Unit: 1, Timeout: serialTimeout, BaudRate: 19200, DataBits: 7, StopBits: 1, Parity: "E"}, "*modbus.ASCIIClientHandler"},
+		{"rtu+tcp://192.168.1.12:4001?unit=2", URLConfig{Scheme: "rtu+tcp", Address: "192.168.1.12:4001", Unit: 2, Timeout: tcpTimeout, IdleTimeout: tcpIdleTimeout}, "*modbus.RTUOverTCPClientHandler"},
+		{"ascii+tcp://gw:4002", URLConfig{Scheme: "ascii+tcp", Address: "gw:4002", Unit: 1, Timeout: tcpTimeout, IdleTimeout: tcpIdleTimeout}, "*modbus.ASCIIOverTCPClientHandler"},
+		{"rtu+udp://gw:4001?retries=0", URLConfig{Scheme: "rtu+udp", Address: "gw:4001", Unit: 1, Timeout: time.Second}, "*modbus.RTUOverUDPClientHandler"},
+		{"ascii+udp://gw:4002", URLConfig{Scheme: "ascii+udp", Address: "gw:4002", Unit: 1, Timeout: time.Second, Retries: udpRetries}, "*modbus.ASCIIOverUDPClientHandler"},
+	} {
+		config, err := ParseURL(tc.url)
+		if err != nil {
+			t.Errorf("ParseURL(%q): %v", tc.url, err)
+			continue
+		}
+		if config != tc.expected {
+			t.Errorf("ParseURL(%q) = %+v, expected %+v", tc.url, config, tc.expected)
+		}
+		if again, err := ParseURL(config.String()); err != nil || again != config {
+			t.Errorf("ParseURL(%q) = %+v, %v, expected %+v", config.String(), again, err, config)
+		}
+		handler, err := config.Handler()
+		if err != nil {
+			t.Errorf("%q: %v", tc.url, err)
+		} else if got := fmt.Sprintf("%T", handler); got != tc.handler {
+			t.Errorf("%q handler = %s, expected %s", tc.url, got, tc.handler)
+		}
+	}
+	if s := (URLConfig{Scheme: "rtu", Address: "/dev/ttyS0", Unit: 4, Timeout: serialTimeout, IdleTimeout: serialIdleTimeout, BaudRate: 9600, DataBits: 8, StopBits: 1, Parity: "E"}).String(); s != "rtu:///dev/ttyS0?baud=9600&unit=4" {
+		t.Errorf("String() = %q", s)
+	}
+
+	for _, bad := range []string{
+		"http://host:502",
+		"tcp://",
+		"tcp://host:502?baud=9600",
+		"udp://host:502?idle=1s",
+		"rtu:///dev/ttyS0?parity=X",
+		"tcp://host:502?unit=300",
+		"tcp://host:502?timeout=soon",
+	} {
+		if _, err := ParseURL(bad); err == nil {
+			t.Errorf("ParseURL(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	slaves := &testRTUSlaves{registers: map[uint8][]uint16{3: {30, 31}}}
+	gateway := NewTCPRTUGateway()
+	gateway.Route(newTestRTUBus(t, slaves), 3)
+	addr := startTestGateway(t, gateway)
+
+	client, err := Open("tcp://" + addr + "?unit=3&timeout=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if results, err := client.ReadHoldingRegisters(1, 1); err != nil || binary.BigEndian.Uint16(results) != 31 {
+		t.Errorf("read = % X, %v", results, err)
+	}
+
+	device := &testTransactor{registers: []uint16{40}}
+	udpAddr := startTestUDPServer(t, NewUDPServer(device))
+	client, err = Open("udp://" + udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if results, err := client.ReadHoldingRegisters(0, 1); err != nil || binary.BigEndian.Uint16(results) != 40 {
+		t.Errorf("udp read = % X, %v", results, err)
+	}
+	if _, err := Open("modbus://nowhere"); err == nil {
+		t.Errorf("expected an error for an unknown scheme")
+	}
+}
@@ -0,0 +1,248 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VirtualTag is a calculated tag whose value is an expression over other tags, e.g.
+// "voltage * current". See Expression for the syntax. Virtual tags are delivered as
+// float64 registers alongside the registers read from the device.
+type VirtualTag struct {
+	UUID            string  `json:"uuid"`                      // Unique identifier for the tag
+	Tag             string  `json:"tag"`                       // Name of the tag
+	Alias           string  `json:"alias"`                     // A human-readable name or alias for the tag
+	Expression      string  `json:"expression"`                // Expression over other tags
+	Deadband        float64 `json:"deadband,omitempty"`        // Absolute deadband, see ChangeDetector
+	DeadbandPercent float64 `json:"deadbandPercent,omitempty"` // Percent deadband, see ChangeDetector
+}
+
+// virtualInput is the last known value of a tag referenced by a virtual tag
+type virtualInput struct {
+	value       float64
+	quality     Quality
+	reason      string
+	sourceTime  time.Time
+	receiveTime time.Time
+}
+
+type compiledVirtualTag struct {
+	VirtualTag
+	expr       *Expression
+	last       *float64  // Last successfully calculated value
+	lastSource time.Time // SourceTime of the last calculated value
+}
+
+// VirtualTagEngine evaluates virtual tags in dependency order whenever their inputs update.
+type VirtualTagEngine struct {
+	mu     sync.Mutex
+	tags   []*compiledVirtualTag // Sorted so that every tag follows the virtual tags it uses
+	inputs map[string]virtualInput
+}
+
+// NewVirtualTagEngine parses the expressions of the virtual tags and orders them by
+// their dependencies. inputs are the tags read from devices that expressions may use. It
+// fails on duplicate tags, invalid expressions, references to unknown tags and cycles.
+func NewVirtualTagEngine(tags []VirtualTag, inputs []string) (*VirtualTagEngine, error) {
+	byTag := make(map[string]*compiledVirtualTag, len(tags))
+	for _, tag := range tags {
+		if tag.Tag == "" {
+			return nil, fmt.Errorf("virtual tag without name")
+		}
+		if byTag[tag.Tag] != nil {
+			return nil, fmt.Errorf("duplicate tag: %s", tag.Tag)
+		}
+		expr, err := ParseExpression(tag.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("virtual tag %s: %w", tag.Tag, err)
+		}
+		byTag[tag.Tag] = &compiledVirtualTag{VirtualTag: tag, expr: expr}
+	}
+	known := make(map[string]bool, len(inputs))
+	for _, input := range inputs {
+		known[input] = true
+	}
+	for _, tag := range tags {
+		for _, v := range byTag[tag.Tag].expr.Variables() {
+			if !known[v] && byTag[v] == nil {
+				return nil, fmt.Errorf("virtual tag %s: unknown tag %s", tag.Tag, v)
+			}
+		}
+	}
+
+	// Depth-first topological sort, reporting the path of the first cycle found
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(tags))
+	ordered := make([]*compiledVirtualTag, 0, len(tags))
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		tag := byTag[name]
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			start := 0
+			for i, p := range path {
+				if p == name {
+					start = i
+				}
+			}
+			return fmt.Errorf("cycle in virtual tags: %s -> %s", strings.Join(path[start:], " -> "), name)
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, v := range tag.expr.Variables() {
+			if byTag[v] != nil {
+				if err := visit(v); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		ordered = append(ordered, tag)
+		return nil
+	}
+	for _, tag := range tags {
+		if err := visit(tag.Tag); err != nil {
+			return nil, err
+		}
+	}
+	return &VirtualTagEngine{tags: ordered, inputs: make(map[string]virtualInput)}, nil
+}
+
+// unknownInput returns a virtual tag and a tag it uses that is neither in inputs nor a
+// virtual tag, if any
+func (e *VirtualTagEngine) unknownInput(inputs map[string]bool) (string, string, bool) {
+	for _, t := range e.tags {
+		for _, v := range t.expr.Variables() {
+			if !inputs[v] && !e.Has(v) {
+				return t.Tag, v, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// Has reports whether tag is a virtual tag of the engine
+func (e *VirtualTagEngine) Has(tag string) bool {
+	for _, t := range e.tags {
+		if t.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate records the registers of a read cycle as inputs and recalculates the virtual
+// tags that depend on them, directly or through other virtual tags. It returns the
+// recalculated tags as registers. The quality of a virtual tag is the worst quality of
+// its inputs; when an input is bad or the expression fails the last value is kept.
+// Virtual tags whose inputs were never read are not calculated.
+func (e *VirtualTagEngine) Evaluate(groups [][]DeviceRegister) []DeviceRegister {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	updated := make(map[string]bool)
+	for _, group := range groups {
+		for _, reg := range group {
+			decoded, _ := reg.DecodeValue()
+			e.inputs[reg.Tag] = virtualInput{
+				value:       decoded.Float64,
+				quality:     decoded.Quality,
+				reason:      decoded.QualityReason,
+				sourceTime:  reg.SourceTime,
+				receiveTime: reg.ReceiveTime,
+			}
+			updated[reg.Tag] = true
+		}
+	}
+
+	var result []DeviceRegister
+	for _, tag := range e.tags {
+		vars := tag.expr.Variables()
+		affected, complete := false, true
+		worst, worstTag := virtualInput{quality: QualityGood}, ""
+		for _, v := range vars {
+			in, ok := e.inputs[v]
+			if !ok {
+				complete = false
+				break
+			}
+			affected = affected || updated[v]
+			if in.quality < worst.quality {
+				worst.quality, worst.reason, worstTag = in.quality, in.reason, v
+			}
+			if in.sourceTime.After(worst.sourceTime) {
+				worst.sourceTime = in.sourceTime
+			}
+			if in.receiveTime.After(worst.receiveTime) {
+				worst.receiveTime = in.receiveTime
+			}
+		}
+		if !complete || len(vars) > 0 && !affected || len(vars) == 0 && tag.last != nil {
+			continue
+		}
+		if len(vars) == 0 {
+			// Constant expressions are calculated once
+			worst.sourceTime = time.Now()
+			worst.receiveTime = worst.sourceTime
+		}
+
+		reg := tag.register()
+		if worst.quality == QualityBad {
+			reg.setBad(worst.reason, 0, fmt.Sprintf("input %s: %s", worstTag, worst.reason), worst.receiveTime)
+		} else if value, err := tag.expr.Eval(e.lookup); err != nil {
+			reg.setBad(ReasonCalcError, 0, err.Error(), worst.receiveTime)
+		} else {
+			tag.last, tag.lastSource = &value, worst.sourceTime
+			reg.setGood(worst.sourceTime, worst.receiveTime)
+			reg.Quality, reg.QualityReason = worst.quality, worst.reason
+		}
+		if tag.last != nil {
+			binary.BigEndian.PutUint64(reg.Value, math.Float64bits(*tag.last))
+			reg.SourceTime = tag.lastSource
+		}
+
+		e.inputs[tag.Tag] = virtualInput{
+			value:       math.Float64frombits(binary.BigEndian.Uint64(reg.Value)),
+			quality:     reg.Quality,
+			reason:      reg.QualityReason,
+			sourceTime:  reg.SourceTime,
+			receiveTime: reg.ReceiveTime,
+		}
+		updated[tag.Tag] = true
+		result = append(result, reg)
+	}
+	return result
+}
+
+// lookup resolves an input value. Caller must hold the mutex.
+func (e *VirtualTagEngine) lookup(tag string) (float64, bool) {
+	in, ok := e.inputs[tag]
+	return in.value, ok
+}
+
+// register returns an empty float64 register for the virtual tag
+func (t *compiledVirtualTag) register() DeviceRegister {
+	return DeviceRegister{
+		UUID:            t.UUID,
+		Tag:             t.Tag,
+		Alias:           t.Alias,
+		ReadQuantity:    4,
+		DataType:        "float64",
+		Weight:          1,
+		Value:           make([]byte, 8),
+		Deadband:        t.Deadband,
+		DeadbandPercent: t.DeadbandPercent,
+	}
+}
@@ -0,0 +1,130 @@
+package modbus
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVirtualTagEngineOrdering(t *testing.T) {
+	_, err := NewVirtualTagEngine([]VirtualTag{
+		{Tag: "a", Expression: "b + 1"},
+		{Tag: "b", Expression: "c + 1"},
+		{Tag: "c", Expression: "a + 1"},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Errorf("expected cycle error a -> b -> c -> a, got %v", err)
+	}
+	if _, err := NewVirtualTagEngine([]VirtualTag{{Tag: "a", Expression: "a * 2"}}, nil); err == nil {
+		t.Errorf("expected error for a self-referencing tag")
+	}
+	if _, err := NewVirtualTagEngine([]VirtualTag{{Tag: "a", Expression: "1 +"}}, nil); err == nil {
+		t.Errorf("expected error for an invalid expression")
+	}
+
+	_, err = NewVirtualTagEngine([]VirtualTag{
+		{Tag: "power", Expression: "v * amps"},
+	}, []string{"v", "i"})
+	if err == nil || !strings.Contains(err.Error(), "unknown tag amps") {
+		t.Errorf("expected unknown tag amps, got %v", err)
+	}
+
+	// Declared out of order: total depends on power which depends on read tags
+	engine, err := NewVirtualTagEngine([]VirtualTag{
+		{Tag: "total", Expression: "power_a + power_b"},
+		{Tag: "power_a", Expression: "v * i_a"},
+		{Tag: "power_b", Expression: "v * i_b"},
+	}, []string{"v", "i_a", "i_b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	float := func(tag string, v float64) DeviceRegister {
+		reg := DeviceRegister{Tag: tag, DataType: "float32", Weight: 1, Value: make([]byte, 4)}
+		reg.Value, _ = reg.EncodeValue(v)
+		reg.setGood(time.Now(), time.Now())
+		return reg
+	}
+
+	// Incomplete inputs: nothing is calculated yet
+	if got := engine.Evaluate([][]DeviceRegister{{float("v", 230), float("i_a", 2)}}); len(got) != 1 || got[0].Tag != "power_a" {
+		t.Fatalf("expected only power_a calculated, got %d registers", len(got))
+	}
+
+	got := engine.Evaluate([][]DeviceRegister{{float("i_b", 3)}})
+	values := make(map[string]float64)
+	for _, reg := range got {
+		decoded, err := reg.DecodeValue()
+		if err != nil || decoded.Quality != QualityGood {
+			t.Fatalf("%s: decode %v, quality %s", reg.Tag, err, decoded.Quality)
+		}
+		values[reg.Tag] = decoded.Float64
+	}
+	if len(got) != 2 || values["power_b"] != 690 || values["total"] != 1150 {
+		t.Errorf("calculated %v, expected power_b 690 and total 1150", values)
+	}
+
+	// A bad input makes the dependent tags bad while keeping their last value
+	bad := float("v", 0)
+	bad.setBad(ReasonTimeout, 0, "timeout", time.Now())
+	got = engine.Evaluate([][]DeviceRegister{{bad}})
+	if len(got) != 3 {
+		t.Fatalf("expected all virtual tags recalculated, got %d", len(got))
+	}
+	for _, reg := range got {
+		decoded, _ := reg.DecodeValue()
+		if decoded.Quality != QualityBad || decoded.QualityReason != ReasonTimeout {
+			t.Errorf("%s: quality %s/%q, expected bad/timeout", reg.Tag, decoded.Quality, decoded.QualityReason)
+		}
+		if reg.Tag == "total" && decoded.Float64 != 1150 {
+			t.Errorf("total = %v, expected last value 1150 to be kept", decoded.Float64)
+		}
+	}
+}
+
+func TestRegisterManagerVirtualTags(t *testing.T) {
+	client := newMemoryClient()
+	client.setRegisters(1, 0, 2300, 25, 0x0004)
+	manager := NewRegisterManager(client, 10)
+	registers := []DeviceRegister{
+		{Tag: "voltage", SlaverId: 1, Function: 3, ReadAddress: 0, ReadQuantity: 1, DataType: "uint16", Weight: 0.1},
+		{Tag: "current", SlaverId: 1, Function: 3, ReadAddress: 1, ReadQuantity: 1, DataType: "uint16", Weight: 0.1},
+		{Tag: "alarms", SlaverId: 1, Function: 3, ReadAddress: 2, ReadQuantity: 1, DataType: "uint16", Weight: 1},
+	}
+	if err := manager.LoadRegisters(registers); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetVirtualTags([]VirtualTag{{Tag: "voltage", Expression: "1"}}); err == nil {
+		t.Errorf("expected error for a virtual tag shadowing a register")
+	}
+	if err := manager.SetVirtualTags([]VirtualTag{{Tag: "power", Expression: "voltage * amps"}}); err == nil ||
+		!strings.Contains(err.Error(), "amps") {
+		t.Errorf("expected error naming the unknown tag amps, got %v", err)
+	}
+	err := manager.SetVirtualTags([]VirtualTag{
+		{Tag: "power", Expression: "voltage * current"},
+		{Tag: "overload", Expression: "power > 500 || alarms % 8 >= 4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Registers used by virtual tags cannot be unloaded
+	if err := manager.LoadRegisters(registers[:1]); err == nil || !strings.Contains(err.Error(), "current") {
+		t.Errorf("expected error for removing a register used by power, got %v", err)
+	}
+	cache := NewTagCache(0)
+	manager.SetTagCache(cache)
+
+	if errs := manager.ReadGroupedData(); len(errs) > 0 {
+		t.Fatalf("ReadGroupedData failed: %v", errs)
+	}
+	power, ok := cache.Get("power")
+	if !ok || !FuzzyEqual(power.Value.Float64, 575) || power.Quality != QualityGood {
+		t.Errorf("power = %+v, expected 575 good", power.Value)
+	}
+	if overload, _ := cache.Get("overload"); overload.Value.Float64 != 1 {
+		t.Errorf("overload = %v, expected 1", overload.Value.Float64)
+	}
+	if err := manager.WriteTag("power", 1); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("expected read-only error writing a virtual tag, got %v", err)
+	}
+}
@@ -686,7 +686,9 @@ func (h *ModbusHandler) sendAndReceive(slaveID uint8, reqPDU []byte) ([]byte, er
 			exceptionCode = respPDU[1] // Exception code is in the second byte
 		}
 		exceptionMsg := getExceptionMessage(exceptionCode) // Assumes getExceptionMessage exists
-		err = fmt.Errorf("modbus: received exception response (slave %d): code 0x%02X - %s", slaveID, exceptionCode, exceptionMsg)
+		// Wrap a ModbusError so callers can tell exceptions from communication errors
+		err = fmt.Errorf("modbus: received exception response (slave %d): code 0x%02X - %s: %w", slaveID, exceptionCode, exceptionMsg,
+			&ModbusError{FunctionCode: respPDU[0] & 0x7F, ExceptionCode: exceptionCode})
 		if h.logger != nil {
 			if h.mode == "TCP" {
 				RemoteAddr := h.tcpTransporter.conn.RemoteAddr().String()
@@ -5,6 +5,8 @@
 package modbus
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -148,6 +150,11 @@ type tcpTransporter struct {
 	Timeout time.Duration
 	// Idle timeout to close the connection
 	IdleTimeout time.Duration
+	// TLS configuration, plain TCP if nil
+	TLSConfig *tls.Config
+	// Opens the connection, net.Dialer if nil. Address may be unix:///path
+	// for Unix domain sockets
+	Dial DialFunc
 	// Transmission logger
 	Logger io.WriteCloser
 
@@ -184,19 +191,34 @@ func (mb *tcpTransporter) Send(aduRequest []byte) (aduResponse []byte, err error
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
 
+	return mb.send(aduRequest, mb.Timeout, true)
+}
+
+// sendTimeout sends data to server with a timeout for this request only, leaving
+// Timeout unchanged. Without awaitResponse it returns once the request is written.
+func (mb *tcpTransporter) sendTimeout(aduRequest []byte, timeout time.Duration, awaitResponse bool) (aduResponse []byte, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return mb.send(aduRequest, timeout, awaitResponse)
+}
+
+// send writes the request and reads the response with the given connect, write and
+// read timeout; mb.mu must be held
+func (mb *tcpTransporter) send(aduRequest []byte, timeout time.Duration, awaitResponse bool) (aduResponse []byte, err error) {
 	// Establish a new connection if not connected
-	if err = mb.connect(); err != nil {
+	if err = mb.connectTimeout(timeout); err != nil {
 		return
 	}
 	// Set timer to close when idle
 	mb.lastActivity = time.Now()
 	mb.startCloseTimer()
 	// Set write and read timeout
-	var timeout time.Time
-	if mb.Timeout > 0 {
-		timeout = mb.lastActivity.Add(mb.Timeout)
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = mb.lastActivity.Add(timeout)
 	}
-	if err = mb.conn.SetDeadline(timeout); err != nil {
+	if err = mb.conn.SetDeadline(deadline); err != nil {
 		return
 	}
 	// Send data
@@ -204,6 +226,9 @@ func (mb *tcpTransporter) Send(aduRequest []byte) (aduResponse []byte, err error
 	if _, err = mb.conn.Write(aduRequest); err != nil {
 		return
 	}
+	if !awaitResponse {
+		return
+	}
 	// Read header first
 	var data [tcpMaxLength]byte
 	if _, err = io.ReadFull(mb.conn, data[:tcpHeaderSize]); err != nil {
@@ -241,12 +266,40 @@ func (mb *tcpTransporter) Connect() error {
 }
 
 func (mb *tcpTransporter) connect() error {
+	return mb.connectTimeout(mb.Timeout)
+}
+
+// connectTimeout connects if not connected, giving up after timeout if positive
+func (mb *tcpTransporter) connectTimeout(timeout time.Duration) error {
 	if mb.conn == nil {
-		dialer := net.Dialer{Timeout: mb.Timeout}
-		conn, err := dialer.Dial("tcp", mb.Address)
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		network, address := splitNetworkAddress(mb.Address)
+		dial := mb.Dial
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		conn, err := dial(ctx, network, address)
 		if err != nil {
 			return err
 		}
+		if mb.TLSConfig != nil {
+			config := mb.TLSConfig
+			if config.ServerName == "" && network == "tcp" {
+				config = config.Clone()
+				config.ServerName, _, _ = net.SplitHostPort(address)
+			}
+			tlsConn := tls.Client(conn, config)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return err
+			}
+			conn = tlsConn
+		}
 		mb.conn = conn
 	}
 	return nil